@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var bo = &metal3ctl.BundleOptions{}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Manages offline install bundles",
+	Long: LongDesc(`
+		Manages offline install bundles.`),
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Creates an offline install bundle",
+	Long: LongDesc(`
+		Creates an offline install bundle.
+
+		The bundle contains the rendered baremetal-operator manifests, the local cluster-api provider
+		repository, the metal3ctl config file and optionally the image tarballs, so a management cluster
+		can be initialized with metal3ctl init --bundle without resolving any kustomize or URL source;
+		the bundled images are loaded with the container tool selected by metal3ctl init --container-tool.
+
+		Variables whose name looks like the name of a secret (e.g. containing PASSWORD, TOKEN or KEY)
+		are not written into the bundle: they must be set as environment variables when the bundle is
+		installed.`),
+
+	Example: Examples(`
+		# Creates a bundle with all the provider manifests.
+		metal3ctl bundle create --output metal3ctl-bundle.tar.gz
+
+		# Creates a bundle including the image tarballs.
+		metal3ctl bundle create --output metal3ctl-bundle.tar.gz --include-images`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runBundleCreate()
+	},
+}
+
+func init() {
+	bundleCreateCmd.Flags().StringVarP(&bo.OutputPath, "output", "o", "metal3ctl-bundle.tar.gz", "Path of the bundle archive")
+	bundleCreateCmd.Flags().BoolVarP(&bo.IncludeImages, "include-images", "", false, "Adds the tarballs of the images required by the management cluster to the bundle")
+	bundleCreateCmd.Flags().StringVar(&bo.ContainerTool, "container-tool", "docker", "The container tool used to pull and save images")
+	bundleCmd.AddCommand(bundleCreateCmd)
+	RootCmd.AddCommand(bundleCmd)
+}
+
+func runBundleCreate() error {
+	var err error
+
+	metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
+	}
+
+	configData, err := ioutil.ReadFile(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the config file")
+	}
+
+	err = metal3ctl.CreateBundle(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, bo)
+	if err != nil {
+		return errors.Wrapf(err, "error while creating the bundle")
+	}
+	return nil
+}
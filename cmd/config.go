@@ -0,0 +1,462 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manages the metal3ctl configuration file",
+	Long: LongDesc(`
+		Manages the metal3ctl configuration file.`),
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the metal3ctl configuration file",
+	Long: LongDesc(`
+		Validates the metal3ctl configuration file without connecting to the management cluster.
+
+		Unknown fields are reported together with the closest known field, and all the errors
+		found are listed with the path and the line of the field they refer to.`),
+
+	Example: Examples(`
+		# Validates the metal3ctl configuration file.
+		metal3ctl config validate --config metal3ctl.yaml`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigValidate()
+	},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrites the metal3ctl configuration file to the latest format version",
+	Long: LongDesc(`
+		Rewrites the metal3ctl configuration file to the latest format version, preserving comments.
+
+		Configuration files in an older format, including the legacy format without apiVersion and
+		kind, are still accepted by all the commands, being converted on load.`),
+
+	Example: Examples(`
+		# Rewrites the metal3ctl configuration file in place.
+		metal3ctl config migrate --config metal3ctl.yaml
+
+		# Prints the migrated configuration file.
+		metal3ctl config migrate --config metal3ctl.yaml --output -`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigMigrate()
+	},
+}
+
+var configUseContextCmd = &cobra.Command{
+	Use:   "use-context NAME",
+	Short: "Sets the current context in the metal3ctl configuration file",
+	Long: LongDesc(`
+		Sets the current context in the metal3ctl configuration file.
+
+		A context defines the name, the kubeconfig, the kube context, the artifacts path and the variables
+		of a mgmt cluster, so the providers defined in the configuration file can be shared by several
+		mgmt clusters. The current context is used by all the commands, unless --context is set.`),
+
+	Example: Examples(`
+		# Uses the targetcluster context by default.
+		metal3ctl config use-context targetcluster --config metal3ctl.yaml`),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigUseContext(args[0])
+	},
+}
+
+var configGetContextsCmd = &cobra.Command{
+	Use:   "get-contexts",
+	Short: "Lists the contexts defined in the metal3ctl configuration file",
+	Long: LongDesc(`
+		Lists the contexts defined in the metal3ctl configuration file; the selected context is marked with *.`),
+
+	Example: Examples(`
+		# Lists the contexts defined in the metal3ctl configuration file.
+		metal3ctl config get-contexts --config metal3ctl.yaml`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigGetContexts()
+	},
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Prints the metal3ctl configuration file",
+	Long: LongDesc(`
+		Prints the metal3ctl configuration file, converted to the latest format version; the values of
+		the variables whose name suggests a secret, e.g. IRONIC_PASSWORD, are redacted.
+
+		With --merged, the effective configuration is printed instead, that is the configuration after
+		merging the included config files, applying the selected context and the defaults, and
+		expanding the variables.`),
+
+	Example: Examples(`
+		# Prints the effective configuration used by the other commands.
+		metal3ctl config view --merged --config metal3ctl.yaml`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigView()
+	},
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generates a metal3ctl configuration file from the local source trees",
+	Long: LongDesc(`
+		Generates a metal3ctl configuration file for a dev setup, installing cluster-api,
+		cluster-api-provider-metal3 and the baremetal-operator from their local source trees; the
+		configuration is examples/metal3ctl.dev.conf, with the paths and the versions of the local
+		checkouts and a single context for the management cluster.
+
+		The local checkouts are searched in the src folder of each GOPATH entry, or in the folders
+		set with --root, either GOPATH like or containing the checkouts; provider versions are read
+		from the git tags of the checkouts, or from their clusterctl metadata.yaml.
+
+		The configuration file is written to --output, or to --config if not set; existing files are
+		overwritten only with --force.`),
+
+	Example: Examples(`
+		# Generates metal3ctl.yaml from the checkouts in the GOPATH.
+		metal3ctl config init --config metal3ctl.yaml
+
+		# Generates metal3ctl.yaml from the checkouts in ~/src, for the targetcluster mgmt cluster.
+		metal3ctl config init --root ~/src --name targetcluster \
+			--kubeconfig ~/.kube/config-target-cluster --output metal3ctl.yaml
+
+		# Prints the generated configuration file.
+		metal3ctl config init --output -`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigInit()
+	},
+}
+
+var ci = &metal3ctl.ScaffoldConfigOptions{}
+
+var (
+	configInitOutput string
+	configInitForce  bool
+)
+
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Generates a metal3ctl configuration file from an existing management cluster",
+	Long: LongDesc(`
+		Generates a metal3ctl configuration file from a management cluster not initialized with metal3ctl,
+		e.g. set up by hand or with plain clusterctl, so it can be managed with metal3ctl.
+
+		The cluster-api providers are read from the clusterctl provider inventory, using the released
+		manifests of the installed versions as sources; the baremetal-operator is read from its deployment,
+		together with the Ironic mode, and all the instances recorded by metal3ctl are exported. The images running in the management cluster are pinned with image
+		overrides, so the exported configuration installs exactly what is running.
+
+		The configuration file is written to --output, or to --config if not set; existing files are
+		overwritten only with --force.`),
+
+	Example: Examples(`
+		# Exports the configuration of the management cluster in the current kube context.
+		metal3ctl config export --output metal3ctl.yaml
+
+		# Exports the configuration of the management cluster with the given kubeconfig.
+		metal3ctl config export --kubeconfig ~/.kube/config-lab --name lab --output metal3ctl.yaml`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigExport()
+	},
+}
+
+var ce = &metal3ctl.ExportConfigOptions{}
+
+var (
+	configExportOutput string
+	configExportForce  bool
+)
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of the metal3ctl configuration file",
+	Long: LongDesc(`
+		Prints the JSON Schema of the metal3ctl configuration file, generated from the configuration types.
+
+		The same schema is used by metal3ctl for validating configuration files, so editors supporting
+		JSON Schema (e.g. with the YAML language server) and CI linters check configuration files the same
+		way the CLI does; the checks involving several fields are run only by metal3ctl config validate.`),
+
+	Example: Examples(`
+		# Writes the JSON Schema to a file, e.g. for configuring an editor.
+		metal3ctl config schema --output metal3ctl.schema.json`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runConfigSchema()
+	},
+}
+
+var configSchemaOutput string
+
+var configViewMerged bool
+
+var configMigrateOutput string
+
+func init() {
+	configMigrateCmd.Flags().StringVarP(&configMigrateOutput, "output", "o", "", "The file the migrated configuration is written to, or - for stdout (default is the configuration file itself)")
+	configInitCmd.Flags().StringSliceVarP(&ci.Roots, "root", "", nil, "The folders containing the local checkouts, can be repeated (default is the src folder of each GOPATH entry)")
+	configInitCmd.Flags().StringVarP(&ci.Name, "name", "", "minikube", "The name of the mgmt cluster")
+	configInitCmd.Flags().StringVarP(&ci.Kubeconfig, "kubeconfig", "", "${HOME}/.kube/config", "The kubeconfig of the mgmt cluster")
+	configInitCmd.Flags().StringVarP(&ci.ArtifactsPath, "artifacts-path", "", "", "The folder the generated artifacts are stored in (default is /tmp/_artifacts/<name>/)")
+	configInitCmd.Flags().StringVarP(&configInitOutput, "output", "o", "", "The file the configuration is written to, or - for stdout (default is the configuration file set with --config)")
+	configInitCmd.Flags().BoolVarP(&configInitForce, "force", "f", false, "Overwrites the output file if it exists")
+	configCmd.AddCommand(configInitCmd)
+	configExportCmd.Flags().StringVarP(&ce.Kubeconfig, "kubeconfig", "", "", "The kubeconfig of the mgmt cluster (default is the kubeconfig in the standard locations)")
+	configExportCmd.Flags().StringVarP(&ce.Name, "name", "", "", "The name of the mgmt cluster (default is the current context of the kubeconfig)")
+	configExportCmd.Flags().StringVarP(&ce.ArtifactsPath, "artifacts-path", "", "", "The folder the generated artifacts are stored in (default is /tmp/_artifacts/<name>/)")
+	configExportCmd.Flags().StringVarP(&configExportOutput, "output", "o", "", "The file the configuration is written to, or - for stdout (default is the configuration file set with --config)")
+	configExportCmd.Flags().BoolVarP(&configExportForce, "force", "f", false, "Overwrites the output file if it exists")
+	configCmd.AddCommand(configExportCmd)
+	configSchemaCmd.Flags().StringVarP(&configSchemaOutput, "output", "o", "-", "The file the JSON Schema is written to, or - for stdout")
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configUseContextCmd)
+	configCmd.AddCommand(configGetContextsCmd)
+	configViewCmd.Flags().BoolVarP(&configViewMerged, "merged", "", false, "Prints the effective configuration, after includes, context, defaults and variable expansion")
+	configCmd.AddCommand(configViewCmd)
+	RootCmd.AddCommand(configCmd)
+}
+
+func runConfigInit() error {
+	output, err := configOutputPath(configInitOutput, configInitForce)
+	if err != nil {
+		return err
+	}
+	data, err := metal3ctl.ScaffoldConfig(context.Background(), ci)
+	if err != nil {
+		return err
+	}
+	return writeConfigOutput(data, output)
+}
+
+func runConfigExport() error {
+	output, err := configOutputPath(configExportOutput, configExportForce)
+	if err != nil {
+		return err
+	}
+	data, err := metal3ctl.ExportConfig(context.Background(), ce)
+	if err != nil {
+		return errors.Wrap(err, "error exporting the config of the mgmt cluster")
+	}
+	return writeConfigOutput(data, output)
+}
+
+// configOutputPath returns the file a generated configuration is written to, that is output or, if empty, the
+// configuration file set with --config; existing files are overwritten only if force is set.
+func configOutputPath(output string, force bool) (string, error) {
+	if output == "" {
+		output = metal3ctlCfgFile
+	}
+	if output == "" {
+		return "", errors.New("please set the file the configuration is written to with --output or --config")
+	}
+	if output != "-" && !force {
+		if _, err := os.Stat(output); err == nil {
+			return "", errors.Errorf("the file %s already exists, please use --force to overwrite it", output)
+		}
+	}
+	return output, nil
+}
+
+// writeConfigOutput writes a generated configuration to the given file, or to stdout if it is -.
+func writeConfigOutput(data []byte, output string) error {
+	if output == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		return errors.Wrapf(err, "error writing the config file")
+	}
+	fmt.Printf("The metal3ctl configuration file has been written to %s\n", output)
+	return nil
+}
+
+func runConfigSchema() error {
+	data, err := config.SchemaJSON()
+	if err != nil {
+		return errors.Wrap(err, "failed to generate the JSON Schema")
+	}
+	if configSchemaOutput == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(configSchemaOutput, data, 0644); err != nil {
+		return errors.Wrapf(err, "error writing the JSON Schema")
+	}
+	return nil
+}
+
+func runConfigValidate() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if _, err := config.LoadMetal3CtlConfig(context.Background(), config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}); err != nil {
+		return err
+	}
+	fmt.Printf("The metal3ctl configuration file %s is valid\n", metal3ctlCfgFile)
+	return nil
+}
+
+func runConfigMigrate() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	migrated, err := config.MigrateConfig(configData)
+	if err != nil {
+		return errors.Wrapf(err, "error migrating the config file")
+	}
+	switch {
+	case configMigrateOutput == "-":
+		_, err := os.Stdout.Write(migrated)
+		return err
+	case configMigrateOutput == "" && bytes.Equal(migrated, configData):
+		fmt.Printf("The metal3ctl configuration file %s is already at version %s\n", metal3ctlCfgFile, config.GroupVersion)
+		return nil
+	case configMigrateOutput == "":
+		configMigrateOutput = metal3ctlCfgFile
+	}
+	if err := ioutil.WriteFile(configMigrateOutput, migrated, 0644); err != nil {
+		return errors.Wrapf(err, "error writing the migrated config file")
+	}
+	fmt.Printf("The metal3ctl configuration file has been migrated to version %s and written to %s\n", config.GroupVersion, configMigrateOutput)
+	return nil
+}
+
+func runConfigUseContext(name string) error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	// the context is selected while loading the config, so contexts defined in included files are found too
+	if _, err := config.LoadMetal3CtlConfig(context.Background(), config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: name, ConfigPath: metal3ctlCfgFile}); err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+
+	updated, err := config.SetCurrentContext(configData, name)
+	if err != nil {
+		return errors.Wrapf(err, "error setting the current context")
+	}
+	info, err := os.Stat(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the config file")
+	}
+	if err := ioutil.WriteFile(metal3ctlCfgFile, updated, info.Mode().Perm()); err != nil {
+		return errors.Wrapf(err, "error writing the config file")
+	}
+	fmt.Printf("Switched to context %q\n", name)
+	return nil
+}
+
+func runConfigGetContexts() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	conf, err := config.LoadMetal3CtlConfig(context.Background(), config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile})
+	if err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tKUBECONFIG\tKUBE CONTEXT\tARTIFACTS PATH")
+	for _, c := range conf.Contexts {
+		current := ""
+		if c.Name == conf.CurrentContext {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", current, c.Name, c.Kubeconfig, c.KubeContext, c.ArtifactsPath)
+	}
+	return w.Flush()
+}
+
+func runConfigView() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if !configViewMerged {
+		data, err := config.MigrateConfig(configData)
+		if err != nil {
+			return errors.Wrapf(err, "error converting the config file")
+		}
+		data, err = config.RedactConfigFile(data)
+		if err != nil {
+			return errors.Wrapf(err, "error redacting the config file")
+		}
+		_, err = os.Stdout.Write([]byte(config.Redact(string(data))))
+		return err
+	}
+
+	conf, err := config.LoadMetal3CtlConfig(context.Background(), config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile})
+	if err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	data, err := yaml.Marshal(conf.Redacted())
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the config")
+	}
+	_, err = os.Stdout.Write([]byte(config.Redact(string(data))))
+	return err
+}
+
+// readConfigFile reads the metal3ctl configuration file.
+func readConfigFile() ([]byte, error) {
+	var err error
+	metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
+	if err != nil {
+		return nil, errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
+	}
+
+	configData, err := ioutil.ReadFile(metal3ctlCfgFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the config file")
+	}
+	return configData, nil
+}
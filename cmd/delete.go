@@ -78,7 +78,7 @@ func runDelete() error {
 		return errors.Wrapf(err, "error reading the config file")
 	}
 
-	err = metal3ctl.DeleteFromMgmtCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData}, dd)
+	err = metal3ctl.DeleteFromMgmtCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, dd)
 	if err != nil {
 		return errors.Wrapf(err, "error while deleting management cluster")
 	}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var imo = &metal3ctl.MirrorImagesOptions{}
+
+var imagesCmd = &cobra.Command{
+	Use:   "images",
+	Short: "Manages the container images required by the management cluster",
+	Long: LongDesc(`
+		Manages the container images required by the management cluster.`),
+}
+
+var imagesMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Mirrors the container images required by the management cluster to a private registry",
+	Long: LongDesc(`
+		Mirrors the container images required by the management cluster to a private registry.
+
+		All the images in the baremetal-operator and cluster-api provider manifests, plus the images
+		listed in the metal3ctl configuration file, are pulled, retagged and pushed to the given registry
+		using a container tool; a report mapping source to mirrored images is written at the end.
+
+		Set registryMirror in the metal3ctl configuration file to the same registry in order to
+		initialize the management cluster using the mirrored images.`),
+
+	Example: Examples(`
+		# Mirrors all the images to a private registry.
+		metal3ctl images mirror --to my-registry.lab:5000/metal3
+
+		# Mirrors all the images to a local registry:2 instance using podman.
+		metal3ctl images mirror --to localhost:5000 --container-tool podman --insecure
+
+		# Mirrors the images of all the provider versions defined in the config, e.g. for upgrading later.
+		metal3ctl images mirror --to my-registry.lab:5000/metal3 --all-versions
+
+		# Writes the mapping report without pulling or pushing any image.
+		metal3ctl images mirror --to my-registry.lab:5000 --dry-run`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runImagesMirror()
+	},
+}
+
+func init() {
+	imagesMirrorCmd.Flags().StringVar(&imo.ToRegistry, "to", "", "The registry, optionally followed by a path prefix, where the images should be pushed")
+	imagesMirrorCmd.Flags().StringVar(&imo.ContainerTool, "container-tool", "docker", "The container tool used to pull, tag and push images")
+	imagesMirrorCmd.Flags().StringVar(&imo.ReportPath, "report", "", "Path of the image mapping report (default is image-mirror-report.yaml in the artifacts folder)")
+	imagesMirrorCmd.Flags().BoolVarP(&imo.Insecure, "insecure", "", false, "Pushes images without TLS verification; supported only with podman, with docker the registry must be configured as an insecure registry of the daemon")
+	imagesMirrorCmd.Flags().BoolVarP(&imo.AllVersions, "all-versions", "", false, "Mirrors the images of all the provider versions defined in the config, not only of the versions installed by default")
+	imagesMirrorCmd.Flags().BoolVarP(&imo.DryRun, "dry-run", "", false, "Writes the image mapping report without pulling or pushing any image")
+	imagesCmd.AddCommand(imagesMirrorCmd)
+	RootCmd.AddCommand(imagesCmd)
+}
+
+func runImagesMirror() error {
+	var err error
+	if imo.ToRegistry == "" {
+		return errors.New("please specify a target registry using the --to flag")
+	}
+
+	metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
+	}
+
+	configData, err := ioutil.ReadFile(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the config file")
+	}
+
+	report, err := metal3ctl.MirrorImages(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, imo)
+	if err != nil {
+		return errors.Wrapf(err, "error while mirroring images")
+	}
+	for _, entry := range report.Images {
+		fmt.Printf("%s -> %s\n", entry.Source, entry.Target)
+	}
+	fmt.Printf("Image mapping report written to %s\n", imo.ReportPath)
+	return nil
+}
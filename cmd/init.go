@@ -17,6 +17,7 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
 
@@ -45,7 +46,29 @@ var initCmd = &cobra.Command{
 		metal3ctl init  --skip-bmo
 
 		# Skips the cluster-api component initialization.
-		metal3ctl init  --skip-capi`),
+		metal3ctl init  --skip-capi
+
+		# Initialize a management cluster installing a specific baremetal-operator version.
+		metal3ctl init --bmo-version v0.2.0
+
+		# Initialize a management cluster installing specific cluster-api provider versions; providers are selected
+		# by name, or by label when names are ambiguous (e.g. bootstrap-kubeadm).
+		metal3ctl init --provider-version metal3=v0.3.0 --provider-version bootstrap-kubeadm=v0.3.3
+
+		# Initialize a management cluster installing the providers in a custom namespace, watching only
+		# the objects in a tenant namespace; namespaces defined in the config take precedence.
+		metal3ctl init --target-namespace metal3-tenant-a --watching-namespace tenant-a
+
+		# Initialize a management cluster from a bundle created with metal3ctl bundle create, extracting it
+		# into the bundle folder of a local artifacts path.
+		metal3ctl init --bundle metal3ctl-bundle.tar.gz --artifacts-path $HOME/.metal3/artifacts
+
+		# Initialize a management cluster from a bundle, loading the bundled images with podman.
+		metal3ctl init --bundle metal3ctl-bundle.tar.gz --artifacts-path $HOME/.metal3/artifacts --container-tool podman
+
+		# Writes the processed manifests and a kustomization.yaml to a folder, without applying them
+		# (e.g. for a GitOps pipeline).
+		metal3ctl init --output-dir ./out`),
 	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runInit()
@@ -54,37 +77,57 @@ var initCmd = &cobra.Command{
 
 func init() {
 	initCmd.Flags().BoolVarP(&io.ListImages, "list-images", "", false, "Lists the container images required for initializing the management cluster (without actually installing the providers)")
+	initCmd.Flags().BoolVarP(&io.AllVersions, "all-versions", "", false, "Lists the container images of all the provider versions defined in the config, not only of the versions to be installed (used with --list-images)")
 	initCmd.Flags().BoolVarP(&io.SkipBMO, "skip-bmo", "", false, "Skips the baremetal-operator initialization on the management cluster)")
 	initCmd.Flags().BoolVarP(&io.SkipCAPI, "skip-capi", "", false, "Skips the cluster-api initialization on the management cluster)")
+	initCmd.Flags().StringVar(&io.BMOVersion, "bmo-version", "", "The baremetal-operator version to be installed, as listed in bmoProvider.versions (default is the version marked as default, or the highest one)")
+	initCmd.Flags().StringToStringVar(&io.ProviderVersions, "provider-version", nil, "The cluster-api provider versions to be installed, as listed in capiProviders versions, e.g. metal3=v0.3.0 (default is the version marked as default, or the highest one)")
+	initCmd.Flags().StringVar(&io.TargetNamespace, "target-namespace", "", "The namespace where the providers should be installed, for the providers not defining targetNamespace in the config (default is the namespace defined in the provider manifest)")
+	initCmd.Flags().StringVar(&io.WatchingNamespace, "watching-namespace", "", "The namespace the providers should watch, for the providers not defining watchingNamespace in the config (default is all namespaces)")
+	initCmd.Flags().StringVar(&io.OutputDir, "output-dir", "", "Writes the processed manifests and a kustomization.yaml to the given folder instead of applying them to the management cluster; Secrets are written into separate *-secrets.yaml files readable only by the owner, and the Ironic credentials found there are reused; the NN-*.yaml files of a previous run which are not rendered again are removed")
+	initCmd.Flags().StringVar(&io.Bundle, "bundle", "", "Path to a bundle created with metal3ctl bundle create; the metal3ctl config file is read from the bundle")
+	initCmd.Flags().StringVar(&io.ArtifactsPath, "artifacts-path", "", "The local artifacts path the bundle is extracted into, under its bundle folder; the artifacts path of the bundled config is ignored (required with --bundle)")
+	initCmd.Flags().StringVar(&io.ContainerTool, "container-tool", "docker", "The container tool used to load the image tarballs of the bundle (used with --bundle)")
+	initCmd.Flags().BoolVarP(&io.SkipImageLoad, "skip-image-load", "", false, "Skips loading the image tarballs of the bundle (used with --bundle)")
 	RootCmd.AddCommand(initCmd)
 }
 
 func runInit() error {
 	var err error
-
-	metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
-	if err != nil {
-		return errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
-	}
-
-	configData, err := ioutil.ReadFile(metal3ctlCfgFile)
-	if err != nil {
-		return errors.Wrapf(err, "error reading the config file")
+	var configData []byte
+
+	if io.Bundle != "" {
+		if io.ArtifactsPath == "" {
+			return errors.New("--artifacts-path is required with --bundle")
+		}
+		metal3ctlCfgFile, configData, err = metal3ctl.ImportBundle(io.Bundle, io)
+		if err != nil {
+			return errors.Wrapf(err, "error importing the bundle")
+		}
+	} else {
+		metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
+		if err != nil {
+			return errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
+		}
+
+		configData, err = ioutil.ReadFile(metal3ctlCfgFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading the config file")
+		}
 	}
 
 	if io.ListImages {
-		// TODO
-		// images, err := cctlClient.InitImages(options)
-		// if err != nil {
-		// 	return err
-		// }
-		// for _, i := range images {
-		// 	fmt.Println(i)
-		// }
-		// return nil
+		images, err := metal3ctl.InitImages(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, io)
+		if err != nil {
+			return err
+		}
+		for _, i := range images {
+			fmt.Println(i)
+		}
+		return nil
 	}
 
-	err = metal3ctl.InitMgmtCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData}, io)
+	err = metal3ctl.InitMgmtCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, io)
 	if err != nil {
 		return errors.Wrapf(err, "error while initializing management cluster")
 	}
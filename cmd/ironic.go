@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var rco = &metal3ctl.RotateCredentialsOptions{}
+
+var ironicCmd = &cobra.Command{
+	Use:   "ironic",
+	Short: "Manages the Ironic instance used by the baremetal-operator",
+	Long: LongDesc(`
+		Manages the Ironic instance used by the baremetal-operator.`),
+}
+
+var ironicRotateCredentialsCmd = &cobra.Command{
+	Use:   "rotate-credentials",
+	Short: "Renews the Ironic TLS certificates and basic-auth credentials",
+	Long: LongDesc(`
+		Renews the Ironic TLS certificates and basic-auth credentials.
+
+		When bmoProvider.ironic.tls or bmoProvider.ironic.basicAuth are set in the metal3ctl configuration
+		file, metal3ctl init generates a self-signed CA, the server certificates and random credentials
+		for the Ironic endpoints and stores them as Secrets in the baremetal-operator namespace.
+
+		This command generates new certificates and credentials, updates the Secrets and restarts the
+		baremetal-operator and Ironic pods. The server certificates are signed with the existing CA,
+		unless --ca is set.`),
+
+	Example: Examples(`
+		# Renews the Ironic server certificates and basic-auth credentials.
+		metal3ctl ironic rotate-credentials
+
+		# Renews the Ironic CA too.
+		metal3ctl ironic rotate-credentials --ca`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runIronicRotateCredentials()
+	},
+}
+
+func init() {
+	ironicRotateCredentialsCmd.Flags().BoolVarP(&rco.RotateCA, "ca", "", false, "Generates a new CA too; clients trusting the current CA should be updated")
+	ironicCmd.AddCommand(ironicRotateCredentialsCmd)
+	RootCmd.AddCommand(ironicCmd)
+}
+
+func runIronicRotateCredentials() error {
+	var err error
+	metal3ctlCfgFile, err = filepath.Abs(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Errorf("error converting %s to an absolute path", metal3ctlCfgFile)
+	}
+
+	configData, err := ioutil.ReadFile(metal3ctlCfgFile)
+	if err != nil {
+		return errors.Wrapf(err, "error reading the config file")
+	}
+
+	if err := metal3ctl.RotateIronicCredentials(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, rco); err != nil {
+		return errors.Wrapf(err, "error while rotating the Ironic credentials")
+	}
+	return nil
+}
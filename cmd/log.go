@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/go-logr/logr"
+)
+
+// redactingLogger wraps a logger and redacts the values of the secret variables in the messages, in the values and
+// in the errors, so the secrets never end up in the log output.
+type redactingLogger struct {
+	logr.Logger
+}
+
+var _ logr.Logger = redactingLogger{}
+
+func newRedactingLogger(log logr.Logger) logr.Logger {
+	return redactingLogger{Logger: log}
+}
+
+func (l redactingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.Logger.Info(config.Redact(msg), redactValues(keysAndValues)...)
+}
+
+func (l redactingLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err != nil {
+		err = errors.New(config.Redact(err.Error()))
+	}
+	l.Logger.Error(err, config.Redact(msg), redactValues(keysAndValues)...)
+}
+
+func (l redactingLogger) V(level int) logr.InfoLogger {
+	return redactingInfoLogger{InfoLogger: l.Logger.V(level)}
+}
+
+func (l redactingLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return redactingLogger{Logger: l.Logger.WithValues(redactValues(keysAndValues)...)}
+}
+
+func (l redactingLogger) WithName(name string) logr.Logger {
+	return redactingLogger{Logger: l.Logger.WithName(name)}
+}
+
+// redactingInfoLogger is the redactingLogger for a verbosity level.
+type redactingInfoLogger struct {
+	logr.InfoLogger
+}
+
+func (l redactingInfoLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.InfoLogger.Info(config.Redact(msg), redactValues(keysAndValues)...)
+}
+
+// redactValues redacts the string, error and fmt.Stringer values of the key/value pairs; the keys are left untouched.
+func redactValues(keysAndValues []interface{}) []interface{} {
+	ret := make([]interface{}, len(keysAndValues))
+	for i, v := range keysAndValues {
+		if i%2 == 0 {
+			ret[i] = v
+			continue
+		}
+		switch value := v.(type) {
+		case string:
+			ret[i] = config.Redact(value)
+		case error:
+			ret[i] = config.Redact(value.Error())
+		case fmt.Stringer:
+			ret[i] = config.Redact(value.String())
+		default:
+			ret[i] = v
+		}
+	}
+	return ret
+}
@@ -79,7 +79,7 @@ func runMove() error {
 		return errors.Wrapf(err, "error reading the config file")
 	}
 
-	err = metal3ctl.MoveFromBootstrapToTargetCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData}, mo)
+	err = metal3ctl.MoveFromBootstrapToTargetCluster(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, mo)
 	if err != nil {
 		return errors.Wrapf(err, "error while moving")
 	}
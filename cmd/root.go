@@ -18,20 +18,24 @@ package cmd
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 
+	"github.com/Arvinderpal/metal3ctl/config"
 	"github.com/MakeNowJust/heredoc"
 	"github.com/spf13/cobra"
 	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
 )
 
 var metal3ctlCfgFile string
+var metal3ctlContext string
 
 var RootCmd = &cobra.Command{
-	Use:          "metal3ctl",
-	SilenceUsage: true,
-	Short:        "metal3ctl controls a management cluster for metal-3",
+	Use:           "metal3ctl",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	Short:         "metal3ctl controls a management cluster for metal-3",
 	Long: LongDesc(`
 		Get started with metal-3 using metal3ctl for initializing a management cluster by 
 		installing Cluster API providers, baremetal-operator and associated packages, and 
@@ -41,6 +45,8 @@ var RootCmd = &cobra.Command{
 
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
+		// errors are printed here, so the values of the secret variables are redacted
+		fmt.Fprintln(os.Stderr, "Error:", config.Redact(err.Error()))
 		//TODO: print error stack if log v>0
 		//TODO: print cmd help if validation error
 		os.Exit(1)
@@ -51,10 +57,11 @@ func init() {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	verbosity := flag.CommandLine.Int("v", 0, "number for the log level verbosity")
-	logf.SetLogger(logf.NewLogger(logf.WithThreshold(verbosity)))
+	logf.SetLogger(newRedactingLogger(logf.NewLogger(logf.WithThreshold(verbosity))))
 
 	RootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 	RootCmd.PersistentFlags().StringVar(&metal3ctlCfgFile, "config", "", "Path to the the metal3ctl config file (default is $HOME/.metal3/metal3ctl.yaml)")
+	RootCmd.PersistentFlags().StringVar(&metal3ctlContext, "context", "", "The context of the metal3ctl config file to use (default is the current context)")
 }
 
 const Indentation = `  `
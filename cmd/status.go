@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Lists the providers installed in the management cluster",
+	Long: LongDesc(`
+		Lists the providers installed in the management cluster.
+
+		The baremetal-operator instances and the cluster-api providers are read from the clusterctl
+		provider inventory; the baremetal-operator instances are listed first.`),
+
+	Example: Examples(`
+		# Lists the providers installed in the management cluster.
+		metal3ctl status`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStatus()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+}
+
+func runStatus() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := metal3ctl.Status(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile})
+	if err != nil {
+		return errors.Wrapf(err, "error while reading the status of the management cluster")
+	}
+
+	return printStatus(os.Stdout, statuses)
+}
+
+// printStatus prints the providers as a table; providers watching all namespaces are shown as (all).
+func printStatus(out io.Writer, statuses []metal3ctl.ProviderStatus) error {
+	w := tabwriter.NewWriter(out, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tTYPE\tVERSION\tWATCHING NAMESPACE")
+	for _, status := range statuses {
+		watchingNamespace := status.WatchingNamespace
+		if watchingNamespace == "" {
+			watchingNamespace = "(all)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Name, status.Namespace, status.Type, status.Version, watchingNamespace)
+	}
+	return w.Flush()
+}
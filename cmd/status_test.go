@@ -0,0 +1,58 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+func TestPrintStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []metal3ctl.ProviderStatus
+		want     string
+	}{
+		{
+			name: "no providers",
+			want: "NAME      NAMESPACE   TYPE      VERSION   WATCHING NAMESPACE\n",
+		},
+		{
+			name: "providers watching a namespace or all namespaces",
+			statuses: []metal3ctl.ProviderStatus{
+				{Name: "baremetal-operator", Namespace: "metal3-tenant-a", Type: "BareMetalOperator", Version: "v0.3.0", WatchingNamespace: "tenant-a"},
+				{Name: "cluster-api", Namespace: "capi-system", Type: "CoreProvider", Version: "v0.3.3"},
+			},
+			want: "NAME                 NAMESPACE         TYPE                VERSION   WATCHING NAMESPACE\n" +
+				"baremetal-operator   metal3-tenant-a   BareMetalOperator   v0.3.0    tenant-a\n" +
+				"cluster-api          capi-system       CoreProvider        v0.3.3    (all)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if err := printStatus(out, tt.statuses); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("printStatus() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
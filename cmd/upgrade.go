@@ -0,0 +1,135 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	metal3ctl "github.com/Arvinderpal/metal3ctl/pkg/cluster"
+)
+
+var uo = &metal3ctl.UpgradeOptions{}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrades the providers installed in the management cluster",
+	Long: LongDesc(`
+		Upgrades the baremetal-operator and the cluster-api providers installed in the management cluster.`),
+}
+
+var upgradePlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Lists the provider versions the management cluster can be upgraded to",
+	Long: LongDesc(`
+		Lists the provider versions the management cluster can be upgraded to.
+
+		The versions installed in the management cluster are compared with the versions defined
+		in the metal3ctl configuration file, and thus available in the local repository.`),
+
+	Example: Examples(`
+		# Lists the provider versions the management cluster can be upgraded to.
+		metal3ctl upgrade plan
+
+		# Checks if the baremetal-operator can be upgraded to a specific version.
+		metal3ctl upgrade plan --bmo-version v0.3.0 --skip-capi`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUpgradePlan()
+	},
+}
+
+var upgradeApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Upgrades the providers installed in the management cluster",
+	Long: LongDesc(`
+		Upgrades the providers installed in the management cluster.
+
+		The cluster-api providers are upgraded using clusterctl upgrade. The baremetal-operator is
+		upgraded to newer versions only, by pausing all the BareMetalHosts, replacing the
+		baremetal-operator components and deleting the obsolete ones, waiting for the
+		baremetal-operator to be ready and finally unpausing the BareMetalHosts; the BareMetalHosts
+		are unpaused also if the upgrade fails, and running upgrade apply again completes it.`),
+
+	Example: Examples(`
+		# Upgrades the baremetal-operator to the default version and the cluster-api providers
+		# to the latest versions for the current contract.
+		metal3ctl upgrade apply
+
+		# Upgrades the baremetal-operator to a specific version.
+		metal3ctl upgrade apply --bmo-version v0.3.0 --skip-capi`),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUpgradeApply()
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{upgradePlanCmd, upgradeApplyCmd} {
+		c.Flags().BoolVarP(&uo.SkipBMO, "skip-bmo", "", false, "Skips the baremetal-operator upgrade")
+		c.Flags().BoolVarP(&uo.SkipCAPI, "skip-capi", "", false, "Skips the cluster-api providers upgrade")
+		c.Flags().StringVar(&uo.BMOVersion, "bmo-version", "", "The baremetal-operator version to upgrade to, as listed in bmoProvider.versions (default is the version marked as default, or the highest one)")
+	}
+	upgradeApplyCmd.Flags().StringVar(&uo.Contract, "contract", "", "The API Version of Cluster API (contract) the cluster-api providers should be upgraded to (default is the current contract)")
+	upgradeCmd.AddCommand(upgradePlanCmd)
+	upgradeCmd.AddCommand(upgradeApplyCmd)
+	RootCmd.AddCommand(upgradeCmd)
+}
+
+func runUpgradePlan() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	items, err := metal3ctl.PlanUpgrade(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, uo)
+	if err != nil {
+		return errors.Wrapf(err, "error while planning the upgrade")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tTYPE\tCONTRACT\tCURRENT VERSION\tNEXT VERSION")
+	for _, item := range items {
+		nextVersion := item.NextVersion
+		if nextVersion == "" {
+			nextVersion = "Already up to date"
+		}
+		contract := item.Contract
+		if contract == "" {
+			contract = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", item.Name, item.Namespace, item.Type, contract, item.CurrentVersion, nextVersion)
+	}
+	return w.Flush()
+}
+
+func runUpgradeApply() error {
+	configData, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	if err := metal3ctl.ApplyUpgrade(config.LoadMetal3CtlConfigInput{ConfigData: configData, Context: metal3ctlContext, ConfigPath: metal3ctlCfgFile}, uo); err != nil {
+		return errors.Wrapf(err, "error while upgrading the management cluster")
+	}
+	return nil
+}
@@ -21,16 +21,19 @@ import (
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/Arvinderpal/metal3ctl/config/exec"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/version"
 )
 
 // ContainerImage describes an image to load into a cluster and the behavior
 // when loading the image.
 type ContainerImage struct {
 	// Name is the fully qualified name of the image.
-	Name string
+	Name string `json:"name"`
 }
 
 // ComponentSourceType indicates how a component's source should be obtained.
@@ -49,14 +52,14 @@ const (
 // ComponentSource describes how to obtain a component's YAML.
 type ComponentSource struct {
 	// Name is used for logging when a component has multiple sources.
-	Name string `json:"name,omitempty"`
+	Name string `json:"name"`
 
 	// Value is the source of the component's YAML.
 	// May be a URL or a kustomization root (specified by Type).
 	// If a Type=url then Value may begin with file://, http://, or https://.
 	// If a Type=kustomize then Value may be any valid go-getter URL. For
 	// more information please see https://github.com/hashicorp/go-getter#url-format.
-	Value string `json:"value"`
+	Value string `json:"value,omitempty"`
 
 	// Type describes how to process the source of the component's YAML.
 	//
@@ -66,6 +69,21 @@ type ComponentSource struct {
 	// Replacements is a list of patterns to replace in the component YAML
 	// prior to application.
 	Replacements []ComponentReplacement `json:"replacements,omitempty"`
+
+	// Default marks the release to be installed when no version is explicitly selected.
+	// If no release is marked as default, the highest semantic version is used, or the first release if
+	// none of the names is a semantic version.
+	Default bool `json:"default,omitempty"`
+
+	// Contract is the Cluster API contract (e.g. v1alpha3) implemented by the release, used for generating the
+	// clusterctl metadata.yaml in the local repository; it is supported only for CAPI providers.
+	// Defaults to the contract of the Cluster API version supported by metal3ctl.
+	Contract string `json:"contract,omitempty"`
+
+	// Metadata is the path of the clusterctl metadata.yaml to be copied into the local repository for the release;
+	// it is supported only for CAPI providers. If empty, the metadata.yaml found in the source tree of a local
+	// kustomization root is used, and the release series missing from it are generated from Name and Contract.
+	Metadata string `json:"metadata,omitempty"`
 }
 
 // ComponentWaiterType indicates the type of check to use to determine if the
@@ -135,15 +153,121 @@ type ProviderConfig struct {
 	// Type is the type of the provider.
 	Type string `json:"type"`
 
-	// Versions is a list of component YAML to be added to the local repository, one for each release.
-	// Please note that the first source will be used as a default release for this provider.
+	// Versions is a list of component YAML to be added to the local repository, one for each release; for CAPI
+	// providers, at least one version is required and names must be semantic versions.
+	// Please note that the release marked as default, or the highest version if none, will be used as a default
+	// release for this provider.
 	Versions []ComponentSource `json:"versions,omitempty"`
 
-	// Files is a list of files to be copied into the local repository for the default release of this provider.
+	// Files is a list of files to be copied into the local repository for each release of this provider.
 	Files []Files `json:"files,omitempty"`
 
+	// ClusterTemplates is a list of cluster templates to be copied into the local repository for each release of this
+	// provider, used by clusterctl config cluster; it is supported only for infrastructure providers.
+	ClusterTemplates []ClusterTemplate `json:"clusterTemplates,omitempty"`
+
 	// Waiters is list of waiters to be used to check if the installed provider are ready.
 	Waiters []ProviderWaiter `json:"waiters,omitempty"`
+
+	// ImageOverrides is a list of image overrides to be applied to the manifests of this provider.
+	// Provider specific image overrides take precedence over the global ones.
+	ImageOverrides []ImageOverride `json:"imageOverrides,omitempty"`
+
+	// Ironic is the Ironic deployment topology; it is supported only for the baremetal-operator.
+	Ironic *IronicConfig `json:"ironic,omitempty"`
+
+	// TargetNamespace is the namespace where the provider is installed; if empty, the namespace defined in the
+	// provider manifest is used, or the namespace passed to metal3ctl init with --target-namespace.
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// WatchingNamespace is the namespace watched by the provider; if empty, all namespaces are watched, or the
+	// namespace passed to metal3ctl init with --watching-namespace.
+	WatchingNamespace string `json:"watchingNamespace,omitempty"`
+
+	// Instances is a list of instances of the baremetal-operator, each one installed in its own namespace and
+	// usually watching a different tenant namespace; it is supported only for the baremetal-operator, and it can't be
+	// used together with targetNamespace and watchingNamespace.
+	Instances []BMOInstance `json:"instances,omitempty"`
+}
+
+// BMOInstance is an instance of the baremetal-operator.
+type BMOInstance struct {
+	// TargetNamespace is the namespace where the instance is installed.
+	TargetNamespace string `json:"targetNamespace"`
+
+	// WatchingNamespace is the namespace watched by the instance; if empty, all namespaces are watched.
+	WatchingNamespace string `json:"watchingNamespace,omitempty"`
+}
+
+// BMOInstances returns the instances of the baremetal-operator; if no instances are defined, a single instance using
+// the provider targetNamespace and watchingNamespace is returned.
+func (p ProviderConfig) BMOInstances() []BMOInstance {
+	if len(p.Instances) > 0 {
+		return p.Instances
+	}
+	return []BMOInstance{{TargetNamespace: p.TargetNamespace, WatchingNamespace: p.WatchingNamespace}}
+}
+
+// DefaultVersion returns the release marked as default; if none is marked as default, the release with the highest
+// semantic version is returned, preferring stable versions over pre-releases, or the first release if none of the
+// names is a semantic version.
+func (p ProviderConfig) DefaultVersion() ComponentSource {
+	for _, version := range p.Versions {
+		if version.Default {
+			return version
+		}
+	}
+	if len(p.Versions) == 0 {
+		return ComponentSource{}
+	}
+	sorted := p.SortedVersions()
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if v, _ := version.ParseSemantic(sorted[i].Name); v.PreRelease() == "" {
+			return sorted[i]
+		}
+	}
+	if len(sorted) > 0 {
+		return sorted[len(sorted)-1]
+	}
+	return p.Versions[0]
+}
+
+// SortedVersions returns the releases with a semantic version as a name, sorted from the lowest to the highest version;
+// the other releases are omitted.
+func (p ProviderConfig) SortedVersions() []ComponentSource {
+	type semanticVersion struct {
+		source  ComponentSource
+		version *version.Version
+	}
+	versions := []semanticVersion{}
+	for _, source := range p.Versions {
+		if v, err := version.ParseSemantic(source.Name); err == nil {
+			versions = append(versions, semanticVersion{source: source, version: v})
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].version.LessThan(versions[j].version)
+	})
+	sorted := make([]ComponentSource, 0, len(versions))
+	for _, v := range versions {
+		sorted = append(sorted, v.source)
+	}
+	return sorted
+}
+
+// GetVersion returns the release with the given name; if name is empty, the default release is returned.
+func (p ProviderConfig) GetVersion(name string) (ComponentSource, error) {
+	if name == "" {
+		return p.DefaultVersion(), nil
+	}
+	names := []string{}
+	for _, version := range p.Versions {
+		if version.Name == name {
+			return version, nil
+		}
+		names = append(names, version.Name)
+	}
+	return ComponentSource{}, errors.Errorf("version %q is not defined for %q, available versions are: %s", name, p.Name, strings.Join(names, ", "))
 }
 
 // ProviderWaiterType indicates the type of check to use to determine if the
@@ -188,8 +312,24 @@ type ProviderWaiter struct {
 	// Please see the documentation for the different WaiterType constants to
 	// understand the valid values for this field.
 	Name string `json:"name"`
+
+	// Ironic marks waiters checking Ironic components; they are skipped when the baremetal-operator
+	// uses an external Ironic. Deployment waiters for Deployments which are not part of the baremetal-operator
+	// manifest, as the Ironic ones with an external Ironic, are skipped even if they are not marked.
+	Ironic bool `json:"ironic,omitempty"`
 }
 
+// FileKind indicates the kind of object a file is materialised as in the mgmt cluster.
+type FileKind string
+
+const (
+	// ConfigMapFile indicates to store the file into a ConfigMap.
+	ConfigMapFile FileKind = "ConfigMap"
+
+	// SecretFile indicates to store the file into a Secret.
+	SecretFile FileKind = "Secret"
+)
+
 // Files contains information about files to be copied into the local repository
 type Files struct {
 	// SourcePath path of the file.
@@ -198,6 +338,40 @@ type Files struct {
 	// TargetName name of the file copied into the local repository. if empty, the source name
 	// Will be preserved
 	TargetName string `json:"targetName,omitempty"`
+
+	// Kind is the kind of object the file is materialised as in the mgmt cluster; it is supported only for
+	// the baremetal-operator, and it can be ConfigMap or Secret.
+	// Files with the same Kind, Name and Namespace are stored in the same object, using TargetName as a key.
+	Kind FileKind `json:"kind,omitempty"`
+
+	// Name is the name of the ConfigMap or Secret.
+	Name string `json:"name,omitempty"`
+
+	// Namespace is the namespace of the ConfigMap or Secret.
+	// Defaults to the baremetal-operator namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Env, if true, reads the file as a list of KEY=VALUE lines, and stores each of them as a separate key
+	// in the ConfigMap or Secret, like the kustomize configMapGenerator envs (e.g. for ironic_bmo_configmap.env).
+	Env bool `json:"env,omitempty"`
+}
+
+// ClusterTemplate is a cluster template of an infrastructure provider.
+type ClusterTemplate struct {
+	// Flavor is the flavor of the template, selected with clusterctl config cluster --flavor; the template without
+	// a flavor is used when no flavor is selected.
+	Flavor string `json:"flavor,omitempty"`
+
+	// SourcePath is the path of the template.
+	SourcePath string `json:"sourcePath"`
+}
+
+// FileName returns the name of the template in the local repository, as expected by clusterctl.
+func (t ClusterTemplate) FileName() string {
+	if t.Flavor == "" {
+		return "cluster-template.yaml"
+	}
+	return "cluster-template-" + t.Flavor + ".yaml"
 }
 
 // YAMLForComponentSource returns the YAML for the provided component source.
@@ -206,6 +380,14 @@ func YAMLForComponentSource(ctx context.Context, source ComponentSource) ([]byte
 
 	switch source.Type {
 	case URLSource:
+		if strings.HasPrefix(source.Value, "file://") {
+			buf, err := ioutil.ReadFile(strings.TrimPrefix(source.Value, "file://"))
+			if err != nil {
+				return nil, err
+			}
+			data = buf
+			break
+		}
 		resp, err := http.Get(source.Value)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,57 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestProviderConfigDefaultVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []ComponentSource
+		want     string
+	}{
+		{
+			name: "no versions",
+			want: "",
+		},
+		{
+			name:     "version marked as default",
+			versions: []ComponentSource{{Name: "v0.3.3"}, {Name: "v0.3.2", Default: true}},
+			want:     "v0.3.2",
+		},
+		{
+			name:     "highest semantic version, regardless of the list order",
+			versions: []ComponentSource{{Name: "v0.3.2"}, {Name: "v0.3.10"}, {Name: "v0.3.3"}, {Name: "v0.3.11-rc.0"}},
+			want:     "v0.3.10",
+		},
+		{
+			name:     "first version, if none is a semantic version",
+			versions: []ComponentSource{{Name: "master"}, {Name: "capm3-v0.3.0"}},
+			want:     "master",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ProviderConfig{Versions: tt.versions}
+			if got := p.DefaultVersion().Name; got != tt.want {
+				t.Errorf("DefaultVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -24,14 +24,27 @@ import (
 	"regexp"
 
 	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
 	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
 	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
 	"sigs.k8s.io/yaml"
 )
 
 // LoadMetal3CtlConfig is the input for LoadMetal3CtlConfig.
 type LoadMetal3CtlConfigInput struct {
 	ConfigData []byte
+
+	// Context is the name of the context to use; if empty, currentContext is used.
+	Context string
+
+	// ConfigPath is the path of the config file, used for resolving the relative paths of the included files; if
+	// empty, they are relative to the current directory.
+	ConfigPath string
 }
 
 // LoadMetal3CtlConfig will load the metal3ctl config.
@@ -40,26 +53,101 @@ func LoadMetal3CtlConfig(ctx context.Context, input LoadMetal3CtlConfigInput) (*
 		return nil, errors.New("config should not be empty")
 	}
 
-	config := &Metal3CtlConfig{}
-	if err := yaml.Unmarshal(input.ConfigData, config); err != nil {
-		return nil, errors.Wrapf(err, "error loading the init config file")
+	config, root, configErrs, err := decodeConfig(input.ConfigData, "")
+	if err != nil {
+		return nil, err
+	}
+	docs := []configDocument{}
+	if len(config.Include) > 0 {
+		base, includeDocs, includeErrs, err := resolveIncludes(ctx, config.Include, input.ConfigPath, config.Variables, map[string]bool{input.ConfigPath: true})
+		if err != nil {
+			return nil, err
+		}
+		configErrs = append(configErrs, includeErrs...)
+		docs = includeDocs
+		mergeConfig(base, config)
+		config = base
+	}
+	docs = append(docs, configDocument{root: root})
+	allErrs := config.applyContext(input.Context)
+
+	if err := config.expandPaths(); err != nil {
+		return nil, errors.Wrapf(err, "error expanding variables in the init config file")
 	}
 
 	config.Defaults()
-	if err := config.Validate(); err != nil {
-		return nil, errors.Wrapf(err, "invalid init config")
+	allErrs = append(allErrs, config.validate()...)
+	configErrs = append(configErrs, locateConfigErrors(config, docs, allErrs)...)
+	if err := configErrs.toError(); err != nil {
+		return nil, err
 	}
 	return config, nil
 }
 
+// decodeConfig converts a config file to GroupVersion, validates it against the config schema and decodes it; schema
+// errors, e.g. unknown fields, are returned as errors located in the file. The file is the path or the URL of an
+// included config file, empty for the configuration file itself.
+func decodeConfig(data []byte, file string) (*Metal3CtlConfig, *yamlv3.Node, ConfigErrors, error) {
+	// The document is parsed as a YAML node tree too, so it is validated against the schema and errors located by line.
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, root); err != nil {
+		if file != "" {
+			return nil, nil, nil, errors.Wrapf(err, "error loading the included config file %s", file)
+		}
+		return nil, nil, nil, errors.Wrapf(err, "error loading the init config file")
+	}
+	version, err := convertConfig(root)
+	if err != nil {
+		if configErrs, ok := err.(ConfigErrors); ok {
+			for i := range configErrs {
+				configErrs[i].File = file
+			}
+		}
+		return nil, nil, nil, err
+	}
+	if version != GroupVersion {
+		logf.Log.Info("The config file uses an older format, please run metal3ctl config migrate", "File", file, "Version", version, "LatestVersion", GroupVersion)
+	}
+	configErrs := newConfigErrors(root, file, configSchema.validateSchema(root, configSchema, nil))
+
+	data, err = yamlv3.Marshal(root)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "error converting the config file to %s", GroupVersion)
+	}
+	config := &Metal3CtlConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		// Values of the wrong type are reported by the schema, located in the file.
+		if err := configErrs.toError(); err != nil {
+			return nil, nil, nil, err
+		}
+		if file != "" {
+			return nil, nil, nil, errors.Wrapf(err, "error loading the included config file %s", file)
+		}
+		return nil, nil, nil, errors.Wrapf(err, "error loading the init config file")
+	}
+	return config, root, configErrs, nil
+}
+
 // Metal3CtlConfig is the input used to configure a metal3 mgmt cluster.
 type Metal3CtlConfig struct {
+	// TypeMeta defines the version of the configuration file format; configuration files without apiVersion and kind
+	// are converted from the legacy format.
+	metav1.TypeMeta `json:",inline"`
+
+	// Include is a list of config files (paths or URLs) merged in order before this config file; the fields defined
+	// here override the included ones, and lists of named items, e.g. providers, versions and replacements, are
+	// merged by name. Relative paths defined in an included local file are relative to the directory of the file.
+	Include []string `json:"include,omitempty"`
+
 	// Name is the name of the management cluster.
 	ManagementClusterName string `json:"managementClusterName,omitempty"`
 
 	// Path to kubeconfig of the mgmt cluster.
 	Kubeconfig string `json:"kubeconfig,omitempty"`
 
+	// KubeContext is the context of the kubeconfig to use; if empty, the kubeconfig current context is used.
+	KubeContext string `json:"kubeContext,omitempty"`
+
 	// Path to where all the generated artifacts will be stored.
 	ArtifactsPath string `json:"artifactsPath,omitempty"`
 
@@ -77,17 +165,57 @@ type Metal3CtlConfig struct {
 	// baremetal-operator configuration
 	BMOProvider ProviderConfig `json:"bmoProvider,omitempty"`
 
+	// ImageOverrides is a list of image overrides to be applied to all the containers and init containers
+	// in the generated manifests, as well as to the list of Images to load into the mgmt cluster.
+	ImageOverrides []ImageOverride `json:"imageOverrides,omitempty"`
+
+	// RegistryMirror is a private registry (optionally followed by a path prefix, e.g. my-registry:5000/metal3)
+	// every image in the BMO and CAPI provider manifests is pulled from; the original registry of the image is kept
+	// as a path component (e.g. my-registry:5000/metal3/quay.io/metal3-io/ironic).
+	// Image overrides are applied before the registry mirror.
+	RegistryMirror string `json:"registryMirror,omitempty"`
+
 	// Variables to be added to the clusterctl config file
 	// Please not that clusterctl read variables from the os environment variables as well, so you can avoid to hard code
 	// sensitive data in the config file.
+	// Variables are also used for expanding ${VAR} and ${VAR:=default} in the path fields of this config file,
+	// e.g. kubeconfig, artifactsPath, versions values and files source paths.
 	Variables map[string]string `json:"variables,omitempty"`
+
+	// SecretVariables are variables whose values are read, when needed, from environment variables, files or
+	// Secrets in the mgmt cluster; they are passed to clusterctl and used for expanding the baremetal-operator
+	// manifest like variables, but their values are never written to the artifacts path and are redacted in the output.
+	SecretVariables []SecretVariable `json:"secretVariables,omitempty"`
+
+	// ProvisioningNetwork, if set, is used for generating the Ironic ConfigMap injected into the baremetal-operator manifest.
+	ProvisioningNetwork *ProvisioningNetwork `json:"provisioningNetwork,omitempty"`
+
+	// BMOVariables are used for expanding ${VAR} and ${VAR:=default} in the baremetal-operator manifest;
+	// os environment variables take precedence over the values defined here.
+	BMOVariables map[string]string `json:"bmoVariables,omitempty"`
+
+	// Contexts is a list of mgmt clusters using the providers defined in this config file; the settings of the
+	// selected context override managementClusterName, kubeconfig, kubeContext, artifactsPath and variables.
+	Contexts []ManagementClusterContext `json:"contexts,omitempty"`
+
+	// CurrentContext is the name of the context used when no context is selected with --context.
+	CurrentContext string `json:"currentContext,omitempty"`
 }
 
 // Defaults assigns default values to the object.
 func (c *Metal3CtlConfig) Defaults() {
+	if c.ProvisioningNetwork != nil {
+		c.ProvisioningNetwork.Defaults()
+	}
+	if c.BMOProvider.Ironic != nil {
+		c.BMOProvider.Ironic.Defaults()
+	}
 
+	providers := []*ProviderConfig{&c.BMOProvider}
 	for i := range c.CAPIProviders {
-		provider := &c.CAPIProviders[i]
+		providers = append(providers, &c.CAPIProviders[i])
+	}
+	for _, provider := range providers {
 		for j := range provider.Versions {
 			version := &provider.Versions[j]
 			if version.Value != "" && version.Type == "" {
@@ -109,132 +237,321 @@ func (c *Metal3CtlConfig) Defaults() {
 	}
 }
 
-// Validate validates the configuration.
+// Validate validates the configuration; all the errors found are returned as an aggregate.
 func (c *Metal3CtlConfig) Validate() error {
+	return c.validate().ToAggregate()
+}
+
+func (c *Metal3CtlConfig) validate() field.ErrorList {
+	allErrs := field.ErrorList{}
 	if c.ManagementClusterName == "" {
-		return errEmptyArg("managementClusterName")
+		allErrs = append(allErrs, field.Required(field.NewPath("managementClusterName"), ""))
 	}
 	if c.Kubeconfig == "" {
-		return errEmptyArg("kubeconfig")
+		allErrs = append(allErrs, field.Required(field.NewPath("kubeconfig"), ""))
 	}
 	if c.ArtifactsPath == "" {
-		return errEmptyArg("artifactsPath")
-	}
-	providersByType := map[clusterctlv1.ProviderType][]string{
-		clusterctlv1.CoreProviderType:           nil,
-		clusterctlv1.BootstrapProviderType:      nil,
-		clusterctlv1.ControlPlaneProviderType:   nil,
-		clusterctlv1.InfrastructureProviderType: nil,
+		allErrs = append(allErrs, field.Required(field.NewPath("artifactsPath"), ""))
 	}
+	allErrs = append(allErrs, validateContexts(c.Contexts, field.NewPath("contexts"))...)
+	allErrs = append(allErrs, validateSecretVariables(c.SecretVariables, c.Variables, field.NewPath("secretVariables"))...)
+
+	providersPath := field.NewPath("capiProviders")
+	providersByType := map[clusterctlv1.ProviderType][]int{}
 	for i, providerConfig := range c.CAPIProviders {
+		providerPath := providersPath.Index(i)
 		if providerConfig.Name == "" {
-			return errEmptyArg(fmt.Sprintf("CAPIProviders[%d].Name", i))
+			allErrs = append(allErrs, field.Required(providerPath.Child("name"), ""))
 		}
 		providerType := clusterctlv1.ProviderType(providerConfig.Type)
 		switch providerType {
 		case clusterctlv1.CoreProviderType, clusterctlv1.BootstrapProviderType, clusterctlv1.ControlPlaneProviderType, clusterctlv1.InfrastructureProviderType:
-			providersByType[providerType] = append(providersByType[providerType], providerConfig.Name)
+			providersByType[providerType] = append(providersByType[providerType], i)
 		default:
-			return errInvalidArg("CAPIProviders[%d].Type=%q", i, providerConfig.Type)
+			allErrs = append(allErrs, field.NotSupported(providerPath.Child("type"), providerConfig.Type, capiProviderTypes))
 		}
 
-		for j, version := range providerConfig.Versions {
-			if version.Name == "" {
-				return errEmptyArg(fmt.Sprintf("CAPIProviders[%d].Sources[%d].Name", i, j))
-			}
-			switch version.Type {
-			case URLSource, KustomizeSource:
-				if version.Value == "" {
-					return errEmptyArg(fmt.Sprintf("CAPIProviders[%d].Sources[%d].Value", i, j))
-				}
-			default:
-				return errInvalidArg("CAPIProviders[%d].Sources[%d].Type=%q", i, j, version.Type)
-			}
-			for k, replacement := range version.Replacements {
-				if _, err := regexp.Compile(replacement.Old); err != nil {
-					return errInvalidArg("CAPIProviders[%d].Sources[%d].Replacements[%d].Old=%q: %v", i, j, k, replacement.Old, err)
-				}
-			}
+		if len(providerConfig.Versions) == 0 {
+			allErrs = append(allErrs, field.Required(providerPath.Child("versions"), "please specify at least one version"))
 		}
+		allErrs = append(allErrs, validateVersions(providerConfig.Versions, providerPath.Child("versions"))...)
+		allErrs = append(allErrs, validateSemanticVersions(providerConfig.Versions, providerPath.Child("versions"))...)
 
 		for j, file := range providerConfig.Files {
+			filePath := providerPath.Child("files").Index(j)
 			if file.SourcePath == "" {
-				return errInvalidArg("CAPIProviders[%d].Files[%d].SourcePath=%q", i, j, file.SourcePath)
-			}
-			if !fileExists(file.SourcePath) {
-				return errInvalidArg("CAPIProviders[%d].Files[%d].SourcePath=%q", i, j, file.SourcePath)
+				allErrs = append(allErrs, field.Required(filePath.Child("sourcePath"), ""))
+			} else if !fileExists(file.SourcePath) {
+				allErrs = append(allErrs, field.Invalid(filePath.Child("sourcePath"), file.SourcePath, "file not found"))
 			}
 			if file.TargetName == "" {
-				return errInvalidArg("CAPIProviders[%d].Files[%d].TargetName=%q", i, j, file.TargetName)
+				allErrs = append(allErrs, field.Required(filePath.Child("targetName"), ""))
 			}
 		}
 
-		for j, waiter := range providerConfig.Waiters {
-			switch waiter.Type {
-			case ApiServiceWaiter:
-				//TODO: add validation
-			case DeploymentWaiter:
-				//TODO: add validation
-			default:
-				return errInvalidArg("CAPIProviders[%d].Waiters[%d].Type=%q", i, j, waiter.Type)
-			}
+		allErrs = append(allErrs, validateWaiters(providerConfig.Waiters, providerPath.Child("waiters"))...)
+		allErrs = append(allErrs, validateImageOverrides(providerConfig.ImageOverrides, providerPath.Child("imageOverrides"))...)
+		allErrs = append(allErrs, validateClusterTemplates(providerConfig, providerPath)...)
+
+		if providerConfig.Ironic != nil {
+			allErrs = append(allErrs, field.Forbidden(providerPath.Child("ironic"), "ironic is supported only for the baremetal-operator"))
+		}
+		if len(providerConfig.Instances) > 0 {
+			allErrs = append(allErrs, field.Forbidden(providerPath.Child("instances"), "instances are supported only for the baremetal-operator"))
 		}
+		allErrs = append(allErrs, validateNamespace(providerConfig.TargetNamespace, providerPath.Child("targetNamespace"))...)
+		allErrs = append(allErrs, validateNamespace(providerConfig.WatchingNamespace, providerPath.Child("watchingNamespace"))...)
 	}
 
-	if len(providersByType[clusterctlv1.CoreProviderType]) != 1 {
-		return errInvalidArg("invalid config: it is required to have exactly one core-provider")
+	// clusterctl requires exactly one core provider named cluster-api, while any number of bootstrap, control-plane
+	// and infrastructure providers can be installed, e.g. metal3 alongside other infrastructure providers.
+	coreProviders := providersByType[clusterctlv1.CoreProviderType]
+	switch {
+	case len(coreProviders) == 0:
+		allErrs = append(allErrs, field.Required(providersPath, fmt.Sprintf("it is required to have exactly one %s", clusterctlv1.CoreProviderType)))
+	case len(coreProviders) > 1:
+		for _, i := range coreProviders[1:] {
+			allErrs = append(allErrs, field.Invalid(providersPath.Index(i).Child("type"), string(clusterctlv1.CoreProviderType), fmt.Sprintf("it is required to have exactly one %s", clusterctlv1.CoreProviderType)))
+		}
+	case c.CAPIProviders[coreProviders[0]].Name != clusterctlconfig.ClusterAPIProviderName:
+		allErrs = append(allErrs, field.Invalid(providersPath.Index(coreProviders[0]).Child("name"), c.CAPIProviders[coreProviders[0]].Name, fmt.Sprintf("%s should be named %s", clusterctlv1.CoreProviderType, clusterctlconfig.ClusterAPIProviderName)))
 	}
-	if providersByType[clusterctlv1.CoreProviderType][0] != clusterctlconfig.ClusterAPIProviderName {
-		return errInvalidArg("invalid config: core-provider should be named %s", clusterctlconfig.ClusterAPIProviderName)
+	for _, providerType := range []clusterctlv1.ProviderType{clusterctlv1.BootstrapProviderType, clusterctlv1.ControlPlaneProviderType, clusterctlv1.InfrastructureProviderType} {
+		indexes := providersByType[providerType]
+		if len(indexes) == 0 {
+			allErrs = append(allErrs, field.Required(providersPath, fmt.Sprintf("it is required to have at least one %s", providerType)))
+		}
+		names := map[string]bool{}
+		for _, i := range indexes {
+			name := c.CAPIProviders[i].Name
+			if name != "" && names[name] {
+				allErrs = append(allErrs, field.Duplicate(providersPath.Index(i).Child("name"), name))
+			}
+			names[name] = true
+		}
 	}
 
-	if len(providersByType[clusterctlv1.BootstrapProviderType]) != 1 {
-		return errInvalidArg("invalid config: it is required to have exactly one bootstrap-provider")
+	for i, containerImage := range c.Images {
+		if containerImage.Name == "" {
+			allErrs = append(allErrs, field.Required(field.NewPath("images").Index(i).Child("name"), ""))
+		}
 	}
-	if providersByType[clusterctlv1.BootstrapProviderType][0] != clusterctlconfig.KubeadmBootstrapProviderName {
-		return errInvalidArg("invalid config: bootstrap-provider should be named %s", clusterctlconfig.KubeadmBootstrapProviderName)
+
+	allErrs = append(allErrs, validateImageOverrides(c.ImageOverrides, field.NewPath("imageOverrides"))...)
+	allErrs = append(allErrs, validateRegistryMirror(c.RegistryMirror, field.NewPath("registryMirror"))...)
+
+	if c.ProvisioningNetwork != nil {
+		allErrs = append(allErrs, c.ProvisioningNetwork.validate(field.NewPath("provisioningNetwork"))...)
 	}
 
-	if len(providersByType[clusterctlv1.ControlPlaneProviderType]) != 1 {
-		return errInvalidArg("invalid config: it is required to have exactly one control-plane-provider")
+	bmoPath := field.NewPath("bmoProvider")
+	if c.BMOProvider.Name == "" {
+		allErrs = append(allErrs, field.Required(bmoPath.Child("name"), ""))
 	}
-	if providersByType[clusterctlv1.ControlPlaneProviderType][0] != clusterctlconfig.KubeadmControlPlaneProviderName {
-		return errInvalidArg("invalid config: control-plane-provider should be named %s", clusterctlconfig.KubeadmControlPlaneProviderName)
+	if c.BMOProvider.Type != bmoProviderTypes[0] {
+		allErrs = append(allErrs, field.NotSupported(bmoPath.Child("type"), c.BMOProvider.Type, bmoProviderTypes))
 	}
-
-	if len(providersByType[clusterctlv1.InfrastructureProviderType]) != 1 {
-		return errInvalidArg("invalid config: it is required to have exactly one infrastructure-provider")
+	if len(c.BMOProvider.Versions) == 0 {
+		allErrs = append(allErrs, field.Required(bmoPath.Child("versions"), "please specify at least one baremetal-operator version"))
+	}
+	allErrs = append(allErrs, validateVersions(c.BMOProvider.Versions, bmoPath.Child("versions"))...)
+	for j, version := range c.BMOProvider.Versions {
+		if version.Contract != "" || version.Metadata != "" {
+			allErrs = append(allErrs, field.Forbidden(bmoPath.Child("versions").Index(j), "contract and metadata are supported only for CAPI providers"))
+		}
+	}
+	if len(c.BMOProvider.ClusterTemplates) > 0 {
+		allErrs = append(allErrs, field.Forbidden(bmoPath.Child("clusterTemplates"), "cluster templates are supported only for infrastructure providers"))
+	}
+	if c.BMOProvider.Ironic != nil {
+		allErrs = append(allErrs, c.BMOProvider.Ironic.validate(bmoPath.Child("ironic"))...)
+	}
+	allErrs = append(allErrs, validateWaiters(c.BMOProvider.Waiters, bmoPath.Child("waiters"))...)
+	for j, waiter := range c.BMOProvider.Waiters {
+		if waiter.Name == "" {
+			allErrs = append(allErrs, field.Required(bmoPath.Child("waiters").Index(j).Child("name"), ""))
+		}
 	}
+	for j, file := range c.BMOProvider.Files {
+		filePath := bmoPath.Child("files").Index(j)
+		if file.SourcePath == "" {
+			allErrs = append(allErrs, field.Required(filePath.Child("sourcePath"), ""))
+		} else if !fileExists(file.SourcePath) {
+			allErrs = append(allErrs, field.Invalid(filePath.Child("sourcePath"), file.SourcePath, "file not found"))
+		}
+		switch file.Kind {
+		case ConfigMapFile, SecretFile:
+		default:
+			allErrs = append(allErrs, field.NotSupported(filePath.Child("kind"), file.Kind, []string{string(ConfigMapFile), string(SecretFile)}))
+		}
+		if file.Name == "" {
+			allErrs = append(allErrs, field.Required(filePath.Child("name"), ""))
+		}
+	}
+	allErrs = append(allErrs, validateImageOverrides(c.BMOProvider.ImageOverrides, bmoPath.Child("imageOverrides"))...)
+	allErrs = append(allErrs, validateBMOInstances(c.BMOProvider, bmoPath)...)
 
-	//TODO: check if the infrastructure provider has a cluster-template
+	return allErrs
+}
 
-	for i, containerImage := range c.Images {
-		if containerImage.Name == "" {
-			return errEmptyArg(fmt.Sprintf("Images[%d].Name=%q", i, containerImage.Name))
+// validateBMOInstances validates the namespaces of the baremetal-operator instances; each instance must be installed
+// in its own namespace, and the watched namespaces must not overlap. Several instances require an external Ironic,
+// because the Ironic deployed with each instance runs on the host network, using the same ports and DHCP range.
+func validateBMOInstances(provider ProviderConfig, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	allErrs = append(allErrs, validateNamespace(provider.TargetNamespace, path.Child("targetNamespace"))...)
+	allErrs = append(allErrs, validateNamespace(provider.WatchingNamespace, path.Child("watchingNamespace"))...)
+	if len(provider.Instances) == 0 {
+		return allErrs
+	}
+	if provider.TargetNamespace != "" || provider.WatchingNamespace != "" {
+		allErrs = append(allErrs, field.Forbidden(path.Child("instances"), "instances can't be used together with targetNamespace and watchingNamespace"))
+	}
+	if len(provider.Instances) > 1 && !provider.Ironic.IsExternal() {
+		allErrs = append(allErrs, field.Forbidden(path.Child("instances"), fmt.Sprintf("several instances can be used only when the Ironic mode is %s", IronicExternalMode)))
+	}
+
+	targetNamespaces := map[string]bool{}
+	watchingNamespaces := map[string]bool{}
+	for i, instance := range provider.Instances {
+		instancePath := path.Child("instances").Index(i)
+		if instance.TargetNamespace == "" {
+			allErrs = append(allErrs, field.Required(instancePath.Child("targetNamespace"), ""))
+		} else if targetNamespaces[instance.TargetNamespace] {
+			allErrs = append(allErrs, field.Duplicate(instancePath.Child("targetNamespace"), instance.TargetNamespace))
+		}
+		targetNamespaces[instance.TargetNamespace] = true
+		allErrs = append(allErrs, validateNamespace(instance.TargetNamespace, instancePath.Child("targetNamespace"))...)
+		allErrs = append(allErrs, validateNamespace(instance.WatchingNamespace, instancePath.Child("watchingNamespace"))...)
+
+		// An instance watching all namespaces overlaps with any other instance.
+		if instance.WatchingNamespace == "" && len(provider.Instances) > 1 {
+			allErrs = append(allErrs, field.Required(instancePath.Child("watchingNamespace"), "it is required when there are several instances, so the watched namespaces don't overlap"))
+		} else if instance.WatchingNamespace != "" && watchingNamespaces[instance.WatchingNamespace] {
+			allErrs = append(allErrs, field.Duplicate(instancePath.Child("watchingNamespace"), instance.WatchingNamespace))
 		}
+		watchingNamespaces[instance.WatchingNamespace] = true
 	}
+	return allErrs
+}
 
-	if len(c.BMOProvider.Versions) != 1 {
-		// TODO: consider adding support for multiple bmo versions
-		return errors.New("please specify one and only one baremetal-operator version")
+func validateNamespace(namespace string, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if namespace == "" {
+		return allErrs
 	}
-	if c.BMOProvider.Name == "" {
-		return errors.New("baremetal-operator name cannot be empty in metal3ctl configuration file")
+	for _, msg := range validation.IsDNS1123Label(namespace) {
+		allErrs = append(allErrs, field.Invalid(path, namespace, msg))
 	}
-	if c.BMOProvider.Type != "BareMetalOperator" {
-		return errors.Errorf("baremetal-operator type must be BareMetalOperator, found %v instead", c.BMOProvider.Type)
+	return allErrs
+}
+
+// validateVersions validates the versions of a provider; version names must be unique and at most one version
+// can be marked as default.
+func validateVersions(versions []ComponentSource, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := map[string]bool{}
+	defaults := 0
+	for j, version := range versions {
+		versionPath := path.Index(j)
+		if version.Name == "" {
+			allErrs = append(allErrs, field.Required(versionPath.Child("name"), ""))
+		} else if names[version.Name] {
+			allErrs = append(allErrs, field.Duplicate(versionPath.Child("name"), version.Name))
+		}
+		names[version.Name] = true
+		switch version.Type {
+		case URLSource, KustomizeSource:
+			if version.Value == "" {
+				allErrs = append(allErrs, field.Required(versionPath.Child("value"), ""))
+			}
+		default:
+			allErrs = append(allErrs, field.NotSupported(versionPath.Child("type"), version.Type, []string{string(URLSource), string(KustomizeSource)}))
+		}
+		for k, replacement := range version.Replacements {
+			if _, err := regexp.Compile(replacement.Old); err != nil {
+				allErrs = append(allErrs, field.Invalid(versionPath.Child("replacements").Index(k).Child("old"), replacement.Old, err.Error()))
+			}
+		}
+		if version.Default {
+			defaults++
+			if defaults > 1 {
+				allErrs = append(allErrs, field.Invalid(versionPath.Child("default"), version.Default, "only one version can be marked as default"))
+			}
+		}
 	}
+	return allErrs
+}
 
-	return nil
+// validateSemanticVersions validates that the version names are semantic versions, as required by clusterctl, and
+// that each version is defined only once.
+func validateSemanticVersions(versions []ComponentSource, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	seen := map[string]string{}
+	for j, source := range versions {
+		if source.Name == "" {
+			continue
+		}
+		v, err := version.ParseSemantic(source.Name)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Index(j).Child("name"), source.Name, "must be a semantic version, e.g. v0.3.3"))
+			continue
+		}
+		if name, ok := seen[v.String()]; ok && name != source.Name {
+			allErrs = append(allErrs, field.Duplicate(path.Index(j).Child("name"), source.Name))
+		}
+		seen[v.String()] = source.Name
+	}
+	return allErrs
 }
 
-func errInvalidArg(format string, args ...interface{}) error {
-	msg := fmt.Sprintf(format, args...)
-	return errors.Errorf("invalid argument: %s", msg)
+// validateClusterTemplates validates the cluster templates of a provider, and the metadata of its releases.
+func validateClusterTemplates(provider ProviderConfig, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	templatesPath := path.Child("clusterTemplates")
+	if len(provider.ClusterTemplates) > 0 && provider.Type != string(clusterctlv1.InfrastructureProviderType) {
+		allErrs = append(allErrs, field.Forbidden(templatesPath, "cluster templates are supported only for infrastructure providers"))
+	}
+	names := map[string]bool{}
+	for _, file := range provider.Files {
+		names[file.TargetName] = true
+	}
+	for i, template := range provider.ClusterTemplates {
+		templatePath := templatesPath.Index(i)
+		if template.Flavor != "" {
+			for _, msg := range validation.IsDNS1123Label(template.Flavor) {
+				allErrs = append(allErrs, field.Invalid(templatePath.Child("flavor"), template.Flavor, msg))
+			}
+		}
+		if names[template.FileName()] {
+			allErrs = append(allErrs, field.Duplicate(templatePath.Child("flavor"), template.Flavor))
+		}
+		names[template.FileName()] = true
+		if template.SourcePath == "" {
+			allErrs = append(allErrs, field.Required(templatePath.Child("sourcePath"), ""))
+		} else if !fileExists(template.SourcePath) {
+			allErrs = append(allErrs, field.Invalid(templatePath.Child("sourcePath"), template.SourcePath, "file not found"))
+		}
+	}
+	for i, version := range provider.Versions {
+		if version.Metadata != "" && !fileExists(version.Metadata) {
+			allErrs = append(allErrs, field.Invalid(path.Child("versions").Index(i).Child("metadata"), version.Metadata, "file not found"))
+		}
+	}
+	return allErrs
 }
 
-func errEmptyArg(argName string) error {
-	return errInvalidArg("%s is empty", argName)
+func validateWaiters(waiters []ProviderWaiter, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for j, waiter := range waiters {
+		switch waiter.Type {
+		case ApiServiceWaiter, DeploymentWaiter:
+			//TODO: add validation
+		default:
+			allErrs = append(allErrs, field.NotSupported(path.Index(j).Child("type"), waiter.Type, []string{string(ApiServiceWaiter), string(DeploymentWaiter)}))
+		}
+	}
+	return allErrs
 }
 
 func fileExists(filename string) bool {
@@ -244,12 +561,3 @@ func fileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
-
-func (c *Metal3CtlConfig) InfraProvider() string {
-	for _, providerConfig := range c.CAPIProviders {
-		if providerConfig.Type == string(clusterctlv1.InfrastructureProviderType) {
-			return providerConfig.Name
-		}
-	}
-	panic("it is required to have an infra provider in the config")
-}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ManagementClusterContext describes a mgmt cluster using the providers defined in the config file, so a single
+// config file can be used for several mgmt clusters.
+type ManagementClusterContext struct {
+	// Name is the name of the context, used as the name of the mgmt cluster.
+	Name string `json:"name"`
+
+	// Kubeconfig is the path to the kubeconfig of the mgmt cluster; if empty, the top level kubeconfig is used.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// KubeContext is the context of the kubeconfig to use; if empty, the top level kubeContext is used.
+	KubeContext string `json:"kubeContext,omitempty"`
+
+	// ArtifactsPath is the path where all the generated artifacts for the mgmt cluster will be stored; if empty,
+	// the top level artifactsPath is used.
+	ArtifactsPath string `json:"artifactsPath,omitempty"`
+
+	// Variables override the top level variables with the same name.
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// applyContext applies the settings of the selected context to the top level ones; the context is selected by name,
+// by currentContext if name is empty, or if it is the only context defined.
+func (c *Metal3CtlConfig) applyContext(name string) field.ErrorList {
+	contextsPath := field.NewPath("contexts")
+	if len(c.Contexts) == 0 {
+		if name != "" {
+			return field.ErrorList{field.NotFound(contextsPath, name)}
+		}
+		return nil
+	}
+
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" && len(c.Contexts) == 1 {
+		name = c.Contexts[0].Name
+	}
+	if name == "" {
+		return field.ErrorList{field.Required(field.NewPath("currentContext"), "please select a context with currentContext or --context")}
+	}
+
+	for _, context := range c.Contexts {
+		if context.Name != name {
+			continue
+		}
+		c.CurrentContext = context.Name
+		c.ManagementClusterName = context.Name
+		if context.Kubeconfig != "" {
+			c.Kubeconfig = context.Kubeconfig
+		}
+		if context.KubeContext != "" {
+			c.KubeContext = context.KubeContext
+		}
+		if context.ArtifactsPath != "" {
+			c.ArtifactsPath = context.ArtifactsPath
+		}
+		if len(context.Variables) > 0 {
+			variables := map[string]string{}
+			for k, v := range c.Variables {
+				variables[k] = v
+			}
+			for k, v := range context.Variables {
+				variables[k] = v
+			}
+			c.Variables = variables
+		}
+		return nil
+	}
+	return field.ErrorList{field.NotFound(contextsPath, name)}
+}
+
+func validateContexts(contexts []ManagementClusterContext, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := map[string]bool{}
+	for i, context := range contexts {
+		if context.Name == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("name"), ""))
+		} else if names[context.Name] {
+			allErrs = append(allErrs, field.Duplicate(path.Index(i).Child("name"), context.Name))
+		}
+		names[context.Name] = true
+	}
+	return allErrs
+}
+
+// SetCurrentContext sets currentContext in a metal3ctl configuration file; only the currentContext line is changed,
+// or added at the end of the file, so the rest of the file, including legacy fields and comments, is left untouched.
+// The context is not validated, because it can be defined in an included file: callers should check that the
+// context exists in the loaded configuration.
+func SetCurrentContext(data []byte, name string) ([]byte, error) {
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, root); err != nil {
+		return nil, errors.Wrapf(err, "error loading the config file")
+	}
+	mapping := documentMapping(root)
+	if mapping == nil || mapping.Style&yamlv3.FlowStyle != 0 {
+		return nil, errors.New("the config file must be a YAML block mapping")
+	}
+
+	value, err := yamlv3.Marshal(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to convert to yaml the context name")
+	}
+	value = bytes.TrimSuffix(value, []byte("\n"))
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != "currentContext" {
+			continue
+		}
+		node := mapping.Content[i+1]
+		if node.Kind != yamlv3.ScalarNode || node.Line != mapping.Content[i].Line {
+			return nil, errors.New("currentContext must be a single line value")
+		}
+		lines := bytes.SplitAfter(data, []byte("\n"))
+		line := lines[node.Line-1]
+		eol := line[len(bytes.TrimRight(line, "\r\n")):]
+		updated := append([]byte{}, line[:node.Column-1]...)
+		updated = append(updated, value...)
+		if node.LineComment != "" {
+			updated = append(updated, []byte(" "+node.LineComment)...)
+		}
+		lines[node.Line-1] = append(updated, eol...)
+		return bytes.Join(lines, nil), nil
+	}
+
+	indent := 0
+	if len(mapping.Content) > 0 {
+		indent = mapping.Content[0].Column - 1
+	}
+	ret := append([]byte{}, data...)
+	if len(ret) > 0 && !bytes.HasSuffix(ret, []byte("\n")) {
+		ret = append(ret, '\n')
+	}
+	ret = append(ret, []byte(strings.Repeat(" ", indent)+"currentContext: ")...)
+	ret = append(ret, value...)
+	return append(ret, '\n'), nil
+}
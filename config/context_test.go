@@ -0,0 +1,161 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyContext(t *testing.T) {
+	contexts := []ManagementClusterContext{
+		{Name: "minikube", Kubeconfig: "/home/metal3/.kube/config", ArtifactsPath: "/tmp/_artifacts/minikube"},
+		{Name: "lab", KubeContext: "lab-admin", Variables: map[string]string{"FOO": "lab"}},
+	}
+	tests := []struct {
+		name           string
+		context        string
+		currentContext string
+		want           Metal3CtlConfig
+		wantErr        bool
+	}{
+		{
+			name:           "current context is applied",
+			currentContext: "minikube",
+			want: Metal3CtlConfig{
+				ManagementClusterName: "minikube",
+				Kubeconfig:            "/home/metal3/.kube/config",
+				ArtifactsPath:         "/tmp/_artifacts/minikube",
+				Variables:             map[string]string{"FOO": "foo", "BAR": "bar"},
+				CurrentContext:        "minikube",
+			},
+			wantErr: false,
+		},
+		{
+			name:           "selected context overrides the current one and the variables",
+			context:        "lab",
+			currentContext: "minikube",
+			want: Metal3CtlConfig{
+				ManagementClusterName: "lab",
+				Kubeconfig:            "/home/metal3/.kube/lab",
+				KubeContext:           "lab-admin",
+				ArtifactsPath:         "/tmp/_artifacts",
+				Variables:             map[string]string{"FOO": "lab", "BAR": "bar"},
+				CurrentContext:        "lab",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "no context selected",
+			wantErr: true,
+		},
+		{
+			name:    "unknown context",
+			context: "production",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Metal3CtlConfig{
+				ManagementClusterName: "default",
+				Kubeconfig:            "/home/metal3/.kube/lab",
+				ArtifactsPath:         "/tmp/_artifacts",
+				Variables:             map[string]string{"FOO": "foo", "BAR": "bar"},
+				Contexts:              contexts,
+				CurrentContext:        tt.currentContext,
+			}
+			errs := c.applyContext(tt.context)
+			if (len(errs) > 0) != tt.wantErr {
+				t.Fatalf("applyContext() errors = %v, wantErr %v", errs, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			c.Contexts = nil
+			if !reflect.DeepEqual(*c, tt.want) {
+				t.Errorf("applyContext() = %+v, want %+v", *c, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCurrentContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		context string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "current context is replaced",
+			data: "# metal3ctl config\n" +
+				"managementClusterName: default # legacy field\n" +
+				"currentContext: minikube # the active context\n" +
+				"contexts:\n" +
+				"  - name: minikube\n",
+			context: "lab",
+			want: "# metal3ctl config\n" +
+				"managementClusterName: default # legacy field\n" +
+				"currentContext: lab # the active context\n" +
+				"contexts:\n" +
+				"  - name: minikube\n",
+		},
+		{
+			name:    "quoted current context is replaced",
+			data:    "currentContext: \"minikube\"\r\nkubeconfig: ~/.kube/config\r\n",
+			context: "lab",
+			want:    "currentContext: lab\r\nkubeconfig: ~/.kube/config\r\n",
+		},
+		{
+			name:    "current context is added",
+			data:    "include:\n  - contexts.yaml\n",
+			context: "lab",
+			want:    "include:\n  - contexts.yaml\ncurrentContext: lab\n",
+		},
+		{
+			name:    "current context is added to a file without a trailing newline",
+			data:    "artifactsPath: /tmp/_artifacts",
+			context: "lab",
+			want:    "artifactsPath: /tmp/_artifacts\ncurrentContext: lab\n",
+		},
+		{
+			name:    "context names are quoted if needed",
+			data:    "currentContext: minikube\n",
+			context: "true",
+			want:    "currentContext: \"true\"\n",
+		},
+		{
+			name:    "flow mappings are not supported",
+			data:    "{currentContext: minikube}\n",
+			context: "lab",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SetCurrentContext([]byte(tt.data), tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetCurrentContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("SetCurrentContext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ImageOverride describes how to rewrite a container image reference found in the generated manifests.
+type ImageOverride struct {
+	// Image is the image to be overridden.
+	// It can be a repository (e.g. quay.io/metal3-io/ironic), matching the image regardless of its tag or digest,
+	// or a full image name (e.g. quay.io/metal3-io/ironic:master), matching only that exact reference.
+	Image string `json:"image"`
+
+	// Registry, if set, replaces the registry of the image (e.g. my-registry:5000).
+	Registry string `json:"registry,omitempty"`
+
+	// Repository, if set, replaces the repository of the image, excluding the registry (e.g. metal3-io/ironic).
+	Repository string `json:"repository,omitempty"`
+
+	// Tag, if set, replaces the tag of the image.
+	Tag string `json:"tag,omitempty"`
+
+	// Digest, if set, pins the image to the given digest (e.g. sha256:...); when a Digest is set, the tag is dropped.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ImageReference is a container image reference split into its components.
+type ImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference splits a container image reference into registry, repository, tag and digest.
+// The registry is detected only when explicitly set in the reference, so no default registry is added.
+func ParseImageReference(image string) (ImageReference, error) {
+	ref := ImageReference{}
+	if image == "" || strings.TrimSpace(image) != image {
+		return ref, errors.Errorf("invalid image reference %q", image)
+	}
+
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if ref.Digest == "" {
+			return ref, errors.Errorf("invalid image reference %q: empty digest", image)
+		}
+	}
+	// A tag is separated by the last ":" after the last "/", so we do not mistake a registry port for a tag.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if ref.Tag == "" {
+			return ref, errors.Errorf("invalid image reference %q: empty tag", image)
+		}
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry = first
+			name = name[i+1:]
+		}
+	}
+	if name == "" {
+		return ref, errors.Errorf("invalid image reference %q: empty repository", image)
+	}
+	ref.Repository = name
+	return ref, nil
+}
+
+// Name returns the image name, excluding tag and digest.
+func (r ImageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the full image reference.
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Normalized returns the image reference as reported by the container runtimes, e.g. in the pod status: images
+// without a registry are on docker.io, official docker.io images are in the library namespace and images without
+// a tag or digest use the latest tag.
+func (r ImageReference) Normalized() ImageReference {
+	switch r.Registry {
+	case "", "index.docker.io", "registry-1.docker.io":
+		r.Registry = "docker.io"
+	}
+	if r.Registry == "docker.io" && !strings.Contains(r.Repository, "/") {
+		r.Repository = "library/" + r.Repository
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r
+}
+
+// Matches returns true if the image override applies to the given image reference.
+func (o ImageOverride) Matches(ref ImageReference) bool {
+	match, err := ParseImageReference(o.Image)
+	if err != nil {
+		return false
+	}
+	if match.Name() != ref.Name() {
+		return false
+	}
+	if match.Tag == "" && match.Digest == "" {
+		return true
+	}
+	return match.Tag == ref.Tag && match.Digest == ref.Digest
+}
+
+// Apply returns the image reference rewritten according to the image override.
+func (o ImageOverride) Apply(ref ImageReference) ImageReference {
+	if o.Registry != "" {
+		ref.Registry = o.Registry
+	}
+	if o.Repository != "" {
+		ref.Repository = o.Repository
+	}
+	if o.Tag != "" {
+		ref.Tag = o.Tag
+		ref.Digest = ""
+	}
+	if o.Digest != "" {
+		ref.Digest = o.Digest
+		ref.Tag = ""
+	}
+	return ref
+}
+
+// OverrideImage rewrites the image according to the first matching override in the list;
+// if no override matches, the image is returned unchanged.
+func OverrideImage(image string, overrides []ImageOverride) (string, error) {
+	if len(overrides) == 0 {
+		return image, nil
+	}
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	for _, o := range overrides {
+		if o.Matches(ref) {
+			return o.Apply(ref).String(), nil
+		}
+	}
+	return image, nil
+}
+
+// MirrorImage rewrites the image so it is pulled from the given registry mirror; the mirror is a registry
+// optionally followed by a path prefix (e.g. my-registry:5000/metal3). The original registry of the image is kept as
+// a path component, so images with the same repository in different registries don't collide, e.g.
+// quay.io/metal3-io/ironic:master becomes my-registry:5000/metal3/quay.io/metal3-io/ironic:master.
+// Images without a registry are on docker.io.
+func MirrorImage(image, mirror string) (string, error) {
+	if mirror == "" {
+		return image, nil
+	}
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	normalized := ref.Normalized()
+	ref.Registry = ""
+	ref.Repository = strings.TrimSuffix(mirror, "/") + "/" + registryPathComponent(normalized.Registry) + "/" + normalized.Repository
+	return ref.String(), nil
+}
+
+// registryPathComponent returns the registry as a repository path component; the port separator is not allowed
+// in a path component, so it is replaced with a dash (e.g. localhost:5000 becomes localhost-5000).
+func registryPathComponent(registry string) string {
+	return strings.Replace(strings.ToLower(registry), ":", "-", -1)
+}
+
+// ImageOverridesFor returns the image overrides to be applied to the manifests of the given provider;
+// provider specific overrides take precedence over the global ones.
+func (c *Metal3CtlConfig) ImageOverridesFor(provider ProviderConfig) []ImageOverride {
+	overrides := []ImageOverride{}
+	overrides = append(overrides, provider.ImageOverrides...)
+	overrides = append(overrides, c.ImageOverrides...)
+	return overrides
+}
+
+// ImageResolverFor returns a function rewriting the images in the manifests of the given provider according to
+// the image overrides and to the registry mirror, if any.
+func (c *Metal3CtlConfig) ImageResolverFor(provider ProviderConfig) func(image string) (string, error) {
+	overrides := c.ImageOverridesFor(provider)
+	return func(image string) (string, error) {
+		image, err := OverrideImage(image, overrides)
+		if err != nil {
+			return "", err
+		}
+		return MirrorImage(image, c.RegistryMirror)
+	}
+}
+
+// ImagesToLoad returns the list of container images to load into the mgmt cluster, after applying the image overrides
+// and the registry mirror as for the manifests: the overrides of the provider whose overrides match the image, if any,
+// take precedence over the global ones.
+func (c *Metal3CtlConfig) ImagesToLoad() ([]ContainerImage, error) {
+	images := []ContainerImage{}
+	for _, image := range c.Images {
+		provider, err := c.providerForImage(image.Name)
+		if err != nil {
+			return nil, err
+		}
+		name, err := c.ImageResolverFor(provider)(image.Name)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, ContainerImage{Name: name})
+	}
+	return images, nil
+}
+
+// providerForImage returns the first provider with an image override matching the image; if there is no such
+// provider, an empty provider is returned, so only the global overrides apply.
+func (c *Metal3CtlConfig) providerForImage(image string) (ProviderConfig, error) {
+	ref, err := ParseImageReference(image)
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	providers := append([]ProviderConfig{c.BMOProvider}, c.CAPIProviders...)
+	for _, provider := range providers {
+		for _, o := range provider.ImageOverrides {
+			if o.Matches(ref) {
+				return provider, nil
+			}
+		}
+	}
+	return ProviderConfig{}, nil
+}
+
+func validateImageOverrides(overrides []ImageOverride, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, o := range overrides {
+		if o.Image == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("image"), ""))
+		} else if _, err := ParseImageReference(o.Image); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Index(i).Child("image"), o.Image, err.Error()))
+		}
+		if o.Registry == "" && o.Repository == "" && o.Tag == "" && o.Digest == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i), "at least one of registry, repository, tag or digest must be set"))
+		}
+		if o.Tag != "" && o.Digest != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Index(i).Child("digest"), "tag and digest are mutually exclusive"))
+		}
+	}
+	return allErrs
+}
+
+func validateRegistryMirror(mirror string, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if mirror == "" {
+		return allErrs
+	}
+	ref, err := ParseImageReference(strings.TrimSuffix(mirror, "/") + "/image")
+	if err != nil || ref.Tag != "" || ref.Digest != "" || strings.ContainsAny(mirror, " \t@") {
+		allErrs = append(allErrs, field.Invalid(path, mirror, "it should be a registry optionally followed by a path prefix"))
+	}
+	return allErrs
+}
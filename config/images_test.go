@@ -0,0 +1,259 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestOverrideImage(t *testing.T) {
+	type args struct {
+		image     string
+		overrides []ImageOverride
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "no overrides",
+			args: args{
+				image: "quay.io/metal3-io/ironic:master",
+			},
+			want:    "quay.io/metal3-io/ironic:master",
+			wantErr: false,
+		},
+		{
+			name: "repository match changes the tag",
+			args: args{
+				image: "quay.io/metal3-io/cluster-api-provider-metal3:master",
+				overrides: []ImageOverride{
+					{Image: "quay.io/metal3-io/cluster-api-provider-metal3", Tag: "v0.3.0"},
+				},
+			},
+			want:    "quay.io/metal3-io/cluster-api-provider-metal3:v0.3.0",
+			wantErr: false,
+		},
+		{
+			name: "full image match does not apply to other tags",
+			args: args{
+				image: "quay.io/metal3-io/ironic:latest",
+				overrides: []ImageOverride{
+					{Image: "quay.io/metal3-io/ironic:master", Tag: "v1"},
+				},
+			},
+			want:    "quay.io/metal3-io/ironic:latest",
+			wantErr: false,
+		},
+		{
+			name: "registry with port is preserved when changing the tag",
+			args: args{
+				image: "localhost:5000/metal3-io/ironic:master",
+				overrides: []ImageOverride{
+					{Image: "localhost:5000/metal3-io/ironic", Tag: "v1"},
+				},
+			},
+			want:    "localhost:5000/metal3-io/ironic:v1",
+			wantErr: false,
+		},
+		{
+			name: "registry and repository are replaced",
+			args: args{
+				image: "gcr.io/k8s-staging-cluster-api/cluster-api-controller:master",
+				overrides: []ImageOverride{
+					{Image: "gcr.io/k8s-staging-cluster-api/cluster-api-controller", Registry: "mirror.lab:5000", Repository: "capi/controller"},
+				},
+			},
+			want:    "mirror.lab:5000/capi/controller:master",
+			wantErr: false,
+		},
+		{
+			name: "digest replaces the tag",
+			args: args{
+				image: "quay.io/metal3-io/ironic:master",
+				overrides: []ImageOverride{
+					{Image: "quay.io/metal3-io/ironic", Digest: "sha256:abcd"},
+				},
+			},
+			want:    "quay.io/metal3-io/ironic@sha256:abcd",
+			wantErr: false,
+		},
+		{
+			name: "first matching override wins",
+			args: args{
+				image: "quay.io/metal3-io/ironic:master",
+				overrides: []ImageOverride{
+					{Image: "quay.io/metal3-io/ironic", Tag: "provider"},
+					{Image: "quay.io/metal3-io/ironic", Tag: "global"},
+				},
+			},
+			want:    "quay.io/metal3-io/ironic:provider",
+			wantErr: false,
+		},
+		{
+			name: "invalid image",
+			args: args{
+				image: "quay.io/metal3-io/ironic:",
+				overrides: []ImageOverride{
+					{Image: "quay.io/metal3-io/ironic", Tag: "v1"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OverrideImage(tt.args.image, tt.args.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		mirror  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "no mirror",
+			image:  "quay.io/metal3-io/ironic:master",
+			mirror: "",
+			want:   "quay.io/metal3-io/ironic:master",
+		},
+		{
+			name:   "registry is kept as a path component",
+			image:  "quay.io/metal3-io/ironic:master",
+			mirror: "localhost:5000",
+			want:   "localhost:5000/quay.io/metal3-io/ironic:master",
+		},
+		{
+			name:   "same repository in a different registry",
+			image:  "docker.io/metal3-io/ironic:master",
+			mirror: "localhost:5000",
+			want:   "localhost:5000/docker.io/metal3-io/ironic:master",
+		},
+		{
+			name:   "registry with port",
+			image:  "registry.lab:5000/metal3-io/ironic:master",
+			mirror: "localhost:5000",
+			want:   "localhost:5000/registry.lab-5000/metal3-io/ironic:master",
+		},
+		{
+			name:   "mirror with path prefix",
+			image:  "gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+			mirror: "mirror.lab:5000/metal3/",
+			want:   "mirror.lab:5000/metal3/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+		},
+		{
+			name:   "image without registry",
+			image:  "busybox@sha256:abcd",
+			mirror: "mirror.lab",
+			want:   "mirror.lab/docker.io/library/busybox@sha256:abcd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MirrorImage(tt.image, tt.mirror)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImagesToLoad(t *testing.T) {
+	c := &Metal3CtlConfig{
+		Images: []ContainerImage{
+			{Name: "quay.io/metal3-io/cluster-api-provider-metal3:master"},
+			{Name: "quay.io/metal3-io/baremetal-operator:latest"},
+			{Name: "gcr.io/k8s-staging-cluster-api/cluster-api-controller:dev"},
+		},
+		ImageOverrides: []ImageOverride{
+			{Image: "quay.io/metal3-io/cluster-api-provider-metal3", Tag: "global"},
+			{Image: "gcr.io/k8s-staging-cluster-api/cluster-api-controller", Tag: "v0.3.3"},
+		},
+		BMOProvider: ProviderConfig{
+			ImageOverrides: []ImageOverride{{Image: "quay.io/metal3-io/baremetal-operator", Tag: "v0.2.0"}},
+		},
+		CAPIProviders: []ProviderConfig{
+			{ImageOverrides: []ImageOverride{{Image: "quay.io/metal3-io/cluster-api-provider-metal3", Tag: "v0.3.0"}}},
+		},
+		RegistryMirror: "mirror.lab:5000",
+	}
+	want := []string{
+		"mirror.lab:5000/quay.io/metal3-io/cluster-api-provider-metal3:v0.3.0",
+		"mirror.lab:5000/quay.io/metal3-io/baremetal-operator:v0.2.0",
+		"mirror.lab:5000/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+	}
+
+	got, err := c.ImagesToLoad()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d images, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i] {
+			t.Errorf("got = %v, want %v", got[i].Name, want[i])
+		}
+	}
+}
+
+func TestImageReferenceNormalized(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "busybox", want: "docker.io/library/busybox:latest"},
+		{image: "busybox:1.31", want: "docker.io/library/busybox:1.31"},
+		{image: "metal3io/ironic:master", want: "docker.io/metal3io/ironic:master"},
+		{image: "index.docker.io/library/busybox:1.31", want: "docker.io/library/busybox:1.31"},
+		{image: "quay.io/metal3-io/baremetal-operator", want: "quay.io/metal3-io/baremetal-operator:latest"},
+		{image: "localhost:5000/ironic@sha256:abcd", want: "localhost:5000/ironic@sha256:abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			ref, err := ParseImageReference(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ref.Normalized().String(); got != tt.want {
+				t.Errorf("Normalized() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,400 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// includeTimeout is the maximum time for downloading an included config file.
+const includeTimeout = 30 * time.Second
+
+var includeClient = &http.Client{Timeout: includeTimeout}
+
+// configDocument is the YAML node tree of a config file, used for locating the validation errors of the merged
+// config; file is the path or the URL of an included config file, empty for the configuration file itself.
+type configDocument struct {
+	file string
+	root *yamlv3.Node
+}
+
+// resolveIncludes loads the included config files, in order, and merges them into a single config; included files
+// can include other files too. Relative includes are resolved against the path or URL of the including file, and the
+// relative paths defined in included local files against their directory. The documents of the included files are
+// returned sorted by precedence, lowest first.
+func resolveIncludes(ctx context.Context, includes []string, parent string, variables map[string]string, visited map[string]bool) (*Metal3CtlConfig, []configDocument, ConfigErrors, error) {
+	merged := &Metal3CtlConfig{}
+	docs := []configDocument{}
+	configErrs := ConfigErrors{}
+	for _, include := range includes {
+		source, err := includeSource(parent, include, variables)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if visited[source] {
+			return nil, nil, nil, errors.Errorf("include cycle detected: %s includes %s", parent, source)
+		}
+
+		data, err := readInclude(ctx, source)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "error reading the included config file %s", source)
+		}
+		conf, root, errs, err := decodeConfig(data, source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		configErrs = append(configErrs, errs...)
+		if !strings.Contains(source, "://") {
+			conf.rebasePaths(filepath.Dir(source))
+		}
+
+		if len(conf.Include) > 0 {
+			visited[source] = true
+			base, includeDocs, errs, err := resolveIncludes(ctx, conf.Include, source, conf.Variables, visited)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			delete(visited, source)
+			configErrs = append(configErrs, errs...)
+			docs = append(docs, includeDocs...)
+			mergeConfig(base, conf)
+			conf = base
+		}
+		docs = append(docs, configDocument{file: source, root: root})
+		mergeConfig(merged, conf)
+	}
+	return merged, docs, configErrs, nil
+}
+
+// includeSource returns the absolute path or the URL of an included config file; ${VAR} and ${VAR:=default} are
+// expanded using the variables of the including file.
+func includeSource(parent, include string, variables map[string]string) (string, error) {
+	expanded, err := ExpandVariables([]byte(include), variables)
+	if err != nil {
+		return "", errors.Wrapf(err, "error expanding variables in include %q", include)
+	}
+	include = strings.TrimPrefix(string(expanded), "file://")
+
+	if strings.Contains(include, "://") {
+		return include, nil
+	}
+	if strings.Contains(parent, "://") {
+		base, err := url.Parse(parent)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid URL %q", parent)
+		}
+		ref, err := url.Parse(include)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid include %q", include)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if !filepath.IsAbs(include) && parent != "" {
+		include = filepath.Join(filepath.Dir(parent), include)
+	}
+	return filepath.Abs(include)
+}
+
+func readInclude(ctx context.Context, source string) ([]byte, error) {
+	if !strings.Contains(source, "://") {
+		return ioutil.ReadFile(source)
+	}
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := includeClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// rebasePaths resolves the relative paths of an included config file against the directory of the file, so they
+// don't depend on the directory metal3ctl is run from. Paths starting with ~ or with a variable are left unchanged,
+// and kustomize roots are resolved only if they exist locally, as they can be remote targets too.
+func (c *Metal3CtlConfig) rebasePaths(dir string) {
+	rebase := func(path *string) {
+		if isRelativePath(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	rebase(&c.Kubeconfig)
+	rebase(&c.ArtifactsPath)
+	providers := []*ProviderConfig{&c.BMOProvider}
+	for i := range c.CAPIProviders {
+		providers = append(providers, &c.CAPIProviders[i])
+	}
+	for _, provider := range providers {
+		for i := range provider.Versions {
+			version := &provider.Versions[i]
+			if version.Type != KustomizeSource || pathExists(filepath.Join(dir, version.Value)) {
+				rebase(&version.Value)
+			}
+			rebase(&version.Metadata)
+		}
+		for i := range provider.Files {
+			rebase(&provider.Files[i].SourcePath)
+		}
+		for i := range provider.ClusterTemplates {
+			rebase(&provider.ClusterTemplates[i].SourcePath)
+		}
+	}
+	for i := range c.SecretVariables {
+		rebase(&c.SecretVariables[i].ValueFrom.File)
+	}
+	for i := range c.Contexts {
+		rebase(&c.Contexts[i].Kubeconfig)
+		rebase(&c.Contexts[i].ArtifactsPath)
+	}
+}
+
+func isRelativePath(path string) bool {
+	return path != "" && !filepath.IsAbs(path) && !strings.HasPrefix(path, "~") && !strings.HasPrefix(path, "$") &&
+		!strings.Contains(path, "://")
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// mergeConfig merges overlay into base. Fields set in overlay override the ones in base; lists of named items,
+// e.g. providers, versions, replacements, waiters, files, images, image overrides and contexts, are merged by name,
+// and maps, e.g. variables, by key. The merged config gets the TypeMeta of overlay, the including file, so it is
+// encoded with the same apiVersion and kind.
+func mergeConfig(base, overlay *Metal3CtlConfig) {
+	base.TypeMeta = overlay.TypeMeta
+	mergeString(&base.ManagementClusterName, overlay.ManagementClusterName)
+	mergeString(&base.Kubeconfig, overlay.Kubeconfig)
+	mergeString(&base.KubeContext, overlay.KubeContext)
+	mergeString(&base.ArtifactsPath, overlay.ArtifactsPath)
+	mergeString(&base.RegistryMirror, overlay.RegistryMirror)
+	mergeString(&base.CurrentContext, overlay.CurrentContext)
+
+	for _, image := range overlay.Images {
+		found := false
+		for _, i := range base.Images {
+			found = found || i.Name == image.Name
+		}
+		if !found {
+			base.Images = append(base.Images, image)
+		}
+	}
+	base.ImageOverrides = mergeImageOverrides(base.ImageOverrides, overlay.ImageOverrides)
+
+	for _, provider := range overlay.CAPIProviders {
+		found := false
+		for i := range base.CAPIProviders {
+			if base.CAPIProviders[i].Name == provider.Name && base.CAPIProviders[i].Type == provider.Type {
+				mergeProvider(&base.CAPIProviders[i], provider)
+				found = true
+			}
+		}
+		if !found {
+			base.CAPIProviders = append(base.CAPIProviders, provider)
+		}
+	}
+	mergeProvider(&base.BMOProvider, overlay.BMOProvider)
+
+	base.Variables = mergeMap(base.Variables, overlay.Variables)
+	base.BMOVariables = mergeMap(base.BMOVariables, overlay.BMOVariables)
+	for _, variable := range overlay.SecretVariables {
+		found := false
+		for i := range base.SecretVariables {
+			if base.SecretVariables[i].Name == variable.Name {
+				base.SecretVariables[i] = variable
+				found = true
+			}
+		}
+		if !found {
+			base.SecretVariables = append(base.SecretVariables, variable)
+		}
+	}
+	if overlay.ProvisioningNetwork != nil {
+		base.ProvisioningNetwork = overlay.ProvisioningNetwork
+	}
+
+	for _, context := range overlay.Contexts {
+		found := false
+		for i := range base.Contexts {
+			if base.Contexts[i].Name == context.Name {
+				base.Contexts[i] = context
+				found = true
+			}
+		}
+		if !found {
+			base.Contexts = append(base.Contexts, context)
+		}
+	}
+}
+
+func mergeProvider(base *ProviderConfig, overlay ProviderConfig) {
+	mergeString(&base.Name, overlay.Name)
+	mergeString(&base.Type, overlay.Type)
+
+	for _, version := range overlay.Versions {
+		if version.Default {
+			for i := range base.Versions {
+				base.Versions[i].Default = false
+			}
+		}
+		found := false
+		for i := range base.Versions {
+			if base.Versions[i].Name == version.Name {
+				mergeVersion(&base.Versions[i], version)
+				found = true
+			}
+		}
+		if !found {
+			base.Versions = append(base.Versions, version)
+		}
+	}
+
+	for _, file := range overlay.Files {
+		found := false
+		for i := range base.Files {
+			if fileKey(base.Files[i]) == fileKey(file) {
+				base.Files[i] = file
+				found = true
+			}
+		}
+		if !found {
+			base.Files = append(base.Files, file)
+		}
+	}
+
+	for _, template := range overlay.ClusterTemplates {
+		found := false
+		for i := range base.ClusterTemplates {
+			if base.ClusterTemplates[i].Flavor == template.Flavor {
+				base.ClusterTemplates[i] = template
+				found = true
+			}
+		}
+		if !found {
+			base.ClusterTemplates = append(base.ClusterTemplates, template)
+		}
+	}
+
+	for _, waiter := range overlay.Waiters {
+		found := false
+		for i := range base.Waiters {
+			if base.Waiters[i].Name == waiter.Name && base.Waiters[i].Type == waiter.Type {
+				base.Waiters[i] = waiter
+				found = true
+			}
+		}
+		if !found {
+			base.Waiters = append(base.Waiters, waiter)
+		}
+	}
+
+	base.ImageOverrides = mergeImageOverrides(base.ImageOverrides, overlay.ImageOverrides)
+	if overlay.Ironic != nil {
+		base.Ironic = overlay.Ironic
+	}
+	mergeString(&base.TargetNamespace, overlay.TargetNamespace)
+	mergeString(&base.WatchingNamespace, overlay.WatchingNamespace)
+	if len(overlay.Instances) > 0 {
+		base.Instances = overlay.Instances
+	}
+}
+
+func mergeVersion(base *ComponentSource, overlay ComponentSource) {
+	mergeString(&base.Value, overlay.Value)
+	if overlay.Type != "" {
+		base.Type = overlay.Type
+	}
+	base.Default = base.Default || overlay.Default
+	mergeString(&base.Contract, overlay.Contract)
+	mergeString(&base.Metadata, overlay.Metadata)
+
+	for _, replacement := range overlay.Replacements {
+		found := false
+		for i := range base.Replacements {
+			if base.Replacements[i].Old == replacement.Old {
+				base.Replacements[i] = replacement
+				found = true
+			}
+		}
+		if !found {
+			base.Replacements = append(base.Replacements, replacement)
+		}
+	}
+}
+
+// mergeImageOverrides merges image overrides by image; as the first matching override applies, the overrides
+// in overlay come first.
+func mergeImageOverrides(base, overlay []ImageOverride) []ImageOverride {
+	if len(overlay) == 0 {
+		return base
+	}
+	merged := append([]ImageOverride{}, overlay...)
+	for _, o := range base {
+		found := false
+		for _, m := range overlay {
+			found = found || m.Image == o.Image
+		}
+		if !found {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+func mergeMap(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
+
+func mergeString(base *string, overlay string) {
+	if overlay != "" {
+		*base = overlay
+	}
+}
+
+// fileKey identifies a file by its target name, defaulting to the base name of the source path.
+func fileKey(file Files) string {
+	if file.TargetName != "" {
+		return file.TargetName
+	}
+	return filepath.Base(file.SourcePath)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadMetal3CtlConfigWithIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metal3ctl-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.yaml": validConfig + `variables:
+  FOO: base
+  BAR: base
+`,
+		"cycle.yaml": `include:
+- metal3ctl.yaml
+`,
+		"bmo/bmo.yaml": `bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.3.0
+    value: deploy/default
+    type: kustomize
+  - name: v0.4.0
+    value: github.com/metal3-io/baremetal-operator/deploy/default?ref=v0.4.0
+    type: kustomize
+  files:
+  - sourcePath: ironic.env
+    kind: ConfigMap
+    name: ironic-bmo-configmap
+`,
+		"bmo/deploy/default/kustomization.yaml": "resources: []\n",
+		"bmo/ironic.env":                        "PROVISIONING_INTERFACE=ironicendpoint\n",
+		"bmo/invalid.yaml": `bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.4.0
+    value: /tmp/baremetal-operator/deploy/default
+  - name: v0.3.0
+    value: /tmp/baremetal-operator/deploy/default
+    replacements:
+    - old: (
+      new: foo
+`,
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    func(c *Metal3CtlConfig) interface{}
+		wantVal interface{}
+		wantErr bool
+		// wantErrMsg is a part of the expected error message, if any.
+		wantErrMsg string
+	}{
+		{
+			name: "versions are merged by name",
+			data: `include:
+- base.yaml
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions:
+  - name: v0.3.2
+    value: https://example.com/v0.3.2/core-components.yaml
+    replacements:
+    - old: foo
+      new: bar
+`,
+			want: func(c *Metal3CtlConfig) interface{} {
+				return []interface{}{len(c.CAPIProviders), c.CAPIProviders[0].Versions}
+			},
+			wantVal: []interface{}{4, []ComponentSource{{
+				Name:         "v0.3.2",
+				Value:        "https://example.com/v0.3.2/core-components.yaml",
+				Type:         URLSource,
+				Replacements: []ComponentReplacement{{Old: "foo", New: "bar"}},
+			}}},
+			wantErr: false,
+		},
+		{
+			name: "apiVersion and kind of the including file are kept",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+include:
+- base.yaml
+`,
+			want: func(c *Metal3CtlConfig) interface{} {
+				return []string{c.APIVersion, c.Kind}
+			},
+			wantVal: []string{GroupVersion, Kind},
+			wantErr: false,
+		},
+		{
+			name: "variables are merged by key",
+			data: `include:
+- base.yaml
+variables:
+  FOO: overlay
+`,
+			want: func(c *Metal3CtlConfig) interface{} {
+				return c.Variables
+			},
+			wantVal: map[string]string{"FOO": "overlay", "BAR": "base"},
+			wantErr: false,
+		},
+		{
+			name: "relative paths are relative to the included file",
+			data: `include:
+- base.yaml
+- bmo/bmo.yaml
+`,
+			want: func(c *Metal3CtlConfig) interface{} {
+				return []string{c.BMOProvider.Versions[0].Value, c.BMOProvider.Versions[1].Value, c.BMOProvider.Files[0].SourcePath}
+			},
+			wantVal: []string{
+				filepath.Join(dir, "bmo", "deploy", "default"),
+				"github.com/metal3-io/baremetal-operator/deploy/default?ref=v0.4.0",
+				filepath.Join(dir, "bmo", "ironic.env"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "validation errors are located in the included file",
+			data: `include:
+- base.yaml
+- bmo/invalid.yaml
+`,
+			wantErr:    true,
+			wantErrMsg: filepath.Join(dir, "bmo", "invalid.yaml") + ":10: bmoProvider.versions[0].replacements[0].old: Invalid value",
+		},
+		{
+			name: "include cycle",
+			data: `include:
+- cycle.yaml
+`,
+			wantErr: true,
+		},
+		{
+			name: "missing include",
+			data: `include:
+- missing.yaml
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMetal3CtlConfig(context.Background(), LoadMetal3CtlConfigInput{
+				ConfigData: []byte(tt.data),
+				ConfigPath: filepath.Join(dir, "metal3ctl.yaml"),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMetal3CtlConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Errorf("LoadMetal3CtlConfig() error = %v, want %q", err, tt.wantErrMsg)
+				}
+				return
+			}
+			if !reflect.DeepEqual(tt.want(got), tt.wantVal) {
+				t.Errorf("LoadMetal3CtlConfig() = %v, want %v", tt.want(got), tt.wantVal)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// IronicMode indicates how Ironic is deployed together with the baremetal-operator.
+type IronicMode string
+
+const (
+	// IronicKeepalivedMode deploys Ironic in the baremetal-operator pod, with keepalived managing the provisioning IP.
+	IronicKeepalivedMode IronicMode = "keepalived"
+
+	// IronicBundledMode deploys Ironic in the baremetal-operator pod, without keepalived.
+	IronicBundledMode IronicMode = "bundled"
+
+	// IronicExternalMode deploys only the baremetal-operator, using an Ironic instance running outside the mgmt cluster.
+	IronicExternalMode IronicMode = "external"
+)
+
+// ironicOverlays are the default baremetal-operator kustomize overlays, relative to the deploy folder, for each Ironic mode.
+var ironicOverlays = map[IronicMode]string{
+	IronicKeepalivedMode: "ironic-keepalived-config",
+	IronicBundledMode:    "default",
+	IronicExternalMode:   "operator",
+}
+
+// IronicConfig describes the Ironic deployment topology used by the baremetal-operator.
+type IronicConfig struct {
+	// Mode is the Ironic deployment topology; it can be keepalived, bundled or external.
+	// Defaults to "keepalived".
+	Mode IronicMode `json:"mode,omitempty"`
+
+	// Overlay is the kustomize overlay, relative to the baremetal-operator deploy folder, used for the selected mode.
+	// When the baremetal-operator version is a kustomize source pointing to the deploy folder or to one of the default
+	// overlays, it is replaced with this overlay.
+	// Defaults to ironic-keepalived-config, default or operator, depending on Mode.
+	Overlay string `json:"overlay,omitempty"`
+
+	// Endpoint is the Ironic API endpoint (e.g. http://172.22.0.2:6385/v1/).
+	// Required when Mode is external.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// InspectorEndpoint is the Ironic Inspector API endpoint (e.g. http://172.22.0.2:5050/v1/).
+	// Required when Mode is external.
+	InspectorEndpoint string `json:"inspectorEndpoint,omitempty"`
+
+	// TLS enables TLS on the Ironic and Ironic Inspector endpoints; metal3ctl generates a self-signed CA and the
+	// server certificates, stores them as Secrets in the BMO namespace and mounts them into the BMO and Ironic containers.
+	// Not supported when Mode is external.
+	TLS bool `json:"tls,omitempty"`
+
+	// BasicAuth enables basic-auth on the Ironic and Ironic Inspector endpoints; metal3ctl generates random credentials,
+	// stores them as Secrets in the BMO namespace and mounts them into the BMO and Ironic containers.
+	// Not supported when Mode is external.
+	BasicAuth bool `json:"basicAuth,omitempty"`
+}
+
+// Defaults assigns default values to the object.
+func (i *IronicConfig) Defaults() {
+	if i.Mode == "" {
+		i.Mode = IronicKeepalivedMode
+	}
+	if i.Overlay == "" {
+		i.Overlay = ironicOverlays[i.Mode]
+	}
+}
+
+// Validate validates the Ironic settings.
+func (i *IronicConfig) Validate() error {
+	return i.validate(field.NewPath("bmoProvider", "ironic")).ToAggregate()
+}
+
+func (i *IronicConfig) validate(path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	endpoints := []struct {
+		name     string
+		endpoint string
+	}{{"endpoint", i.Endpoint}, {"inspectorEndpoint", i.InspectorEndpoint}}
+	switch i.Mode {
+	case IronicKeepalivedMode, IronicBundledMode:
+		for _, e := range endpoints {
+			if e.endpoint != "" {
+				allErrs = append(allErrs, field.Forbidden(path.Child(e.name), fmt.Sprintf("it can be set only when mode is %s", IronicExternalMode)))
+			}
+		}
+	case IronicExternalMode:
+		for _, e := range endpoints {
+			if e.endpoint == "" {
+				allErrs = append(allErrs, field.Required(path.Child(e.name), ""))
+			} else if u, err := url.Parse(e.endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				allErrs = append(allErrs, field.Invalid(path.Child(e.name), e.endpoint, "it should be an http or https URL"))
+			}
+		}
+		if i.TLS {
+			allErrs = append(allErrs, field.Forbidden(path.Child("tls"), "it can be set only when Ironic is deployed in the mgmt cluster"))
+		}
+		if i.BasicAuth {
+			allErrs = append(allErrs, field.Forbidden(path.Child("basicAuth"), "it can be set only when Ironic is deployed in the mgmt cluster"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("mode"), i.Mode, []string{string(IronicKeepalivedMode), string(IronicBundledMode), string(IronicExternalMode)}))
+	}
+	if i.Overlay == "" || filepath.IsAbs(i.Overlay) || strings.HasPrefix(filepath.Clean(i.Overlay), "..") {
+		allErrs = append(allErrs, field.Invalid(path.Child("overlay"), i.Overlay, "it should be a path relative to the deploy folder"))
+	}
+	return allErrs
+}
+
+// IsExternal returns true if BMO uses an Ironic instance running outside the mgmt cluster.
+func (i *IronicConfig) IsExternal() bool {
+	return i != nil && i.Mode == IronicExternalMode
+}
+
+// IsSecured returns true if metal3ctl should generate TLS certificates or basic-auth credentials for Ironic.
+func (i *IronicConfig) IsSecured() bool {
+	return i != nil && (i.TLS || i.BasicAuth)
+}
+
+// Source returns the component source for the selected Ironic mode; if the source is a kustomize source pointing to
+// the baremetal-operator deploy folder or to one of the default overlays, the path is replaced with the selected overlay.
+// Any other source is returned unchanged.
+func (i *IronicConfig) Source(source ComponentSource) ComponentSource {
+	if i == nil || source.Type != KustomizeSource {
+		return source
+	}
+	if isRemoteKustomizeTarget(source.Value) {
+		source.Value = i.remoteOverlay(source.Value)
+		return source
+	}
+	value := strings.TrimSuffix(source.Value, "/")
+	base := filepath.Base(value)
+	switch {
+	case base == "deploy":
+		source.Value = filepath.Join(value, i.Overlay)
+	case isIronicOverlay(base):
+		source.Value = filepath.Join(filepath.Dir(value), i.Overlay)
+	}
+	return source
+}
+
+// remoteOverlay replaces the path of a remote kustomize target with the selected overlay. The target is handled as a
+// string, as cleaning it as a file path would break the scheme, the // separating the repository from the subpath,
+// e.g. https://github.com/metal3-io/baremetal-operator//deploy?ref=v0.2.0, and the query.
+func (i *IronicConfig) remoteOverlay(target string) string {
+	value, query := target, ""
+	if idx := strings.Index(value, "?"); idx >= 0 {
+		value, query = value[:idx], value[idx:]
+	}
+	value = strings.TrimSuffix(value, "/")
+	idx := strings.LastIndex(value, "/")
+	if idx < 0 {
+		return target
+	}
+	overlay := filepath.ToSlash(filepath.Clean(i.Overlay))
+	switch base := value[idx+1:]; {
+	case base == "deploy":
+		return value + "/" + overlay + query
+	case isIronicOverlay(base):
+		return value[:idx+1] + overlay + query
+	}
+	return target
+}
+
+// Env returns the Ironic settings to be added to the Ironic ConfigMap used by BMO.
+func (i *IronicConfig) Env() map[string]string {
+	if !i.IsExternal() {
+		return nil
+	}
+	return map[string]string{
+		"IRONIC_ENDPOINT":           i.Endpoint,
+		"IRONIC_INSPECTOR_ENDPOINT": i.InspectorEndpoint,
+	}
+}
+
+// isRemoteKustomizeTarget returns true if target is a remote kustomize target, i.e. a URL, a git repository or a
+// repository hosted on one of the well-known git hosting services.
+func isRemoteKustomizeTarget(target string) bool {
+	if strings.Contains(target, "://") || strings.Contains(target, "?") || strings.HasPrefix(target, "git@") || strings.HasPrefix(target, "git::") {
+		return true
+	}
+	for _, host := range []string{"github.com/", "gitlab.com/", "bitbucket.org/"} {
+		if strings.HasPrefix(target, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIronicOverlay(name string) bool {
+	for _, overlay := range ironicOverlays {
+		if name == overlay {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIronicConfigSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   IronicMode
+		source ComponentSource
+		want   string
+	}{
+		{
+			name:   "local deploy folder",
+			mode:   IronicBundledMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "/src/baremetal-operator/deploy/"},
+			want:   filepath.Join("/src/baremetal-operator/deploy", "default"),
+		},
+		{
+			name:   "local default overlay",
+			mode:   IronicExternalMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "/src/baremetal-operator/deploy/ironic-keepalived-config"},
+			want:   filepath.Join("/src/baremetal-operator/deploy", "operator"),
+		},
+		{
+			name:   "remote deploy folder",
+			mode:   IronicKeepalivedMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "https://github.com/metal3-io/baremetal-operator//deploy?ref=v0.2.0"},
+			want:   "https://github.com/metal3-io/baremetal-operator//deploy/ironic-keepalived-config?ref=v0.2.0",
+		},
+		{
+			name:   "remote default overlay",
+			mode:   IronicExternalMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "github.com/metal3-io/baremetal-operator/deploy/default/?ref=master"},
+			want:   "github.com/metal3-io/baremetal-operator/deploy/operator?ref=master",
+		},
+		{
+			name:   "remote overlay right after the repository",
+			mode:   IronicBundledMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "git::https://example.com/bmo.git//operator"},
+			want:   "git::https://example.com/bmo.git//default",
+		},
+		{
+			name:   "remote target not pointing to the deploy folder",
+			mode:   IronicBundledMode,
+			source: ComponentSource{Type: KustomizeSource, Value: "https://github.com/example/bmo-deploy//custom/?ref=v1"},
+			want:   "https://github.com/example/bmo-deploy//custom/?ref=v1",
+		},
+		{
+			name:   "url source",
+			mode:   IronicBundledMode,
+			source: ComponentSource{Type: URLSource, Value: "https://example.com/deploy"},
+			want:   "https://example.com/deploy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &IronicConfig{Mode: tt.mode}
+			i.Defaults()
+			if got := i.Source(tt.source).Value; got != tt.want {
+				t.Errorf("Source() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// IronicBMOConfigMapName is the name of the ConfigMap with the Ironic settings used by BMO and Ironic.
+	IronicBMOConfigMapName = "ironic-bmo-configmap"
+
+	defaultIronicHTTPPort      = 6180
+	defaultIronicAPIPort       = 6385
+	defaultIronicInspectorPort = 5050
+)
+
+// ProvisioningNetwork describes the provisioning network used by Ironic; it is used for generating the
+// Ironic ConfigMap injected into the baremetal-operator manifest.
+type ProvisioningNetwork struct {
+	// Interface is the name of the provisioning network interface on the mgmt cluster nodes (e.g. provisioning).
+	Interface string `json:"interface"`
+
+	// CIDR is the provisioning network (e.g. 172.22.0.0/24).
+	CIDR string `json:"cidr"`
+
+	// ProvisioningIP is the IP of the Ironic services on the provisioning network; it must be inside CIDR.
+	ProvisioningIP string `json:"provisioningIP"`
+
+	// DHCPRange is the range of IPs leased by the Ironic DHCP server, in the form "first,last" (e.g. 172.22.0.10,172.22.0.100).
+	// The range must be inside CIDR and must not include ProvisioningIP.
+	DHCPRange string `json:"dhcpRange"`
+
+	// URLHost is the host used in the Ironic endpoints and in the deploy image URLs.
+	// Defaults to ProvisioningIP.
+	URLHost string `json:"urlHost,omitempty"`
+
+	// HTTPPort is the port of the Ironic HTTP server serving the deploy images.
+	// Defaults to 6180.
+	HTTPPort int `json:"httpPort,omitempty"`
+
+	// IronicPort is the port of the Ironic API.
+	// Defaults to 6385.
+	IronicPort int `json:"ironicPort,omitempty"`
+
+	// InspectorPort is the port of the Ironic Inspector API.
+	// Defaults to 5050.
+	InspectorPort int `json:"inspectorPort,omitempty"`
+
+	// CacheURL is the URL of an optional image cache (e.g. http://172.22.0.1/images).
+	CacheURL string `json:"cacheURL,omitempty"`
+
+	// FastTrack enables Ironic fast track provisioning.
+	FastTrack bool `json:"fastTrack,omitempty"`
+}
+
+// Defaults assigns default values to the object.
+func (p *ProvisioningNetwork) Defaults() {
+	if p.URLHost == "" {
+		p.URLHost = p.ProvisioningIP
+	}
+	if p.HTTPPort == 0 {
+		p.HTTPPort = defaultIronicHTTPPort
+	}
+	if p.IronicPort == 0 {
+		p.IronicPort = defaultIronicAPIPort
+	}
+	if p.InspectorPort == 0 {
+		p.InspectorPort = defaultIronicInspectorPort
+	}
+}
+
+// Validate validates the provisioning network settings.
+func (p *ProvisioningNetwork) Validate() error {
+	return p.validate(field.NewPath("provisioningNetwork")).ToAggregate()
+}
+
+func (p *ProvisioningNetwork) validate(path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if p.Interface == "" {
+		allErrs = append(allErrs, field.Required(path.Child("interface"), ""))
+	}
+	ports := []struct {
+		name  string
+		value int
+	}{{"httpPort", p.HTTPPort}, {"ironicPort", p.IronicPort}, {"inspectorPort", p.InspectorPort}}
+	for _, port := range ports {
+		if port.value < 1 || port.value > 65535 {
+			allErrs = append(allErrs, field.Invalid(path.Child(port.name), port.value, "not a valid port"))
+		}
+	}
+
+	_, network, err := net.ParseCIDR(p.CIDR)
+	if err != nil {
+		return append(allErrs, field.Invalid(path.Child("cidr"), p.CIDR, err.Error()))
+	}
+	provisioningIP := net.ParseIP(p.ProvisioningIP)
+	if provisioningIP == nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("provisioningIP"), p.ProvisioningIP, "not a valid IP"))
+	} else if !network.Contains(provisioningIP) {
+		allErrs = append(allErrs, field.Invalid(path.Child("provisioningIP"), p.ProvisioningIP, fmt.Sprintf("not in %s", p.CIDR)))
+	}
+
+	rangeIPs := strings.Split(p.DHCPRange, ",")
+	if len(rangeIPs) != 2 {
+		return append(allErrs, field.Invalid(path.Child("dhcpRange"), p.DHCPRange, "it should be in the form first,last"))
+	}
+	first := net.ParseIP(strings.TrimSpace(rangeIPs[0]))
+	last := net.ParseIP(strings.TrimSpace(rangeIPs[1]))
+	switch {
+	case first == nil || last == nil:
+		allErrs = append(allErrs, field.Invalid(path.Child("dhcpRange"), p.DHCPRange, "not a valid IP range"))
+	case !network.Contains(first) || !network.Contains(last):
+		allErrs = append(allErrs, field.Invalid(path.Child("dhcpRange"), p.DHCPRange, fmt.Sprintf("not in %s", p.CIDR)))
+	case bytes.Compare(first.To16(), last.To16()) > 0:
+		allErrs = append(allErrs, field.Invalid(path.Child("dhcpRange"), p.DHCPRange, "the first IP is greater than the last IP"))
+	case provisioningIP != nil && bytes.Compare(first.To16(), provisioningIP.To16()) <= 0 && bytes.Compare(provisioningIP.To16(), last.To16()) <= 0:
+		allErrs = append(allErrs, field.Invalid(path.Child("dhcpRange"), p.DHCPRange, fmt.Sprintf("it overlaps provisioningIP %s", p.ProvisioningIP)))
+	}
+	return allErrs
+}
+
+// IronicEnv returns the Ironic settings to be stored in the Ironic ConfigMap used by BMO and Ironic.
+func (p *ProvisioningNetwork) IronicEnv() map[string]string {
+	_, network, _ := net.ParseCIDR(p.CIDR)
+	prefixLength, _ := network.Mask.Size()
+
+	urlHost := p.URLHost
+	if ip := net.ParseIP(urlHost); ip != nil && ip.To4() == nil {
+		urlHost = fmt.Sprintf("[%s]", urlHost)
+	}
+	httpURL := fmt.Sprintf("http://%s:%d", urlHost, p.HTTPPort)
+
+	env := map[string]string{
+		"HTTP_PORT":                 strconv.Itoa(p.HTTPPort),
+		"PROVISIONING_IP":           p.ProvisioningIP,
+		"PROVISIONING_INTERFACE":    p.Interface,
+		"PROVISIONING_CIDR":         strconv.Itoa(prefixLength),
+		"DHCP_RANGE":                p.DHCPRange,
+		"DEPLOY_KERNEL_URL":         httpURL + "/images/ironic-python-agent.kernel",
+		"DEPLOY_RAMDISK_URL":        httpURL + "/images/ironic-python-agent.initramfs",
+		"IRONIC_ENDPOINT":           fmt.Sprintf("http://%s:%d/v1/", urlHost, p.IronicPort),
+		"IRONIC_INSPECTOR_ENDPOINT": fmt.Sprintf("http://%s:%d/v1/", urlHost, p.InspectorPort),
+		"IRONIC_FAST_TRACK":         strconv.FormatBool(p.FastTrack),
+	}
+	if p.CacheURL != "" {
+		env["CACHEURL"] = p.CacheURL
+	}
+	return env
+}
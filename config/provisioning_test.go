@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestProvisioningNetworkValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		network ProvisioningNetwork
+		wantErr bool
+	}{
+		{
+			name: "valid network",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "172.22.0.0/24",
+				ProvisioningIP: "172.22.0.1",
+				DHCPRange:      "172.22.0.10,172.22.0.100",
+			},
+			wantErr: false,
+		},
+		{
+			name: "provisioning IP outside the CIDR",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "172.22.0.0/24",
+				ProvisioningIP: "172.23.0.1",
+				DHCPRange:      "172.22.0.10,172.22.0.100",
+			},
+			wantErr: true,
+		},
+		{
+			name: "DHCP range outside the CIDR",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "172.22.0.0/24",
+				ProvisioningIP: "172.22.0.1",
+				DHCPRange:      "172.22.0.10,172.22.1.100",
+			},
+			wantErr: true,
+		},
+		{
+			name: "DHCP range overlapping the provisioning IP",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "172.22.0.0/24",
+				ProvisioningIP: "172.22.0.50",
+				DHCPRange:      "172.22.0.10,172.22.0.100",
+			},
+			wantErr: true,
+		},
+		{
+			name: "inverted DHCP range",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "172.22.0.0/24",
+				ProvisioningIP: "172.22.0.1",
+				DHCPRange:      "172.22.0.100,172.22.0.10",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid IPv6 network",
+			network: ProvisioningNetwork{
+				Interface:      "provisioning",
+				CIDR:           "fd2e:6f44:5dd8:b856::/64",
+				ProvisioningIP: "fd2e:6f44:5dd8:b856::1",
+				DHCPRange:      "fd2e:6f44:5dd8:b856::10,fd2e:6f44:5dd8:b856::ff",
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.network.Defaults()
+			err := tt.network.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvisioningNetworkIronicEnv(t *testing.T) {
+	network := ProvisioningNetwork{
+		Interface:      "provisioning",
+		CIDR:           "172.22.0.0/24",
+		ProvisioningIP: "172.22.0.1",
+		DHCPRange:      "172.22.0.10,172.22.0.100",
+	}
+	network.Defaults()
+	env := network.IronicEnv()
+
+	want := map[string]string{
+		"PROVISIONING_CIDR":         "24",
+		"IRONIC_ENDPOINT":           "http://172.22.0.1:6385/v1/",
+		"IRONIC_INSPECTOR_ENDPOINT": "http://172.22.0.1:5050/v1/",
+		"DEPLOY_KERNEL_URL":         "http://172.22.0.1:6180/images/ironic-python-agent.kernel",
+		"IRONIC_FAST_TRACK":         "false",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %v, want %v", k, env[k], v)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// JSONSchema is a JSON Schema (draft-07), limited to the keywords used for describing the metal3ctl configuration file.
+type JSONSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Type        string `json:"type,omitempty"`
+
+	// Enum is the list of the allowed values; only string enums are used in the configuration file.
+	Enum []string `json:"enum,omitempty"`
+
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+
+	// AdditionalProperties is false for objects without unknown fields, or the schema of the values of a map.
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+
+	Items       *JSONSchema            `json:"items,omitempty"`
+	AllOf       []*JSONSchema          `json:"allOf,omitempty"`
+	Definitions map[string]*JSONSchema `json:"definitions,omitempty"`
+}
+
+const (
+	schemaDraft       = "http://json-schema.org/draft-07/schema#"
+	schemaDefinitions = "#/definitions/"
+)
+
+var (
+	// capiProviderTypes are the provider types supported in capiProviders.
+	capiProviderTypes = []string{
+		string(clusterctlv1.CoreProviderType),
+		string(clusterctlv1.BootstrapProviderType),
+		string(clusterctlv1.ControlPlaneProviderType),
+		string(clusterctlv1.InfrastructureProviderType),
+	}
+
+	// bmoProviderTypes are the provider types supported in bmoProvider.
+	bmoProviderTypes = []string{"BareMetalOperator"}
+
+	// schemaEnums are the values supported by the enum types used in the configuration file.
+	schemaEnums = map[reflect.Type][]string{
+		reflect.TypeOf(ComponentSourceType("")): {string(URLSource), string(KustomizeSource)},
+		reflect.TypeOf(ProviderWaiterType("")):  {string(ApiServiceWaiter), string(DeploymentWaiter)},
+		reflect.TypeOf(IronicMode("")):          {string(IronicKeepalivedMode), string(IronicBundledMode), string(IronicExternalMode)},
+		reflect.TypeOf(FileKind("")):            {string(ConfigMapFile), string(SecretFile)},
+	}
+
+	// schemaTypeNames are the names of the JSON types used in the validation errors.
+	schemaTypeNames = map[string]string{
+		"object":  "an object",
+		"array":   "an array",
+		"string":  "a string",
+		"integer": "an integer",
+		"boolean": "a boolean",
+	}
+)
+
+// configSchema is the schema used for validating the configuration files.
+var configSchema = Schema()
+
+// Schema returns the JSON Schema of the metal3ctl configuration file, generated from the Metal3CtlConfig type: each
+// struct is a definition, fields without omitempty are required, unknown fields are not allowed, and enum types and
+// provider types are restricted to the supported values.
+func Schema() *JSONSchema {
+	definitions := map[string]*JSONSchema{}
+	root := typeSchema(reflect.TypeOf(Metal3CtlConfig{}), definitions)
+
+	config := definitions["Metal3CtlConfig"]
+	config.Properties["apiVersion"].Enum = []string{GroupVersion}
+	config.Properties["kind"].Enum = []string{Kind}
+	providerSchema := func(types []string) *JSONSchema {
+		return &JSONSchema{AllOf: []*JSONSchema{
+			{Ref: schemaDefinitions + "ProviderConfig"},
+			{Properties: map[string]*JSONSchema{"type": {Enum: types}}},
+		}}
+	}
+	config.Properties["capiProviders"].Items = providerSchema(capiProviderTypes)
+	config.Properties["bmoProvider"] = providerSchema(bmoProviderTypes)
+
+	return &JSONSchema{
+		Schema:      schemaDraft,
+		Title:       Kind,
+		Description: fmt.Sprintf("metal3ctl configuration file, version %s", GroupVersion),
+		Ref:         root.Ref,
+		Definitions: definitions,
+	}
+}
+
+// SchemaJSON returns the JSON Schema of the metal3ctl configuration file, in JSON format.
+func SchemaJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// typeSchema returns the schema of a type; structs are added to the definitions, and referenced by name.
+func typeSchema(t reflect.Type, definitions map[string]*JSONSchema) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if enum, ok := schemaEnums[t]; ok {
+		return &JSONSchema{Type: "string", Enum: enum}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Slice:
+		return &JSONSchema{Type: "array", Items: typeSchema(t.Elem(), definitions)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: typeSchema(t.Elem(), definitions)}
+	case reflect.Struct:
+		ref := &JSONSchema{Ref: schemaDefinitions + t.Name()}
+		if _, ok := definitions[t.Name()]; ok {
+			return ref
+		}
+		s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, AdditionalProperties: false}
+		definitions[t.Name()] = s
+		addProperties(s, t, definitions)
+		return ref
+	}
+	return &JSONSchema{}
+}
+
+// addProperties adds the fields of a struct type to the schema by json name; fields of embedded structs without a
+// json name are promoted, as in encoding/json.
+func addProperties(s *JSONSchema, t reflect.Type, definitions map[string]*JSONSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addProperties(s, f.Type, definitions)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = typeSchema(f.Type, definitions)
+
+		omitempty := false
+		for _, option := range tag[1:] {
+			omitempty = omitempty || option == "omitempty"
+		}
+		if !omitempty {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// validateSchema validates a YAML node against the schema, returning an error for each unknown field, suggesting the
+// closest known field, for each value of the wrong type or not supported by an enum, and for each missing required
+// field. Null values are accepted for any type, as in encoding/json.
+func (s *JSONSchema) validateSchema(node *yamlv3.Node, root *JSONSchema, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	switch node.Kind {
+	case yamlv3.DocumentNode:
+		for _, n := range node.Content {
+			allErrs = append(allErrs, s.validateSchema(n, root, path)...)
+		}
+		return allErrs
+	case yamlv3.AliasNode:
+		return s.validateSchema(node.Alias, root, path)
+	}
+
+	if s.Ref != "" {
+		allErrs = append(allErrs, root.Definitions[strings.TrimPrefix(s.Ref, schemaDefinitions)].validateSchema(node, root, path)...)
+	}
+	for _, sub := range s.AllOf {
+		allErrs = append(allErrs, sub.validateSchema(node, root, path)...)
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return allErrs
+	}
+
+	switch s.Type {
+	case "":
+	case "object":
+		if node.Kind != yamlv3.MappingNode {
+			return append(allErrs, typeError(node, s.Type, path))
+		}
+	case "array":
+		if node.Kind != yamlv3.SequenceNode {
+			return append(allErrs, typeError(node, s.Type, path))
+		}
+		for i, item := range node.Content {
+			allErrs = append(allErrs, s.Items.validateSchema(item, root, path.Index(i))...)
+		}
+	case "string":
+		if node.Kind != yamlv3.ScalarNode || (node.Tag != "!!str" && node.Tag != "!!timestamp") {
+			return append(allErrs, typeError(node, s.Type, path))
+		}
+	case "integer":
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!int" {
+			return append(allErrs, typeError(node, s.Type, path))
+		}
+	case "boolean":
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!bool" {
+			return append(allErrs, typeError(node, s.Type, path))
+		}
+	}
+
+	if len(s.Enum) > 0 && node.Kind == yamlv3.ScalarNode {
+		supported := false
+		for _, value := range s.Enum {
+			supported = supported || node.Value == value
+		}
+		if !supported {
+			allErrs = append(allErrs, field.NotSupported(path, node.Value, s.Enum))
+		}
+	}
+
+	if node.Kind == yamlv3.MappingNode && (s.Properties != nil || s.AdditionalProperties != nil || len(s.Required) > 0) {
+		allErrs = append(allErrs, s.validateProperties(node, root, path)...)
+	}
+	return allErrs
+}
+
+func (s *JSONSchema) validateProperties(node *yamlv3.Node, root *JSONSchema, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+
+	found := map[string]bool{}
+	for _, pair := range mappingPairs(node) {
+		key, value := pair[0], pair[1]
+		found[key.Value] = true
+		if property, ok := s.Properties[key.Value]; ok {
+			allErrs = append(allErrs, property.validateSchema(value, root, path.Child(key.Value))...)
+			continue
+		}
+		switch additional := s.AdditionalProperties.(type) {
+		case *JSONSchema:
+			allErrs = append(allErrs, additional.validateSchema(value, root, path.Key(key.Value))...)
+		case bool:
+			if additional {
+				continue
+			}
+			detail := "unknown field"
+			if suggestion := closestName(key.Value, names); suggestion != "" {
+				detail = fmt.Sprintf("unknown field, did you mean %q?", suggestion)
+			}
+			allErrs = append(allErrs, field.Forbidden(path.Child(key.Value), detail))
+		}
+	}
+	for _, name := range s.Required {
+		if !found[name] {
+			allErrs = append(allErrs, field.Required(path.Child(name), ""))
+		}
+	}
+	return allErrs
+}
+
+// mappingPairs returns the key/value pairs of a mapping node, including the ones merged with <<.
+func mappingPairs(node *yamlv3.Node) [][2]*yamlv3.Node {
+	pairs := [][2]*yamlv3.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Tag != "!!merge" {
+			pairs = append(pairs, [2]*yamlv3.Node{key, value})
+			continue
+		}
+		if value.Kind == yamlv3.AliasNode {
+			value = value.Alias
+		}
+		merged := []*yamlv3.Node{value}
+		if value.Kind == yamlv3.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			if m.Kind == yamlv3.AliasNode {
+				m = m.Alias
+			}
+			if m.Kind == yamlv3.MappingNode {
+				pairs = append(pairs, mappingPairs(m)...)
+			}
+		}
+	}
+	return pairs
+}
+
+func typeError(node *yamlv3.Node, schemaType string, path *field.Path) *field.Error {
+	value := node.Value
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		value = "object"
+	case yamlv3.SequenceNode:
+		value = "array"
+	}
+	return field.Invalid(path, value, fmt.Sprintf("must be %s", schemaTypeNames[schemaType]))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	if got := schema.Definitions["ComponentSource"].Properties["type"].Enum; !reflect.DeepEqual(got, []string{"url", "kustomize"}) {
+		t.Errorf("ComponentSource.type enum = %q", got)
+	}
+	if got := schema.Definitions["ProviderWaiter"].Properties["type"].Enum; !reflect.DeepEqual(got, []string{"apiservice", "deployment"}) {
+		t.Errorf("ProviderWaiter.type enum = %q", got)
+	}
+	if got := schema.Definitions["Metal3CtlConfig"].Properties["capiProviders"].Items.AllOf[1].Properties["type"].Enum; !reflect.DeepEqual(got, capiProviderTypes) {
+		t.Errorf("capiProviders.type enum = %q", got)
+	}
+	if got := schema.Definitions["ProviderConfig"].Required; !reflect.DeepEqual(got, []string{"name", "type"}) {
+		t.Errorf("ProviderConfig required = %q", got)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr []string
+	}{
+		{
+			name: "valid config",
+			data: validConfig,
+		},
+		{
+			name: "anchors and merge keys",
+			data: validConfig + `contexts:
+- &lab
+  name: lab
+  variables:
+    REGISTRY: registry.lab:5000
+- <<: *lab
+  name: lab2
+`,
+		},
+		{
+			name: "types, enums and required fields",
+			data: `managementClusterName: [mgmt]
+capiProviders:
+  name: cluster-api
+bmoProvider:
+  name: baremetal-operator
+  type: BMO
+  versions:
+  - value: /tmp/baremetal-operator/deploy
+    type: git
+  ironic:
+    tls: "yes"
+variables:
+  RETRIES: 3
+`,
+			wantErr: []string{
+				`managementClusterName: Invalid value: "array": must be a string`,
+				`capiProviders: Invalid value: "object": must be an array`,
+				`bmoProvider.versions[0].type: Unsupported value: "git": supported values: "url", "kustomize"`,
+				`bmoProvider.versions[0].name: Required value`,
+				`bmoProvider.ironic.tls: Invalid value: "yes": must be a boolean`,
+				`bmoProvider.type: Unsupported value: "BMO": supported values: "BareMetalOperator"`,
+				`variables[RETRIES]: Invalid value: "3": must be a string`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &yamlv3.Node{}
+			if err := yamlv3.Unmarshal([]byte(tt.data), root); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, err := range configSchema.validateSchema(root, configSchema, nil) {
+				got = append(got, err.Error())
+			}
+			if len(got) == 0 && tt.wantErr == nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("validateSchema() errors = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// RedactedValue replaces the values of the secret variables in the output of metal3ctl.
+const RedactedValue = "<redacted>"
+
+// SecretVariable is a variable whose value is read, when needed, from an environment variable, a file or a Kubernetes
+// Secret; the value is never written to the artifacts path, and it is redacted in the output of metal3ctl.
+type SecretVariable struct {
+	// Name is the name of the variable.
+	Name string `json:"name"`
+
+	// ValueFrom is the source of the value of the variable.
+	ValueFrom VariableSource `json:"valueFrom"`
+}
+
+// VariableSource is the source of the value of a secret variable; exactly one of the fields must be set.
+type VariableSource struct {
+	// Env is the name of the environment variable holding the value.
+	Env string `json:"env,omitempty"`
+
+	// File is the path of the file holding the value; the trailing newline, if any, is removed.
+	File string `json:"file,omitempty"`
+
+	// SecretKeyRef selects a key of a Secret in the mgmt cluster.
+	SecretKeyRef *SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// SecretKeySelector selects a key of a Secret.
+type SecretKeySelector struct {
+	// Namespace is the namespace of the Secret.
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the Secret.
+	Name string `json:"name"`
+
+	// Key is the key of the Secret holding the value.
+	Key string `json:"key"`
+}
+
+// SecretGetter returns the value of a key of a Secret in the mgmt cluster.
+type SecretGetter func(ctx context.Context, namespace, name, key string) (string, error)
+
+// secretValues are the values of the secret variables resolved by the running command, replaced by Redact.
+var (
+	secretValuesLock sync.Mutex
+	secretValues     = map[string]bool{}
+)
+
+// ResolveSecretVariables returns the values of the secret variables, by name; Secrets are read with getSecret, only if
+// a variable references a Secret. The values are recorded, so they are replaced by Redact.
+func (c *Metal3CtlConfig) ResolveSecretVariables(ctx context.Context, getSecret SecretGetter) (map[string]string, error) {
+	values := map[string]string{}
+	for _, variable := range c.SecretVariables {
+		source := variable.ValueFrom
+		var value string
+		switch {
+		case source.Env != "":
+			v, ok := os.LookupEnv(source.Env)
+			if !ok {
+				return nil, errors.Errorf("failed to read the value of %s: environment variable %s is not set", variable.Name, source.Env)
+			}
+			value = v
+		case source.File != "":
+			data, err := ioutil.ReadFile(source.File)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read the value of %s", variable.Name)
+			}
+			value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		case source.SecretKeyRef != nil:
+			ref := source.SecretKeyRef
+			v, err := getSecret(ctx, ref.Namespace, ref.Name, ref.Key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read the value of %s from Secret %s/%s", variable.Name, ref.Namespace, ref.Name)
+			}
+			value = v
+		}
+		values[variable.Name] = value
+		registerSecretValue(value)
+	}
+	return values, nil
+}
+
+// sensitiveNameParts are the parts of a variable name that suggest the variable holds a secret.
+var sensitiveNameParts = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "CREDENTIAL", "KEY", "AUTH"}
+
+// IsSensitiveVariableName returns true if the name of a variable suggests it holds a secret, e.g. IRONIC_PASSWORD or
+// AWS_SECRET_ACCESS_KEY; the values of those variables should not be copied out of the config file.
+func IsSensitiveVariableName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, part := range sensitiveNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func registerSecretValue(value string) {
+	if value == "" {
+		return
+	}
+	secretValuesLock.Lock()
+	defer secretValuesLock.Unlock()
+	secretValues[value] = true
+}
+
+// ContainsSecretValue returns true if s contains the value of one of the secret variables resolved by the running command.
+func ContainsSecretValue(s string) bool {
+	secretValuesLock.Lock()
+	defer secretValuesLock.Unlock()
+	for value := range secretValues {
+		if strings.Contains(s, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact replaces the values of the secret variables resolved by the running command with RedactedValue.
+func Redact(s string) string {
+	secretValuesLock.Lock()
+	defer secretValuesLock.Unlock()
+	if len(secretValues) == 0 {
+		return s
+	}
+	// Longer values are replaced first, so values containing other values are fully redacted.
+	values := make([]string, 0, len(secretValues))
+	for value := range secretValues {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		s = strings.Replace(s, value, RedactedValue, -1)
+	}
+	return s
+}
+
+// isSensitiveVariable returns true if the value of a variable should be redacted when printing a config, that is if
+// its name suggests it holds a secret or its value contains the value of a secret variable.
+func isSensitiveVariable(name, value string) bool {
+	return IsSensitiveVariableName(name) || ContainsSecretValue(value)
+}
+
+// RedactVariables returns a copy of the variables with the values of the sensitive ones replaced by RedactedValue.
+func RedactVariables(variables map[string]string) map[string]string {
+	if variables == nil {
+		return nil
+	}
+	ret := make(map[string]string, len(variables))
+	for name, value := range variables {
+		if isSensitiveVariable(name, value) {
+			value = RedactedValue
+		}
+		ret[name] = value
+	}
+	return ret
+}
+
+// Redacted returns a copy of the config for printing, with the values of the sensitive variables, including the ones
+// defined in the contexts, replaced by RedactedValue.
+func (c *Metal3CtlConfig) Redacted() *Metal3CtlConfig {
+	ret := *c
+	ret.Variables = RedactVariables(c.Variables)
+	ret.BMOVariables = RedactVariables(c.BMOVariables)
+	if c.Contexts != nil {
+		ret.Contexts = make([]ManagementClusterContext, len(c.Contexts))
+		for i := range c.Contexts {
+			ret.Contexts[i] = c.Contexts[i]
+			ret.Contexts[i].Variables = RedactVariables(c.Contexts[i].Variables)
+		}
+	}
+	return &ret
+}
+
+// RedactConfigFile replaces the values of the sensitive variables of a configuration file, including the ones defined
+// in the contexts, with RedactedValue, preserving comments; the configuration file is returned unchanged if it has no
+// sensitive variables.
+func RedactConfigFile(data []byte) ([]byte, error) {
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, root); err != nil {
+		return nil, errors.Wrapf(err, "error loading the config file")
+	}
+	mapping := documentMapping(root)
+	if mapping == nil {
+		return nil, errors.New("the config file should be a YAML object")
+	}
+	variables := []*yamlv3.Node{mappingValue(mapping, "variables"), mappingValue(mapping, "bmoVariables")}
+	if contexts := mappingValue(mapping, "contexts"); contexts != nil && contexts.Kind == yamlv3.SequenceNode {
+		for _, c := range contexts.Content {
+			variables = append(variables, mappingValue(c, "variables"))
+		}
+	}
+	redacted := false
+	for _, v := range variables {
+		if v == nil || v.Kind != yamlv3.MappingNode {
+			continue
+		}
+		for _, pair := range mappingPairs(v) {
+			name, value := pair[0], pair[1]
+			if value.Kind == yamlv3.ScalarNode && value.Value != RedactedValue && isSensitiveVariable(name.Value, value.Value) {
+				value.Value = RedactedValue
+				value.Tag = "!!str"
+				value.Style = 0
+				redacted = true
+			}
+		}
+	}
+	if !redacted {
+		return data, nil
+	}
+	return encodeConfig(root)
+}
+
+func validateSecretVariables(variables []SecretVariable, plain map[string]string, path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	names := map[string]bool{}
+	for i, variable := range variables {
+		variablePath := path.Index(i)
+		switch {
+		case variable.Name == "":
+			allErrs = append(allErrs, field.Required(variablePath.Child("name"), ""))
+		case names[variable.Name]:
+			allErrs = append(allErrs, field.Duplicate(variablePath.Child("name"), variable.Name))
+		default:
+			if _, ok := plain[variable.Name]; ok {
+				allErrs = append(allErrs, field.Invalid(variablePath.Child("name"), variable.Name, "it is already defined in variables"))
+			}
+		}
+		names[variable.Name] = true
+
+		source := variable.ValueFrom
+		sourcePath := variablePath.Child("valueFrom")
+		sources := 0
+		for _, set := range []bool{source.Env != "", source.File != "", source.SecretKeyRef != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			allErrs = append(allErrs, field.Invalid(sourcePath, "", "exactly one of env, file and secretKeyRef must be set"))
+		}
+		if ref := source.SecretKeyRef; ref != nil {
+			refPath := sourcePath.Child("secretKeyRef")
+			for _, f := range []struct {
+				name  string
+				value string
+			}{{"namespace", ref.Namespace}, {"name", ref.Name}, {"key", ref.Key}} {
+				if f.value == "" {
+					allErrs = append(allErrs, field.Required(refPath.Child(f.name), ""))
+				}
+			}
+		}
+	}
+	return allErrs
+}
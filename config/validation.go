@@ -0,0 +1,346 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigError is an error found in the metal3ctl configuration file, located by the line of the field it refers to.
+type ConfigError struct {
+	// File is the included config file the error is found in; empty for the configuration file itself.
+	File string
+
+	// Line is the line of the field in the configuration file; 0 if the field, or any of its parents, is not
+	// defined in the file.
+	Line int
+
+	// Err is the validation error, including the YAML path of the field (e.g. capiProviders[0].versions[1].name).
+	Err *field.Error
+}
+
+func (e ConfigError) Error() string {
+	switch {
+	case e.File != "" && e.Line != 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err.Error())
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Err.Error())
+	case e.Line != 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+	}
+	return e.Err.Error()
+}
+
+// ConfigErrors is the list of all the errors found in a metal3ctl configuration file and in the files it includes,
+// sorted by file and line.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid metal3ctl configuration, %d error(s) found:", len(e))
+	for _, err := range e {
+		b.WriteString("\n  ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// newConfigErrors locates the validation errors in the YAML document they refer to; if the document is nil, the
+// errors are not located.
+func newConfigErrors(root *yamlv3.Node, file string, allErrs field.ErrorList) ConfigErrors {
+	configErrs := make(ConfigErrors, 0, len(allErrs))
+	for _, err := range allErrs {
+		configErr := ConfigError{File: file, Err: err}
+		if root != nil {
+			configErr.Line = lineOf(root, err.Field)
+		}
+		configErrs = append(configErrs, configErr)
+	}
+	return configErrs
+}
+
+// locateConfigErrors locates the validation errors of a config merged from included files; each error is located
+// in the file with the highest precedence defining the field it refers to, as its value is the one used, or else in
+// the configuration file itself, by the closest line. The documents are sorted by precedence, lowest first, and the
+// last one is the configuration file itself.
+func locateConfigErrors(config *Metal3CtlConfig, docs []configDocument, allErrs field.ErrorList) ConfigErrors {
+	if len(docs) == 1 {
+		return newConfigErrors(docs[0].root, docs[0].file, allErrs)
+	}
+	// The indexes of the items of the merged lists can differ from the ones in the files, so the items are matched
+	// by name in the merged config.
+	merged := &yamlv3.Node{}
+	data, err := yaml.Marshal(config)
+	if err == nil {
+		err = yamlv3.Unmarshal(data, merged)
+	}
+	if err != nil {
+		return newConfigErrors(nil, "", allErrs)
+	}
+
+	configErrs := make(ConfigErrors, 0, len(allErrs))
+	for _, err := range allErrs {
+		configErr := ConfigError{Err: err}
+		located := false
+		for i := len(docs) - 1; i >= 0 && !located; i-- {
+			if line, found := locateMergedField(merged, docs[i].root, err.Field); found {
+				configErr.File, configErr.Line = docs[i].file, line
+				located = true
+			}
+		}
+		if !located {
+			configErr.Line = lineOf(docs[len(docs)-1].root, err.Field)
+		}
+		configErrs = append(configErrs, configErr)
+	}
+	return configErrs
+}
+
+// toError returns the errors sorted by file and line, or nil if there are no errors; errors reported both by the
+// schema and by the validation of the config are returned once.
+func (e ConfigErrors) toError() error {
+	if len(e) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	unique := e[:0]
+	for _, err := range e {
+		key := fmt.Sprintf("%s:%d:%s", err.File, err.Line, err.Err.Error())
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, err)
+		}
+	}
+	e = unique
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].File != e[j].File {
+			return e[i].File < e[j].File
+		}
+		return e[i].Line < e[j].Line
+	})
+	return e
+}
+
+// closestName returns the name closest to s, if it is close enough to be a likely typo of s.
+func closestName(s string, names []string) string {
+	sort.Strings(names)
+	closest, closestDistance := "", len(s)/2+1
+	for _, name := range names {
+		if d := levenshtein(strings.ToLower(s), strings.ToLower(name)); d < closestDistance {
+			closest, closestDistance = name, d
+		}
+	}
+	return closest
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// lineOf returns the line of the node identified by a field path (e.g. capiProviders[0].versions[1].name); if the
+// node does not exist, e.g. for a required field, the line of its closest existing parent is returned instead.
+func lineOf(root *yamlv3.Node, path string) int {
+	line := 0
+	node := documentContent(root)
+	for _, token := range splitPath(path) {
+		if node == nil {
+			break
+		}
+		if node.Kind == yamlv3.AliasNode {
+			node = node.Alias
+		}
+		var next *yamlv3.Node
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == token {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				line = next.Line
+			}
+		}
+		node = next
+	}
+	return line
+}
+
+// itemKeys are the fields identifying the items of the lists merged by name, by list; the items of the other lists
+// are identified by name, or by index if they have no name.
+var itemKeys = map[string][]string{
+	"capiProviders":    {"name", "type"},
+	"replacements":     {"old"},
+	"imageOverrides":   {"image"},
+	"clusterTemplates": {"flavor"},
+	"files":            {"targetName"},
+}
+
+// locateMergedField returns the line of a field of the merged config in a YAML document and whether the field is
+// defined in it; list items are matched by the fields identifying them rather than by index.
+func locateMergedField(merged, root *yamlv3.Node, path string) (int, bool) {
+	line := 0
+	m, node := documentContent(merged), documentContent(root)
+	list := ""
+	tokens := splitPath(path)
+	for _, token := range tokens {
+		if m == nil || node == nil {
+			return line, false
+		}
+		if node.Kind == yamlv3.AliasNode {
+			node = node.Alias
+		}
+		var nextM, next *yamlv3.Node
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			nextM = mappingValue(m, token)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == token {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yamlv3.SequenceNode:
+			i, err := strconv.Atoi(token)
+			if err != nil || m.Kind != yamlv3.SequenceNode || i < 0 || i >= len(m.Content) {
+				return line, false
+			}
+			nextM = m.Content[i]
+			next = matchingItem(node, nextM, list, i)
+			if next != nil {
+				line = next.Line
+			}
+		}
+		m, node, list = nextM, next, token
+	}
+	return line, node != nil && len(tokens) > 0
+}
+
+// matchingItem returns the item of a sequence matching an item of the merged config.
+func matchingItem(sequence, item *yamlv3.Node, list string, index int) *yamlv3.Node {
+	keys, ok := itemKeys[list]
+	if !ok {
+		keys = []string{"name"}
+	}
+	want := map[string]string{}
+	for _, key := range keys {
+		if value := mappingValue(item, key); value != nil {
+			want[key] = value.Value
+		}
+	}
+	if len(want) == 0 {
+		if index < len(sequence.Content) {
+			return sequence.Content[index]
+		}
+		return nil
+	}
+	for _, candidate := range sequence.Content {
+		match := true
+		for key, value := range want {
+			v := mappingValue(candidate, key)
+			match = match && v != nil && v.Value == value
+		}
+		if match {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func documentContent(node *yamlv3.Node) *yamlv3.Node {
+	for node != nil && node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	return node
+}
+
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	if mapping == nil || mapping.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// splitPath splits a field path into field names, map keys and indexes.
+func splitPath(path string) []string {
+	tokens := []string{}
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return append(tokens, path[1:])
+			}
+			tokens = append(tokens, path[1:end])
+			path = path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				return append(tokens, path)
+			}
+			tokens = append(tokens, path[:end])
+			path = path[end:]
+		}
+	}
+	return tokens
+}
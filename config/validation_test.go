@@ -0,0 +1,300 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const validConfig = `managementClusterName: mgmt
+kubeconfig: /tmp/kubeconfig
+artifactsPath: /tmp/artifacts
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions:
+  - name: v0.3.2
+    value: https://example.com/core-components.yaml
+    type: url
+- name: kubeadm
+  type: BootstrapProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: ControlPlaneProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: metal3
+  type: InfrastructureProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.3.0
+    value: /tmp/baremetal-operator/deploy/default
+`
+
+func TestLoadMetal3CtlConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr []string
+	}{
+		{
+			name: "valid config",
+			data: validConfig,
+		},
+		{
+			name: "unknown fields are reported with a suggestion",
+			data: validConfig + `  waiter:
+  - name: metal3-baremetal-operator
+registryMirorr: mirror.lab:5000
+`,
+			wantErr: []string{
+				`line 26: bmoProvider.waiter: Forbidden: unknown field, did you mean "waiters"?`,
+				`line 28: registryMirorr: Forbidden: unknown field, did you mean "registryMirror"?`,
+			},
+		},
+		{
+			name: "all validation errors are reported with the closest line",
+			data: `managementClusterName: mgmt
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions:
+  - value: https://example.com/core-components.yaml
+    type: git
+bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.3.0
+    value: /tmp/baremetal-operator/deploy/default
+`,
+			wantErr: []string{
+				`kubeconfig: Required value`,
+				`artifactsPath: Required value`,
+				`line 2: capiProviders: Required value: it is required to have at least one BootstrapProvider`,
+				`line 2: capiProviders: Required value: it is required to have at least one ControlPlaneProvider`,
+				`line 2: capiProviders: Required value: it is required to have at least one InfrastructureProvider`,
+				`line 6: capiProviders[0].versions[0].name: Required value`,
+				`line 7: capiProviders[0].versions[0].type: Unsupported value: "git": supported values: "url", "kustomize"`,
+			},
+		},
+		{
+			name: "secret variables must have exactly one source",
+			data: validConfig + `variables:
+  IRONIC_USERNAME: admin
+secretVariables:
+- name: IRONIC_USERNAME
+  valueFrom:
+    env: IRONIC_USERNAME
+- name: IRONIC_PASSWORD
+  valueFrom:
+    env: IRONIC_PASSWORD
+    file: /tmp/ironic-password
+`,
+			wantErr: []string{
+				`line 29: secretVariables[0].name: Invalid value: "IRONIC_USERNAME": it is already defined in variables`,
+				`line 33: secretVariables[1].valueFrom: Invalid value: "": exactly one of env, file and secretKeyRef must be set`,
+			},
+		},
+		{
+			name: "cluster templates are supported only for infrastructure providers",
+			data: validConfig + `  clusterTemplates:
+  - flavor: ha
+    sourcePath: /tmp/cluster-template-ha.yaml
+`,
+			wantErr: []string{
+				`line 26: bmoProvider.clusterTemplates: Forbidden: cluster templates are supported only for infrastructure providers`,
+			},
+		},
+		{
+			name: "CAPI provider versions must be semantic versions",
+			data: `managementClusterName: mgmt
+kubeconfig: /tmp/kubeconfig
+artifactsPath: /tmp/artifacts
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions:
+  - name: master
+    value: https://example.com/core-components.yaml
+    type: url
+  - name: v0.3.2
+    value: https://example.com/core-components.yaml
+    type: url
+  - name: 0.3.2
+    value: https://example.com/core-components.yaml
+    type: url
+- name: kubeadm
+  type: BootstrapProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: ControlPlaneProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: metal3
+  type: InfrastructureProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: master
+    value: /tmp/baremetal-operator/deploy/default
+`,
+			wantErr: []string{
+				`line 8: capiProviders[0].versions[0].name: Invalid value: "master": must be a semantic version, e.g. v0.3.3`,
+				`line 14: capiProviders[0].versions[2].name: Duplicate value: "0.3.2"`,
+			},
+		},
+		{
+			name: "several providers of the same type must have different names",
+			data: `managementClusterName: mgmt
+kubeconfig: /tmp/kubeconfig
+artifactsPath: /tmp/artifacts
+bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.3.0
+    value: /tmp/baremetal-operator/deploy/default
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: BootstrapProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: talos
+  type: BootstrapProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: ControlPlaneProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: metal3
+  type: InfrastructureProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: docker
+  type: InfrastructureProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: metal3
+  type: InfrastructureProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+`,
+			wantErr: []string{
+				`line 29: capiProviders[6].name: Duplicate value: "metal3"`,
+			},
+		},
+		{
+			name: "CAPI providers must have at least one version",
+			data: `managementClusterName: mgmt
+kubeconfig: /tmp/kubeconfig
+artifactsPath: /tmp/artifacts
+bmoProvider:
+  name: baremetal-operator
+  type: BareMetalOperator
+  versions:
+  - name: v0.3.0
+    value: /tmp/baremetal-operator/deploy/default
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: BootstrapProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: kubeadm
+  type: ControlPlaneProvider
+  versions: [{name: v0.3.2, type: url, value: https://example.com/components.yaml}]
+- name: metal3
+  type: InfrastructureProvider
+  versions: []
+`,
+			wantErr: []string{
+				`line 22: capiProviders[3].versions: Required value: please specify at least one version`,
+			},
+		},
+		{
+			name: "baremetal-operator instances must not overlap",
+			data: validConfig + `  ironic:
+    mode: external
+    endpoint: http://172.22.0.2:6385/v1/
+    inspectorEndpoint: http://172.22.0.2:5050/v1/
+  instances:
+  - targetNamespace: metal3-tenant-a
+    watchingNamespace: tenant-a
+  - targetNamespace: metal3-tenant-a
+    watchingNamespace: tenant-a
+  - targetNamespace: metal3-all
+`,
+			wantErr: []string{
+				`line 33: bmoProvider.instances[1].targetNamespace: Duplicate value: "metal3-tenant-a"`,
+				`line 34: bmoProvider.instances[1].watchingNamespace: Duplicate value: "tenant-a"`,
+				`line 35: bmoProvider.instances[2].watchingNamespace: Required value: it is required when there are several instances, so the watched namespaces don't overlap`,
+			},
+		},
+		{
+			name: "several baremetal-operator instances require an external Ironic",
+			data: validConfig + `  instances:
+  - targetNamespace: metal3-tenant-a
+    watchingNamespace: tenant-a
+  - targetNamespace: metal3-tenant-b
+    watchingNamespace: tenant-b
+`,
+			wantErr: []string{
+				`line 26: bmoProvider.instances: Forbidden: several instances can be used only when the Ironic mode is external`,
+			},
+		},
+		{
+			name: "several baremetal-operator instances with an external Ironic",
+			data: validConfig + `  ironic:
+    mode: external
+    endpoint: http://172.22.0.2:6385/v1/
+    inspectorEndpoint: http://172.22.0.2:5050/v1/
+  instances:
+  - targetNamespace: metal3-tenant-a
+    watchingNamespace: tenant-a
+  - targetNamespace: metal3-tenant-b
+    watchingNamespace: tenant-b
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadMetal3CtlConfig(context.Background(), LoadMetal3CtlConfigInput{ConfigData: []byte(tt.data)})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("LoadMetal3CtlConfig() error = %v", err)
+				}
+				return
+			}
+			configErrs, ok := err.(ConfigErrors)
+			if !ok {
+				t.Fatalf("LoadMetal3CtlConfig() error = %v, want ConfigErrors", err)
+			}
+			got := []string{}
+			for _, e := range configErrs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.wantErr) {
+				t.Errorf("LoadMetal3CtlConfig() errors = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
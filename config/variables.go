@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// variableRegEx matches ${VAR} and ${VAR:=default}, the same syntax supported by clusterctl.
+var variableRegEx = regexp.MustCompile(`\${\s*([A-Za-z_][A-Za-z0-9_]*)\s*(:=([^}]*))?}`)
+
+// ExpandVariables replaces ${VAR} and ${VAR:=default} in data.
+// Values are read from the environment variables first, then from the given variables; if a variable is not
+// defined in either of them, the default value is used, if any.
+// An error listing every unresolved variable is returned if some variables cannot be resolved.
+func ExpandVariables(data []byte, variables map[string]string) ([]byte, error) {
+	missing := map[string]bool{}
+	result := variableRegEx.ReplaceAllFunc(data, func(match []byte) []byte {
+		submatch := variableRegEx.FindSubmatch(match)
+		value, ok := lookupVariable(submatch, variables)
+		if !ok {
+			missing[string(submatch[1])] = true
+			return match
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, errMissingVariables(missing)
+	}
+	return result, nil
+}
+
+func lookupVariable(submatch [][]byte, variables map[string]string) (string, bool) {
+	name := string(submatch[1])
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if value, ok := variables[name]; ok {
+		return value, true
+	}
+	if len(submatch[2]) > 0 {
+		return string(submatch[3]), true
+	}
+	return "", false
+}
+
+func errMissingVariables(missing map[string]bool) error {
+	names := []string{}
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return errors.Errorf("value for variables [%s] is not set. Please set the value using os environment variables or the metal3ctl config file", strings.Join(names, ", "))
+}
+
+// expandPaths applies variable expansion to all the path fields in the configuration, so e.g. ${HOME}
+// can be used instead of hard coding the user home folder.
+func (c *Metal3CtlConfig) expandPaths() error {
+	fields := []*string{&c.Kubeconfig, &c.ArtifactsPath}
+	providers := []*ProviderConfig{&c.BMOProvider}
+	for i := range c.CAPIProviders {
+		providers = append(providers, &c.CAPIProviders[i])
+	}
+	for _, provider := range providers {
+		for i := range provider.Versions {
+			fields = append(fields, &provider.Versions[i].Value, &provider.Versions[i].Metadata)
+		}
+		for i := range provider.Files {
+			fields = append(fields, &provider.Files[i].SourcePath)
+		}
+		for i := range provider.ClusterTemplates {
+			fields = append(fields, &provider.ClusterTemplates[i].SourcePath)
+		}
+	}
+	for i := range c.SecretVariables {
+		fields = append(fields, &c.SecretVariables[i].ValueFrom.File)
+	}
+
+	missing := map[string]bool{}
+	for _, field := range fields {
+		*field = variableRegEx.ReplaceAllStringFunc(*field, func(match string) string {
+			submatch := variableRegEx.FindSubmatch([]byte(match))
+			value, ok := lookupVariable(submatch, c.Variables)
+			if !ok {
+				missing[string(submatch[1])] = true
+				return match
+			}
+			return value
+		})
+	}
+	if len(missing) > 0 {
+		return errMissingVariables(missing)
+	}
+	return nil
+}
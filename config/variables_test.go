@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExpandVariables(t *testing.T) {
+	os.Setenv("METAL3CTL_TEST_ENV", "from-env")
+	defer os.Unsetenv("METAL3CTL_TEST_ENV")
+
+	type args struct {
+		data      string
+		variables map[string]string
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        string
+		wantErr     bool
+		wantMissing []string
+	}{
+		{
+			name: "variables are replaced",
+			args: args{
+				data:      "ip: ${PROVISIONING_IP}",
+				variables: map[string]string{"PROVISIONING_IP": "172.22.0.1"},
+			},
+			want: "ip: 172.22.0.1",
+		},
+		{
+			name: "env variables take precedence",
+			args: args{
+				data:      "value: ${METAL3CTL_TEST_ENV}",
+				variables: map[string]string{"METAL3CTL_TEST_ENV": "from-config"},
+			},
+			want: "value: from-env",
+		},
+		{
+			name: "default is used when the variable is not set",
+			args: args{
+				data: "port: ${HTTP_PORT:=6180}",
+			},
+			want: "port: 6180",
+		},
+		{
+			name: "default is ignored when the variable is set",
+			args: args{
+				data:      "port: ${HTTP_PORT:=6180}",
+				variables: map[string]string{"HTTP_PORT": "8080"},
+			},
+			want: "port: 8080",
+		},
+		{
+			name: "empty default",
+			args: args{
+				data: "value: '${EMPTY:=}'",
+			},
+			want: "value: ''",
+		},
+		{
+			name: "all unresolved variables are reported",
+			args: args{
+				data: "${FOO} ${BAR} ${FOO}",
+			},
+			wantErr:     true,
+			wantMissing: []string{"BAR, FOO"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandVariables([]byte(tt.args.data), tt.args.variables)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				for _, m := range tt.wantMissing {
+					if !strings.Contains(err.Error(), m) {
+						t.Errorf("error = %v, should contain %v", err, m)
+					}
+				}
+				return
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
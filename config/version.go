@@ -0,0 +1,186 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// GroupVersion is the latest version of the metal3ctl configuration file format.
+	GroupVersion = "metal3ctl.metal3.io/v1alpha1"
+
+	// Kind is the kind of the metal3ctl configuration file.
+	Kind = "Metal3CtlConfig"
+
+	// LegacyVersion identifies the configuration files written before the format was versioned, that is
+	// without apiVersion and kind.
+	LegacyVersion = "legacy"
+)
+
+// configConversion converts a configuration document to the next version of the format.
+type configConversion struct {
+	next    string
+	convert func(mapping *yamlv3.Node) error
+}
+
+// configConversions are the conversions applied in chain to a configuration document, until GroupVersion is reached.
+// When the format changes, the new version becomes GroupVersion and the conversion from the previous one is added here,
+// so older configuration files keep working.
+var configConversions = map[string]configConversion{
+	LegacyVersion: {next: GroupVersion, convert: convertLegacyConfig},
+}
+
+// convertLegacyConfig converts a legacy configuration document; the format is unchanged, so only apiVersion and kind
+// are added.
+func convertLegacyConfig(mapping *yamlv3.Node) error {
+	mapping.Content = append([]*yamlv3.Node{
+		{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "apiVersion"},
+		{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: GroupVersion},
+		{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "kind"},
+		{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: Kind},
+	}, mapping.Content...)
+	return nil
+}
+
+// convertConfig converts the configuration document to GroupVersion in place, and returns the original version.
+func convertConfig(root *yamlv3.Node) (string, error) {
+	mapping := documentMapping(root)
+	if mapping == nil {
+		return "", errors.New("the config file should be a YAML object")
+	}
+
+	apiVersion, kind := getScalar(mapping, "apiVersion"), getScalar(mapping, "kind")
+	version := apiVersion
+	switch {
+	case apiVersion == "" && kind == "":
+		version = LegacyVersion
+	case kind == "":
+		return "", newConfigErrors(root, "", field.ErrorList{field.Required(field.NewPath("kind"), "")}).toError()
+	case kind != Kind:
+		return "", newConfigErrors(root, "", field.ErrorList{field.NotSupported(field.NewPath("kind"), kind, []string{Kind})}).toError()
+	case apiVersion == "":
+		return "", newConfigErrors(root, "", field.ErrorList{field.Required(field.NewPath("apiVersion"), "")}).toError()
+	}
+
+	for current := version; current != GroupVersion; {
+		conversion, ok := configConversions[current]
+		if !ok {
+			return "", newConfigErrors(root, "", field.ErrorList{field.NotSupported(field.NewPath("apiVersion"), apiVersion, []string{GroupVersion})}).toError()
+		}
+		if err := conversion.convert(mapping); err != nil {
+			return "", newConfigErrors(root, "", field.ErrorList{field.InternalError(field.NewPath("apiVersion"), errors.Wrapf(err, "failed to convert from %s to %s", current, conversion.next))}).toError()
+		}
+		current = conversion.next
+	}
+	return version, nil
+}
+
+// MigrateConfig rewrites a metal3ctl configuration file to GroupVersion, preserving comments; the configuration is
+// returned unchanged if already at GroupVersion.
+func MigrateConfig(data []byte) ([]byte, error) {
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(data, root); err != nil {
+		return nil, errors.Wrapf(err, "error loading the config file")
+	}
+	version, err := convertConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	if version == GroupVersion {
+		return data, nil
+	}
+	return encodeConfig(root)
+}
+
+// DecodeConfig converts a metal3ctl configuration file to GroupVersion and decodes it, without resolving the includes,
+// applying the context or validating the values, e.g. for rewriting the paths of a configuration file; values not
+// matching the schema are reported located in the file.
+func DecodeConfig(data []byte) (*Metal3CtlConfig, error) {
+	config, _, configErrs, err := decodeConfig(data, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := configErrs.toError(); err != nil {
+		return nil, err
+	}
+	config.APIVersion = GroupVersion
+	config.Kind = Kind
+	return config, nil
+}
+
+// encodeConfig converts to yaml a configuration document, using the same indentation of the examples.
+func encodeConfig(root *yamlv3.Node) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yamlv3.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, errors.Wrapf(err, "failed to convert to yaml the config file")
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, errors.Wrapf(err, "failed to convert to yaml the config file")
+	}
+	return b.Bytes(), nil
+}
+
+// documentMapping returns the top level mapping of a YAML document, if any; an empty document is initialized
+// with an empty mapping.
+func documentMapping(root *yamlv3.Node) *yamlv3.Node {
+	if root.Kind == 0 {
+		root.Kind = yamlv3.DocumentNode
+	}
+	if root.Kind != yamlv3.DocumentNode {
+		return nil
+	}
+	if len(root.Content) == 0 {
+		root.Content = append(root.Content, &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"})
+	}
+	if root.Content[0].Kind != yamlv3.MappingNode {
+		return nil
+	}
+	return root.Content[0]
+}
+
+// getScalar returns the value of a scalar field of a YAML mapping; an empty string is returned if the field is missing.
+func getScalar(mapping *yamlv3.Node, key string) string {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// setScalar sets a scalar field of a YAML mapping; missing fields are added at the end of the mapping.
+func setScalar(mapping *yamlv3.Node, key, value string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1].Kind = yamlv3.ScalarNode
+			mapping.Content[i+1].Tag = "!!str"
+			mapping.Content[i+1].Value = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key},
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: value},
+	)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "legacy config is converted, preserving comments",
+			data: `# mgmt cluster
+managementClusterName: mgmt # the name
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+`,
+			want: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+# mgmt cluster
+managementClusterName: mgmt # the name
+capiProviders:
+- name: cluster-api
+  type: CoreProvider
+`,
+			wantErr: false,
+		},
+		{
+			name: "latest config is unchanged",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+capiProviders:
+- name: cluster-api
+`,
+			want: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+capiProviders:
+- name: cluster-api
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown version",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha9
+kind: Metal3CtlConfig
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown kind",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: ClusterctlConfig
+`,
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			data:    `- name: cluster-api`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MigrateConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MigrateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MigrateConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		data              string
+		wantArtifactsPath string
+		wantErr           bool
+	}{
+		{
+			name: "latest config",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+artifactsPath: /tmp/metal3ctl
+`,
+			wantArtifactsPath: "/tmp/metal3ctl",
+			wantErr:           false,
+		},
+		{
+			name:              "legacy config is converted",
+			data:              `artifactsPath: /tmp/metal3ctl`,
+			wantArtifactsPath: "/tmp/metal3ctl",
+			wantErr:           false,
+		},
+		{
+			name: "value not matching the schema",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha1
+kind: Metal3CtlConfig
+bmoProvider: baremetal-operator
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown version",
+			data: `apiVersion: metal3ctl.metal3.io/v1alpha9
+kind: Metal3CtlConfig
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.APIVersion != GroupVersion || got.Kind != Kind {
+				t.Errorf("got %s/%s, want %s/%s", got.APIVersion, got.Kind, GroupVersion, Kind)
+			}
+			if got.ArtifactsPath != tt.wantArtifactsPath {
+				t.Errorf("got artifactsPath %q, want %q", got.ArtifactsPath, tt.wantArtifactsPath)
+			}
+		})
+	}
+}
@@ -4,11 +4,14 @@ go 1.13
 
 require (
 	github.com/MakeNowJust/heredoc v1.0.0
+	github.com/go-logr/logr v0.1.0
 	github.com/metal3-io/baremetal-operator v0.0.0-20200318114549-c3da1db56f43
 	github.com/metal3-io/cluster-api-provider-metal3 v0.3.1
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cobra v0.0.6
+	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6
 	gopkg.in/yaml.v2 v2.2.8
+	gopkg.in/yaml.v3 v3.0.0-20200121175148-a6ecf24a6d71
 	k8s.io/api v0.17.4
 	k8s.io/apiextensions-apiserver v0.17.4
 	k8s.io/apimachinery v0.17.4
@@ -19,6 +19,7 @@ package cluster
 import (
 	"context"
 	"io/ioutil"
+	"strings"
 
 	"github.com/Arvinderpal/metal3ctl/config"
 	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
@@ -26,59 +27,331 @@ import (
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // BMOConfig is the BMO config file that point to the repository created by InstallBMOComponents.
 type BMOConfig struct {
+	Path      string
 	RawYAML   []byte
 	Files     map[string][]byte
 	Variables map[string]interface{}
 }
 
-func InstallBMOComponents(ctx context.Context, conf *config.Metal3CtlConfig) (*BMOConfig, error) {
+// bmoProviderLabel and bmoVersionLabel are added to all the BMO objects, so the installed version can be read
+// from the mgmt cluster.
+const (
+	bmoProviderLabel = "metal3ctl.metal3.io/provider"
+	bmoVersionLabel  = "metal3ctl.metal3.io/version"
+)
+
+// defaultBMONamespace is the namespace of the BMO manifests.
+const defaultBMONamespace = "metal3"
+
+// InstallBMOComponents installs the given BMO version in the mgmt cluster, once for each BMO instance; if versionName
+// is empty, the default version is installed.
+func InstallBMOComponents(ctx context.Context, conf *config.Metal3CtlConfig, versionName string) ([]*BMOConfig, error) {
+	version, err := conf.BMOProvider.GetVersion(versionName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := proxy.NewProxy(conf.Kubeconfig)
+	c, err := p.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create controller-runtime client")
+	}
+
+	bmoConfigs := []*BMOConfig{}
+	for _, instance := range conf.BMOProvider.BMOInstances() {
+		install, err := prepareBMOInstance(ctx, conf, c, version, instance)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyBMOInstance(ctx, conf, p, c, install); err != nil {
+			return nil, err
+		}
+		if err := recordBMOInstance(ctx, conf, p, install); err != nil {
+			return nil, err
+		}
+		bmoConfigs = append(bmoConfigs, install.bmoConfig)
+	}
+	return bmoConfigs, nil
+}
+
+// bmoInstall is the install of a BMO version for a BMO instance, as generated by prepareBMOInstance.
+type bmoInstall struct {
+	instance  config.BMOInstance
+	version   config.ComponentSource
+	bmoConfig *BMOConfig
+	objs      []unstructured.Unstructured
+}
+
+// prepareBMOInstance generates the objects for installing the given BMO version for a BMO instance, and checks that
+// a different version is not already installed.
+func prepareBMOInstance(ctx context.Context, conf *config.Metal3CtlConfig, c client.Client, version config.ComponentSource, instance config.BMOInstance) (*bmoInstall, error) {
+	bmoConfig, objs, err := generateBMOComponents(ctx, conf, version, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := installedBMOVersion(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+	if err != nil {
+		return nil, err
+	}
+	if installed != "" && installed != version.Name {
+		return nil, errors.Errorf("%s version %s is already installed in the mgmt cluster, please use metal3ctl upgrade apply for installing version %s", conf.BMOProvider.Name, installed, version.Name)
+	}
+	if err := keepIronicCredentials(ctx, c, objs); err != nil {
+		return nil, err
+	}
+	return &bmoInstall{instance: instance, version: version, bmoConfig: bmoConfig, objs: objs}, nil
+}
+
+// applyBMOInstance creates the BMO objects in the mgmt cluster and waits for BMO to be ready.
+func applyBMOInstance(ctx context.Context, conf *config.Metal3CtlConfig, p *proxy.Proxy, c client.Client, install *bmoInstall) error {
+	if err := createComponents(ctx, p, install.objs); err != nil {
+		return errors.Wrap(err, "failed to create bmo components in mgmt cluster")
+	}
+	if err := waitForProvider(ctx, c, conf.BMOProvider, install.instance.TargetNamespace, install.objs); err != nil {
+		return errors.Wrap(err, "error waiting for bmo components")
+	}
+	return nil
+}
+
+// recordBMOInstance records the BMO instance in the BMO inventory and in the BMO repository.
+func recordBMOInstance(ctx context.Context, conf *config.Metal3CtlConfig, p *proxy.Proxy, install *bmoInstall) error {
+	if err := writeBMOInventory(ctx, p, conf.BMOProvider, install.instance, install.version.Name, bmoNamespace(install.objs), bmoWatchingNamespace(install.objs)); err != nil {
+		return errors.Wrap(err, "failed to record bmo in the inventory")
+	}
+	if err := writeBMORepository(conf, install.instance, install.version, install.objs, install.bmoConfig); err != nil {
+		return errors.Wrap(err, "failed to write the bmo repository")
+	}
+	return nil
+}
+
+// generateBMOComponents generates the manifest for the given BMO version and BMO instance and returns the list of
+// objects to be created in the mgmt cluster.
+func generateBMOComponents(ctx context.Context, conf *config.Metal3CtlConfig, version config.ComponentSource, instance config.BMOInstance) (*BMOConfig, []unstructured.Unstructured, error) {
 
 	provider := conf.BMOProvider
-	version := provider.Versions[0]
-	// generate component yamls
-	generator := config.ComponentGeneratorForComponentSource(version)
+	// generate component yamls, selecting the kustomize overlay for the Ironic deployment topology, if any
+	generator := config.ComponentGeneratorForComponentSource(provider.Ironic.Source(version))
 	manifest, err := generator.Manifests(ctx)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error generating the manifest for %q / %q", provider.Name, version.Name)
+		return nil, nil, errors.Wrapf(err, "error generating the manifest for %q / %q", provider.Name, version.Name)
 	}
 
 	fileMap := make(map[string][]byte)
 	for _, file := range provider.Files {
 		data, err := ioutil.ReadFile(file.SourcePath)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error reading file %q / %q", provider.Name, file.SourcePath)
+			return nil, nil, errors.Wrapf(err, "error reading file %q / %q", provider.Name, file.SourcePath)
 		}
 		fileMap[file.TargetName] = data
 	}
 
-	// TODO: change to BMOVariables
 	variablesMap := make(map[string]interface{})
-	for key, value := range conf.Variables {
+	for key, value := range conf.BMOVariables {
 		variablesMap[key] = value
 	}
 
+	// replace ${VAR} and ${VAR:=default} in the manifest, using os environment variables, BMOVariables and secret
+	// variables; secret variables are not added to variablesMap, so they are never written to the BMO repository.
+	secretValues, err := resolveSecretVariables(ctx, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	variables := make(map[string]string)
+	for key, value := range conf.BMOVariables {
+		variables[key] = value
+	}
+	for key, value := range secretValues {
+		variables[key] = value
+	}
+	manifest, err = config.ExpandVariables(manifest, variables)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error processing variables in the manifest for %q / %q", provider.Name, version.Name)
+	}
+
 	// transform the manifest to a list of objects
 	objs, err := util.ToUnstructured(manifest)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse yaml")
+		return nil, nil, errors.Wrap(err, "failed to parse yaml")
+	}
+	objs, err = fixBMOInstance(objs, provider, instance)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error setting the namespaces for %q / %q", provider.Name, version.Name)
 	}
 
-	err = createComponents(ctx, proxy.NewProxy(conf.Kubeconfig), objs)
+	if err := resolveImages(objs, conf, provider); err != nil {
+		return nil, nil, errors.Wrapf(err, "error applying image overrides for %q / %q", provider.Name, version.Name)
+	}
+
+	ironicEnv := map[string]string{}
+	if conf.ProvisioningNetwork != nil {
+		for k, v := range conf.ProvisioningNetwork.IronicEnv() {
+			ironicEnv[k] = v
+		}
+	}
+	for k, v := range provider.Ironic.Env() {
+		ironicEnv[k] = v
+	}
+	if len(ironicEnv) > 0 {
+		objs = injectConfigMap(objs, config.IronicBMOConfigMapName, bmoNamespace(objs), ironicEnv)
+	}
+
+	if provider.Ironic.IsSecured() {
+		objs, err = secureIronic(objs, provider.Ironic)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error generating the Ironic credentials for %q / %q", provider.Name, version.Name)
+		}
+	}
+
+	fileObjs, err := bmoFileObjects(provider.Files, fileMap, bmoNamespace(objs))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create bmo components in mgmt cluster")
+		return nil, nil, errors.Wrapf(err, "error generating the objects for %q files", provider.Name)
+	}
+	objs = append(objs, fileObjs...)
+
+	for i := range objs {
+		labels := objs[i].GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[bmoProviderLabel] = provider.Name
+		labels[bmoVersionLabel] = version.Name
+		objs[i].SetLabels(labels)
 	}
 
 	return &BMOConfig{
 		RawYAML:   manifest,
 		Files:     fileMap,
 		Variables: variablesMap,
-	}, nil
+	}, objs, nil
+}
+
+// installedBMOVersion returns the BMO version installed in the mgmt cluster, as recorded in the BMO inventory or, for
+// installs not recorded in the inventory, in the labels of the BMO deployments; if namespace is not
+// empty, only the BMO instance installed in that namespace is considered. If BMO is not installed, an empty string
+// is returned.
+func installedBMOVersion(ctx context.Context, c client.Client, provider config.ProviderConfig, namespace string) (string, error) {
+	inventory, err := getBMOInventory(ctx, c, provider, namespace)
+	if err != nil {
+		return "", err
+	}
+	if inventory != nil {
+		return inventory.Version, nil
+	}
+
+	deployments := &unstructured.UnstructuredList{}
+	deployments.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DeploymentList"})
+	listOpts := []client.ListOption{client.MatchingLabels{bmoProviderLabel: provider.Name}}
+	if namespace != "" {
+		listOpts = append(listOpts, client.InNamespace(namespace))
+	}
+	if err := c.List(ctx, deployments, listOpts...); err != nil {
+		return "", errors.Wrapf(err, "failed to list the %s deployments", provider.Name)
+	}
+	for _, deployment := range deployments.Items {
+		if version := deployment.GetLabels()[bmoVersionLabel]; version != "" {
+			return version, nil
+		}
+	}
+	return "", nil
+}
+
+// installedBMOComponents returns the objects of a BMO instance installed in the mgmt cluster; they are read from the
+// BMO repository if it has the installed version, otherwise they are generated for the installed version.
+func installedBMOComponents(ctx context.Context, c client.Client, conf *config.Metal3CtlConfig, instance config.BMOInstance) ([]unstructured.Unstructured, error) {
+	installed, err := installedBMOVersion(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := readBMORepository(conf, instance, installed)
+	if err != nil || objs != nil {
+		return objs, err
+	}
+	version, err := conf.BMOProvider.GetVersion(installed)
+	if err != nil {
+		return nil, err
+	}
+	_, objs, err = generateBMOComponents(ctx, conf, version, instance)
+	return objs, err
+}
+
+// bmoNamespace returns the namespace where BMO is installed, as defined in the BMO manifest.
+func bmoNamespace(objs []unstructured.Unstructured) string {
+	for _, obj := range objs {
+		if obj.GetKind() == "Namespace" {
+			return obj.GetName()
+		}
+	}
+	for _, obj := range objs {
+		if obj.GetNamespace() != "" {
+			return obj.GetNamespace()
+		}
+	}
+	return defaultBMONamespace
+}
+
+// injectConfigMap sets the data of the ConfigMap with the given name in the objects; if the manifest already has such
+// a ConfigMap, eventually with the hash suffix added by the kustomize configMapGenerator, the data are merged into it,
+// otherwise a new ConfigMap is added.
+func injectConfigMap(objs []unstructured.Unstructured, name, namespace string, data map[string]string) []unstructured.Unstructured {
+	values := map[string]interface{}{}
+	for k, v := range data {
+		values[k] = v
+	}
+	for i := range objs {
+		obj := &objs[i]
+		if obj.GetKind() != "ConfigMap" || !isGeneratedName(obj.GetName(), name) {
+			continue
+		}
+		current, _, _ := unstructured.NestedMap(obj.Object, "data")
+		if current == nil {
+			current = map[string]interface{}{}
+		}
+		for k, v := range values {
+			current[k] = v
+		}
+		obj.Object["data"] = current
+		return objs
+	}
+
+	cm := unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	cm.SetName(name)
+	cm.SetNamespace(namespace)
+	cm.Object["data"] = values
+	// The ConfigMap is added right after the namespaces, so it already exists when the deployments are created.
+	return insertAfterNamespaces(objs, cm)
+}
+
+// insertAfterNamespaces adds the new objects right after the namespaces defined at the beginning of the objects.
+func insertAfterNamespaces(objs []unstructured.Unstructured, newObjs ...unstructured.Unstructured) []unstructured.Unstructured {
+	pos := 0
+	for pos < len(objs) && objs[pos].GetKind() == "Namespace" {
+		pos++
+	}
+	ret := append([]unstructured.Unstructured{}, objs[:pos]...)
+	ret = append(ret, newObjs...)
+	return append(ret, objs[pos:]...)
+}
+
+// isGeneratedName returns true if name is equal to base, or to base plus the hash suffix added by kustomize generators.
+func isGeneratedName(name, base string) bool {
+	if name == base {
+		return true
+	}
+	if !strings.HasPrefix(name, base+"-") {
+		return false
+	}
+	suffix := strings.TrimPrefix(name, base+"-")
+	return len(suffix) == 10 && !strings.Contains(suffix, "-")
 }
 
 func createComponents(ctx context.Context, p *proxy.Proxy, objs []unstructured.Unstructured) error {
@@ -124,29 +397,59 @@ func createComponents(ctx context.Context, p *proxy.Proxy, objs []unstructured.U
 	return nil
 }
 
+// DeleteBMOComponents deletes all the BMO instances from the mgmt cluster; the BMO CRDs are shared by the instances, so
+// they are deleted only together with the last instance.
 func DeleteBMOComponents(ctx context.Context, conf *config.Metal3CtlConfig, options *DeleteOptions) error {
 	// TODO: Instead of gettitng the objects from the config file, we should instead get them from the cluster itself (see clusterctl approach).
 	// TODO: support --include-crd and --include-namespaces
 
-	version := conf.BMOProvider.Versions[0]
-	// generate component yamls
-	generator := config.ComponentGeneratorForComponentSource(version)
-	manifest, err := generator.Manifests(ctx)
+	p := proxy.NewProxy(conf.Kubeconfig)
+	c, err := p.NewClient()
 	if err != nil {
-		return errors.Wrapf(err, "error generating the manifest for %q / %q", conf.BMOProvider.Name, version.Name)
+		return errors.Wrap(err, "failed to create controller-runtime client")
 	}
-	// transform the manifest to a list of objects
-	objs, err := util.ToUnstructured(manifest)
+	instances, err := installedBMOInstances(ctx, c, conf.BMOProvider)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse yaml")
+		return err
 	}
-	err = deleteComponents(ctx, proxy.NewProxy(conf.Kubeconfig), objs)
-	if err != nil {
-		return errors.Wrap(err, "failed to create bmo components in mgmt cluster")
+	for _, instance := range instances {
+		// Use the objects recorded in the BMO repository at install time, if any.
+		objs, err := installedBMOComponents(ctx, c, conf, instance)
+		if err != nil {
+			return err
+		}
+		inventories, err := listBMOInventory(ctx, c, &conf.BMOProvider)
+		if err != nil {
+			return err
+		}
+		for _, inventory := range inventories {
+			if inventory.Namespace != bmoNamespace(objs) {
+				objs = withoutKind(objs, "CustomResourceDefinition")
+				break
+			}
+		}
+		err = deleteComponents(ctx, p, objs)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete bmo components in mgmt cluster")
+		}
+		if err := deleteBMOInventory(ctx, c, conf.BMOProvider, bmoNamespace(objs)); err != nil {
+			return errors.Wrap(err, "failed to remove bmo from the inventory")
+		}
 	}
 	return nil
 }
 
+// withoutKind returns the objects which are not of the given kind.
+func withoutKind(objs []unstructured.Unstructured, kind string) []unstructured.Unstructured {
+	ret := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		if obj.GetKind() != kind {
+			ret = append(ret, obj)
+		}
+	}
+	return ret
+}
+
 func deleteComponents(ctx context.Context, p *proxy.Proxy, objs []unstructured.Unstructured) error {
 
 	c, err := p.NewClient()
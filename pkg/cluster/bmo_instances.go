@@ -0,0 +1,106 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fixBMOInstance applies the namespaces of a BMO instance to the BMO objects: the objects are moved to the target
+// namespace, and WATCH_NAMESPACE is set in the BMO containers. When several instances are defined, the cluster wide
+// objects of each instance are prefixed with its target namespace, so the instances don't overwrite each other.
+func fixBMOInstance(objs []unstructured.Unstructured, provider config.ProviderConfig, instance config.BMOInstance) ([]unstructured.Unstructured, error) {
+	if instance.TargetNamespace != "" {
+		objs = util.FixTargetNamespace(objs, instance.TargetNamespace, len(provider.Instances) > 0)
+	}
+	if instance.WatchingNamespace == "" {
+		return objs, nil
+	}
+	err := util.VisitContainers(objs, func(_ *unstructured.Unstructured, _, container map[string]interface{}) error {
+		name, _ := container["name"].(string)
+		if !bmoContainers[name] {
+			return nil
+		}
+		env, _ := container["env"].([]interface{})
+		watchNamespace := map[string]interface{}{"name": "WATCH_NAMESPACE", "value": instance.WatchingNamespace}
+		found := false
+		for i, e := range env {
+			if v, ok := e.(map[string]interface{}); ok && v["name"] == "WATCH_NAMESPACE" {
+				// replaces also a valueFrom, e.g. the namespace of the pod
+				env[i] = watchNamespace
+				found = true
+			}
+		}
+		if !found {
+			env = append(env, watchNamespace)
+		}
+		container["env"] = env
+		return nil
+	})
+	return objs, err
+}
+
+// installedBMOInstances returns the BMO instances the commands acting on an installed BMO, e.g. delete, upgrade and
+// ironic rotate-credentials, work on: the instances defined in the config or, if the config defines neither
+// instances nor a target namespace, the instances recorded in the BMO inventory, so the instances installed with
+// init --target-namespace and --watching-namespace are found as well.
+func installedBMOInstances(ctx context.Context, c client.Client, provider config.ProviderConfig) ([]config.BMOInstance, error) {
+	if len(provider.Instances) > 0 || provider.TargetNamespace != "" {
+		return provider.BMOInstances(), nil
+	}
+	inventories, err := listBMOInventory(ctx, c, &provider)
+	if err != nil {
+		return nil, err
+	}
+	if len(inventories) == 0 {
+		return provider.BMOInstances(), nil
+	}
+	instances := []config.BMOInstance{}
+	for _, inventory := range inventories {
+		instance := config.BMOInstance{TargetNamespace: inventory.TargetNamespace, WatchingNamespace: provider.WatchingNamespace}
+		if instance.WatchingNamespace == "" {
+			instance.WatchingNamespace = inventory.WatchingNamespace
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// bmoRepositoryPath returns the path of the BMO repository folder of an instance; instances with a target namespace
+// have their own folder, so the objects installed by each instance are recorded separately.
+func bmoRepositoryPath(conf *config.Metal3CtlConfig, instance config.BMOInstance) string {
+	repositoryPath := util.GetBMORepositoryPath(conf.ArtifactsPath)
+	if instance.TargetNamespace == "" {
+		return repositoryPath
+	}
+	return filepath.Join(repositoryPath, instance.TargetNamespace)
+}
+
+// bmoInstanceName returns the name of a BMO instance, used in the messages for the user.
+func bmoInstanceName(provider config.ProviderConfig, instance config.BMOInstance) string {
+	if instance.TargetNamespace == "" {
+		return provider.Name
+	}
+	return fmt.Sprintf("%s in namespace %s", provider.Name, instance.TargetNamespace)
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	clusterctlembedded "sigs.k8s.io/cluster-api/cmd/clusterctl/config"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// bmoTargetNamespaceAnnotation records on the inventory record the target namespace of a BMO instance, as given in
+	// the config or with init --target-namespace.
+	bmoTargetNamespaceAnnotation = "metal3ctl.metal3.io/target-namespace"
+
+	// hiddenBMOInventoryFileName is the file, in the BMO repository, where the inventory records are saved while they
+	// are hidden from clusterctl, so they can be restored if metal3ctl is interrupted.
+	hiddenBMOInventoryFileName = "hidden-inventory.yaml"
+)
+
+// BMOInventory is the record of a BMO instance installed in the mgmt cluster. It is stored as a clusterctl Provider
+// in the namespace of the instance, so clusterctl and kubectl show which BMO version is installed and where.
+type BMOInventory struct {
+	// Name is the name of the BMO provider.
+	Name string
+
+	// Type is the type of the BMO provider.
+	Type string
+
+	// Version is the installed BMO version.
+	Version string
+
+	// Namespace is the namespace where the BMO instance is installed.
+	Namespace string
+
+	// TargetNamespace is the target namespace of the BMO instance, as given in the config or with init
+	// --target-namespace; empty means the namespace defined in the BMO manifest.
+	TargetNamespace string
+
+	// WatchingNamespace is the namespace watched by the BMO instance; empty means all namespaces.
+	WatchingNamespace string
+}
+
+// newBMOInventory returns the inventory record of the BMO instance installed in the given namespace.
+func newBMOInventory(provider config.ProviderConfig, instance config.BMOInstance, version, namespace, watchingNamespace string) *clusterctlv1.Provider {
+	return &clusterctlv1.Provider{
+		TypeMeta: metav1.TypeMeta{APIVersion: clusterctlv1.GroupVersion.String(), Kind: "Provider"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      provider.Name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				clusterctlv1.ClusterctlLabelName: "",
+				bmoProviderLabel:                 provider.Name,
+				bmoVersionLabel:                  version,
+			},
+			Annotations: map[string]string{
+				bmoTargetNamespaceAnnotation: instance.TargetNamespace,
+			},
+		},
+		ProviderName:     provider.Name,
+		Type:             provider.Type,
+		Version:          version,
+		WatchedNamespace: watchingNamespace,
+	}
+}
+
+// isBMOInventory returns true if a clusterctl Provider is the inventory record of a BMO instance.
+func isBMOInventory(provider clusterctlv1.Provider) bool {
+	_, ok := provider.Labels[bmoProviderLabel]
+	return ok || provider.Type == bmoProviderType
+}
+
+// writeBMOInventory records the BMO instance installed in the given namespace in the clusterctl provider inventory;
+// the inventory CRD is installed if missing, e.g. when BMO is installed before the CAPI providers.
+func writeBMOInventory(ctx context.Context, p *proxy.Proxy, provider config.ProviderConfig, instance config.BMOInstance, version, namespace, watchingNamespace string) error {
+	crd, err := clusterctlembedded.Asset(embeddedInventoryCRDPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the clusterctl inventory CRD embedded in clusterctl")
+	}
+	crdObjs, err := util.ToUnstructured(crd)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse yaml")
+	}
+	if err := createComponents(ctx, p, crdObjs); err != nil {
+		return errors.Wrap(err, "failed to create the clusterctl inventory CRD")
+	}
+
+	inventory := newBMOInventory(provider, instance, version, namespace, watchingNamespace)
+	// The client is created again on each attempt, so it discovers the inventory CRD as soon as it is established.
+	return wait.PollImmediate(time.Second, time.Minute, func() (bool, error) {
+		c, err := p.NewClient()
+		if err != nil {
+			return false, err
+		}
+		if err := createOrUpdateBMOInventory(ctx, c, inventory.DeepCopy()); err != nil {
+			if meta.IsNoMatchError(errors.Cause(err)) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// createOrUpdateBMOInventory creates the given inventory record, or updates the existing one.
+func createOrUpdateBMOInventory(ctx context.Context, c client.Client, inventory *clusterctlv1.Provider) error {
+	current := &clusterctlv1.Provider{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: inventory.Namespace, Name: inventory.Name}, current)
+	switch {
+	case apierrors.IsNotFound(err):
+		if err := c.Create(ctx, inventory); err != nil {
+			return errors.Wrapf(err, "failed to create the inventory record for %s", inventory.ProviderName)
+		}
+		return nil
+	case err != nil:
+		return errors.Wrapf(err, "failed to get the inventory record for %s", inventory.ProviderName)
+	}
+	inventory.ResourceVersion = current.ResourceVersion
+	if err := c.Update(ctx, inventory); err != nil {
+		return errors.Wrapf(err, "failed to update the inventory record for %s", inventory.ProviderName)
+	}
+	return nil
+}
+
+// listBMOInventory returns the inventory records of all the BMO instances, sorted by namespace; if provider is not
+// nil, only the records of the given provider are returned.
+func listBMOInventory(ctx context.Context, c client.Client, provider *config.ProviderConfig) ([]BMOInventory, error) {
+	records, err := listBMOInventoryRecords(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	inventories := []BMOInventory{}
+	for _, record := range records {
+		if provider != nil && record.ProviderName != provider.Name {
+			continue
+		}
+		inventories = append(inventories, BMOInventory{
+			Name:              record.ProviderName,
+			Type:              record.Type,
+			Version:           record.Version,
+			Namespace:         record.Namespace,
+			TargetNamespace:   record.Annotations[bmoTargetNamespaceAnnotation],
+			WatchingNamespace: record.WatchedNamespace,
+		})
+	}
+	sort.Slice(inventories, func(i, j int) bool { return inventories[i].Namespace < inventories[j].Namespace })
+	return inventories, nil
+}
+
+// listBMOInventoryRecords returns the clusterctl Providers recording BMO instances; if the clusterctl inventory CRD
+// is not installed, no records are returned.
+func listBMOInventoryRecords(ctx context.Context, c client.Client) ([]clusterctlv1.Provider, error) {
+	providers := &clusterctlv1.ProviderList{}
+	if err := c.List(ctx, providers); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to list the clusterctl inventory")
+	}
+	records := []clusterctlv1.Provider{}
+	for _, provider := range providers.Items {
+		if isBMOInventory(provider) {
+			records = append(records, provider)
+		}
+	}
+	return records, nil
+}
+
+// getBMOInventory returns the inventory record of the BMO instance installed in the given namespace, or of any BMO
+// instance if namespace is empty; if the instance is not recorded in the inventory, nil is returned.
+func getBMOInventory(ctx context.Context, c client.Client, provider config.ProviderConfig, namespace string) (*BMOInventory, error) {
+	inventories, err := listBMOInventory(ctx, c, &provider)
+	if err != nil {
+		return nil, err
+	}
+	for i := range inventories {
+		if namespace == "" || inventories[i].Namespace == namespace {
+			return &inventories[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// deleteBMOInventory removes the record of the BMO instance installed in the given namespace from the inventory.
+func deleteBMOInventory(ctx context.Context, c client.Client, provider config.ProviderConfig, namespace string) error {
+	inventory := &clusterctlv1.Provider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      provider.Name,
+			Namespace: namespace,
+		},
+	}
+	if err := c.Delete(ctx, inventory); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return errors.Wrapf(err, "failed to delete the inventory record for %s", provider.Name)
+	}
+	return nil
+}
+
+// withoutBMOInventory runs f, e.g. a clusterctl operation, with the BMO inventory records temporarily removed:
+// clusterctl resolves every record in the inventory against its own provider repositories, and the baremetal-operator
+// is not a clusterctl provider type. The records are saved in the BMO repository before being removed and restored
+// even if f fails; records left hidden by an interrupted run are restored first.
+func withoutBMOInventory(ctx context.Context, conf *config.Metal3CtlConfig, f func() error) error {
+	c, err := proxy.NewProxy(conf.Kubeconfig).NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create controller-runtime client")
+	}
+	if err := restoreHiddenBMOInventory(ctx, c, conf.ArtifactsPath); err != nil {
+		return err
+	}
+	records, err := listBMOInventoryRecords(ctx, c)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return f()
+	}
+	if err := saveHiddenBMOInventory(conf.ArtifactsPath, records); err != nil {
+		return err
+	}
+
+	errList := []error{}
+	for i := range records {
+		record := &records[i]
+		logf.Log.V(3).Info("Hiding the inventory record from clusterctl", "Provider", record.ProviderName, "Namespace", record.Namespace)
+		if err := c.Delete(ctx, record); err != nil && !apierrors.IsNotFound(err) {
+			errList = append(errList, errors.Wrapf(err, "failed to delete the inventory record for %s", record.ProviderName))
+			break
+		}
+	}
+	if len(errList) == 0 {
+		if err := f(); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if err := restoreHiddenBMOInventory(ctx, c, conf.ArtifactsPath); err != nil {
+		errList = append(errList, err)
+	}
+	return kerrors.NewAggregate(errList)
+}
+
+// hiddenBMOInventoryPath returns the path of the file where the hidden inventory records are saved.
+func hiddenBMOInventoryPath(artifactsPath string) string {
+	return filepath.Join(util.GetBMORepositoryPath(artifactsPath), hiddenBMOInventoryFileName)
+}
+
+// saveHiddenBMOInventory saves the inventory records about to be hidden from clusterctl.
+func saveHiddenBMOInventory(artifactsPath string, records []clusterctlv1.Provider) error {
+	list := &clusterctlv1.ProviderList{}
+	for _, record := range records {
+		list.Items = append(list.Items, clusterctlv1.Provider{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        record.Name,
+				Namespace:   record.Namespace,
+				Labels:      record.Labels,
+				Annotations: record.Annotations,
+			},
+			ProviderName:     record.ProviderName,
+			Type:             record.Type,
+			Version:          record.Version,
+			WatchedNamespace: record.WatchedNamespace,
+		})
+	}
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the bmo inventory")
+	}
+	path := hiddenBMOInventoryPath(artifactsPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create the bmo repository folder %q", filepath.Dir(path))
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to save the bmo inventory in %q", path)
+	}
+	return nil
+}
+
+// restoreHiddenBMOInventory restores the inventory records saved by saveHiddenBMOInventory, if any; records
+// recreated in the meantime, e.g. by a later install, are kept. The saved records are removed only once all of them
+// are restored, so a failed restore is retried by the next run.
+func restoreHiddenBMOInventory(ctx context.Context, c client.Client, artifactsPath string) error {
+	path := hiddenBMOInventoryPath(artifactsPath)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the hidden bmo inventory %q", path)
+	}
+	list := &clusterctlv1.ProviderList{}
+	if err := yaml.Unmarshal(data, list); err != nil {
+		return errors.Wrapf(err, "failed to parse the hidden bmo inventory %q", path)
+	}
+	errList := []error{}
+	for i := range list.Items {
+		record := &list.Items[i]
+		logf.Log.V(3).Info("Restoring the inventory record", "Provider", record.ProviderName, "Namespace", record.Namespace)
+		if err := c.Create(ctx, record); err != nil && !apierrors.IsAlreadyExists(err) {
+			errList = append(errList, errors.Wrapf(err, "failed to restore the inventory record for %s from %q", record.ProviderName, path))
+		}
+	}
+	if len(errList) > 0 {
+		return kerrors.NewAggregate(errList)
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "failed to remove the hidden bmo inventory %q", path)
+	}
+	return nil
+}
+
+// bmoWatchingNamespace returns the namespace watched by BMO, as defined by the WATCH_NAMESPACE variable of the BMO
+// container; an empty string means all namespaces.
+func bmoWatchingNamespace(objs []unstructured.Unstructured) string {
+	watchingNamespace := ""
+	_ = util.VisitContainers(objs, func(_ *unstructured.Unstructured, _, container map[string]interface{}) error {
+		name, _ := container["name"].(string)
+		if !bmoContainers[name] {
+			return nil
+		}
+		env, _ := container["env"].([]interface{})
+		for _, e := range env {
+			if v, ok := e.(map[string]interface{}); ok && v["name"] == "WATCH_NAMESPACE" {
+				watchingNamespace, _ = v["value"].(string)
+			}
+		}
+		return nil
+	})
+	return watchingNamespace
+}
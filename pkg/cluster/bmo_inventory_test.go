@@ -0,0 +1,148 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/scheme"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testBMOProvider = config.ProviderConfig{Name: "baremetal-operator", Type: bmoProviderType}
+
+func testInventoryObjects() []runtime.Object {
+	return []runtime.Object{
+		newBMOInventory(testBMOProvider, config.BMOInstance{TargetNamespace: "metal3-tenant-b"}, "v0.3.0", "metal3-tenant-b", "tenant-b"),
+		newBMOInventory(testBMOProvider, config.BMOInstance{}, "v0.2.0", "metal3", ""),
+		&clusterctlv1.Provider{
+			ObjectMeta:   metav1.ObjectMeta{Name: "infrastructure-metal3", Namespace: "capm3-system"},
+			ProviderName: "metal3",
+			Type:         string(clusterctlv1.InfrastructureProviderType),
+			Version:      "v0.3.0",
+		},
+	}
+}
+
+func TestListBMOInventory(t *testing.T) {
+	other := config.ProviderConfig{Name: "other-operator", Type: bmoProviderType}
+	tests := []struct {
+		name     string
+		provider *config.ProviderConfig
+		want     []BMOInventory
+	}{
+		{
+			name: "all the BMO instances, sorted by namespace",
+			want: []BMOInventory{
+				{Name: "baremetal-operator", Type: bmoProviderType, Version: "v0.2.0", Namespace: "metal3"},
+				{Name: "baremetal-operator", Type: bmoProviderType, Version: "v0.3.0", Namespace: "metal3-tenant-b", TargetNamespace: "metal3-tenant-b", WatchingNamespace: "tenant-b"},
+			},
+		},
+		{
+			name:     "only the instances of the given provider",
+			provider: &other,
+			want:     []BMOInventory{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, testInventoryObjects()...)
+			got, err := listBMOInventory(context.Background(), c, tt.provider)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listBMOInventory() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreHiddenBMOInventory(t *testing.T) {
+	tests := []struct {
+		name string
+		// recreated are the records created again while the records were hidden.
+		recreated []runtime.Object
+	}{
+		{
+			name: "hidden records are restored",
+		},
+		{
+			name:      "records recreated in the meantime are kept",
+			recreated: testInventoryObjects()[:1],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-inventory")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			ctx := context.Background()
+			c := fake.NewFakeClientWithScheme(scheme.Scheme, testInventoryObjects()...)
+			records, err := listBMOInventoryRecords(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := listBMOInventory(ctx, c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := saveHiddenBMOInventory(dir, records); err != nil {
+				t.Fatal(err)
+			}
+			for i := range records {
+				if err := c.Delete(ctx, &records[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, obj := range tt.recreated {
+				if err := c.Create(ctx, obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := restoreHiddenBMOInventory(ctx, c, dir); err != nil {
+				t.Fatal(err)
+			}
+			got, err := listBMOInventory(ctx, c, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got restored records %+v, want %+v", got, want)
+			}
+			if _, err := os.Stat(hiddenBMOInventoryPath(dir)); !os.IsNotExist(err) {
+				t.Errorf("the hidden records were not removed after the restore: %v", err)
+			}
+			// nothing left to restore
+			if err := restoreHiddenBMOInventory(ctx, c, dir); err != nil {
+				t.Errorf("restoreHiddenBMOInventory() without hidden records error = %v", err)
+			}
+		})
+	}
+}
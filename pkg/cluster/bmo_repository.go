@@ -0,0 +1,270 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// BMORepositoryConfig is the content of the bmo-config.yaml file written into the BMO repository folder; it
+// records the inputs used at install time.
+type BMORepositoryConfig struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version"`
+	Components string            `json:"components"`
+	Files      []string          `json:"files,omitempty"`
+	Variables  map[string]string `json:"variables,omitempty"`
+}
+
+// bmoFileObjects returns the ConfigMaps and Secrets materialising the BMO files.
+func bmoFileObjects(files []config.Files, fileMap map[string][]byte, defaultNamespace string) ([]unstructured.Unstructured, error) {
+	type objKey struct {
+		kind      config.FileKind
+		name      string
+		namespace string
+	}
+	keys := []objKey{}
+	data := map[objKey]map[string]string{}
+	for _, file := range files {
+		key := objKey{kind: file.Kind, name: file.Name, namespace: file.Namespace}
+		if key.namespace == "" {
+			key.namespace = defaultNamespace
+		}
+		if _, ok := data[key]; !ok {
+			keys = append(keys, key)
+			data[key] = map[string]string{}
+		}
+
+		values := map[string]string{file.TargetName: string(fileMap[file.TargetName])}
+		if file.Env {
+			var err error
+			values, err = parseEnvFile(fileMap[file.TargetName])
+			if err != nil {
+				return nil, errors.Wrapf(err, "error parsing env file %q", file.SourcePath)
+			}
+		}
+		for k, v := range values {
+			if file.Kind == config.SecretFile {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			data[key][k] = v
+		}
+	}
+
+	objs := []unstructured.Unstructured{}
+	for _, key := range keys {
+		values := map[string]interface{}{}
+		for k, v := range data[key] {
+			values[k] = v
+		}
+		obj := unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind(string(key.kind))
+		obj.SetName(key.name)
+		obj.SetNamespace(key.namespace)
+		obj.Object["data"] = values
+		if key.kind == config.SecretFile {
+			obj.Object["type"] = "Opaque"
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// parseEnvFile reads a list of KEY=VALUE lines; empty lines and lines starting with # are ignored.
+func parseEnvFile(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("invalid line %q, it should be in the form KEY=VALUE", line)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values, scanner.Err()
+}
+
+// writeBMORepository writes the BMO objects and files used at install time into the BMO repository folder of the
+// instance under the artifacts path, so delete and upgrade can use the exact same inputs.
+func writeBMORepository(conf *config.Metal3CtlConfig, instance config.BMOInstance, version config.ComponentSource, objs []unstructured.Unstructured, bmoConfig *BMOConfig) error {
+	versionPath := filepath.Join(bmoRepositoryPath(conf, instance), version.Name)
+	if err := os.MkdirAll(versionPath, 0755); err != nil {
+		return errors.Wrapf(err, "error creating the repository folder for %q / %q", conf.BMOProvider.Name, version.Name)
+	}
+
+	componentsPath := filepath.Join(versionPath, "components.yaml")
+	if err := writeBMORepositoryManifest(componentsPath, objs); err != nil {
+		return errors.Wrapf(err, "error writing manifest for %q / %q", conf.BMOProvider.Name, version.Name)
+	}
+
+	files := []string{}
+	for name, data := range bmoConfig.Files {
+		filePath := filepath.Join(versionPath, name)
+		if err := ioutil.WriteFile(filePath, data, 0600); err != nil {
+			return errors.Wrapf(err, "error writing file %q / %q", conf.BMOProvider.Name, name)
+		}
+		files = append(files, filePath)
+	}
+	sort.Strings(files)
+
+	variables := map[string]string{}
+	for k, v := range bmoConfig.Variables {
+		variables[k], _ = v.(string)
+	}
+	data, err := yaml.Marshal(BMORepositoryConfig{
+		Name:       conf.BMOProvider.Name,
+		Version:    version.Name,
+		Components: componentsPath,
+		Files:      files,
+		Variables:  variables,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the bmo config file")
+	}
+	bmoConfig.Path = filepath.Join(bmoRepositoryPath(conf, instance), util.BMO_CONFIG_FILENAME)
+	if err := ioutil.WriteFile(bmoConfig.Path, data, 0644); err != nil {
+		return errors.Wrap(err, "error writing the bmo config file")
+	}
+	return nil
+}
+
+// updateBMORepositoryManifest replaces the BMO objects recorded for the given version in the BMO repository folder of
+// the instance, e.g. after rotating the Ironic credentials; if the version was not recorded, nothing is written.
+func updateBMORepositoryManifest(conf *config.Metal3CtlConfig, instance config.BMOInstance, version string, objs []unstructured.Unstructured) error {
+	componentsPath := filepath.Join(bmoRepositoryPath(conf, instance), version, "components.yaml")
+	if _, err := os.Stat(componentsPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "error reading manifest for %q / %q", conf.BMOProvider.Name, version)
+	}
+	if err := writeBMORepositoryManifest(componentsPath, objs); err != nil {
+		return errors.Wrapf(err, "error updating manifest for %q / %q", conf.BMOProvider.Name, version)
+	}
+	return nil
+}
+
+// writeBMORepositoryManifest writes the BMO objects into the components file of the BMO repository; the objects are
+// recorded without secrets, see redactBMORepositoryObjects, and the file is readable only by the owner.
+func writeBMORepositoryManifest(componentsPath string, objs []unstructured.Unstructured) error {
+	manifest, err := util.FromUnstructured(redactBMORepositoryObjects(objs))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(componentsPath, manifest, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of an existing file, e.g. written by an older metal3ctl version.
+	return os.Chmod(componentsPath, 0600)
+}
+
+// unredactedFields are the fields identifying an object, or referencing other objects and images, that are never
+// redacted: a secret value could be a common word, e.g. metal3, and redacting it would break the recorded objects.
+var unredactedFields = map[string]bool{
+	"apiVersion": true,
+	"kind":       true,
+	"metadata":   true,
+	"name":       true,
+	"namespace":  true,
+	"secretName": true,
+	"image":      true,
+}
+
+// redactBMORepositoryObjects returns the BMO objects as recorded in the BMO repository, which is used only for
+// finding the installed objects: Secrets, e.g. the Ironic credentials, are recorded without their data, and the
+// values of the secret variables are redacted in the other fields, except in the unredactedFields, so the recorded
+// objects still match the installed ones.
+func redactBMORepositoryObjects(objs []unstructured.Unstructured) []unstructured.Unstructured {
+	ret := make([]unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		obj = *obj.DeepCopy()
+		if obj.GetKind() == "Secret" {
+			unstructured.RemoveNestedField(obj.Object, "data")
+			unstructured.RemoveNestedField(obj.Object, "stringData")
+		}
+		redactValue(obj.Object)
+		ret = append(ret, obj)
+	}
+	return ret
+}
+
+// redactValue redacts the values of the secret variables in the string fields of an object; maps and slices are
+// redacted in place.
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return config.Redact(v)
+	case map[string]interface{}:
+		for key, field := range v {
+			if !unredactedFields[key] {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return value
+}
+
+// readBMORepository reads the BMO objects used at install time for the given version from the BMO repository folder of
+// the instance; if version is empty, the version of the last install is used. If the repository folder does not exist,
+// nil is returned.
+func readBMORepository(conf *config.Metal3CtlConfig, instance config.BMOInstance, version string) ([]unstructured.Unstructured, error) {
+	repositoryPath := bmoRepositoryPath(conf, instance)
+	if version == "" {
+		data, err := ioutil.ReadFile(filepath.Join(repositoryPath, util.BMO_CONFIG_FILENAME))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "error reading the bmo config file")
+		}
+		repositoryConfig := &BMORepositoryConfig{}
+		if err := yaml.Unmarshal(data, repositoryConfig); err != nil {
+			return nil, errors.Wrap(err, "error parsing the bmo config file")
+		}
+		version = repositoryConfig.Version
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(repositoryPath, version, "components.yaml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "error reading manifest for %q / %q", conf.BMOProvider.Name, version)
+	}
+	return util.ToUnstructured(manifest)
+}
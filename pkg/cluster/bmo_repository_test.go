@@ -0,0 +1,116 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRedactBMORepositoryObjects(t *testing.T) {
+	// the secret value is also a common word, so redacting the manifest text would corrupt the identity fields.
+	os.Setenv("METAL3CTL_TEST_IRONIC_PASSWORD", "metal3")
+	defer os.Unsetenv("METAL3CTL_TEST_IRONIC_PASSWORD")
+	conf := &config.Metal3CtlConfig{SecretVariables: []config.SecretVariable{
+		{Name: "IRONIC_PASSWORD", ValueFrom: config.VariableSource{Env: "METAL3CTL_TEST_IRONIC_PASSWORD"}},
+	}}
+	if _, err := conf.ResolveSecretVariables(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	objs, err := util.ToUnstructured([]byte("apiVersion: v1\n" +
+		"kind: Secret\n" +
+		"metadata:\n" +
+		"  name: metal3-ironic-password\n" +
+		"  namespace: metal3\n" +
+		"data:\n" +
+		"  password: bWV0YWwz\n" +
+		"stringData:\n" +
+		"  username: metal3\n" +
+		"---\n" +
+		"apiVersion: apps/v1\n" +
+		"kind: Deployment\n" +
+		"metadata:\n" +
+		"  name: metal3-baremetal-operator\n" +
+		"  namespace: metal3\n" +
+		"spec:\n" +
+		"  template:\n" +
+		"    spec:\n" +
+		"      containers:\n" +
+		"      - name: baremetal-operator\n" +
+		"        image: quay.io/metal3-io/baremetal-operator:metal3\n" +
+		"        args:\n" +
+		"        - --password=metal3\n" +
+		"        env:\n" +
+		"        - name: IRONIC_PASSWORD\n" +
+		"          value: metal3\n" +
+		"      volumes:\n" +
+		"      - name: metal3\n" +
+		"        secret:\n" +
+		"          secretName: metal3-ironic-password\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := redactBMORepositoryObjects(objs)
+
+	tests := []struct {
+		name   string
+		obj    int
+		fields []string
+		want   interface{}
+		found  bool
+	}{
+		{name: "Secret data is removed", obj: 0, fields: []string{"data"}},
+		{name: "Secret stringData is removed", obj: 0, fields: []string{"stringData"}},
+		{name: "metadata is untouched", obj: 1, fields: []string{"metadata", "namespace"}, want: "metal3", found: true},
+		{name: "Secret metadata is untouched", obj: 0, fields: []string{"metadata", "name"}, want: "metal3-ironic-password", found: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, found, _ := unstructured.NestedFieldNoCopy(got[tt.obj].Object, tt.fields...)
+			if found != tt.found || (found && value != tt.want) {
+				t.Errorf("got %v (found %t) for %s, want %v (found %t)", value, found, strings.Join(tt.fields, "."), tt.want, tt.found)
+			}
+		})
+	}
+
+	containers, _, _ := unstructured.NestedSlice(got[1].Object, "spec", "template", "spec", "containers")
+	container := containers[0].(map[string]interface{})
+	if image := container["image"]; image != "quay.io/metal3-io/baremetal-operator:metal3" {
+		t.Errorf("got image %q, the images must not be redacted", image)
+	}
+	if arg := container["args"].([]interface{})[0]; arg != "--password="+config.RedactedValue {
+		t.Errorf("got arg %q, want the secret value redacted", arg)
+	}
+	if env := container["env"].([]interface{})[0].(map[string]interface{}); env["name"] != "IRONIC_PASSWORD" || env["value"] != config.RedactedValue {
+		t.Errorf("got env %v, want the secret value redacted", env)
+	}
+	volumes, _, _ := unstructured.NestedSlice(got[1].Object, "spec", "template", "spec", "volumes")
+	if secretName, _, _ := unstructured.NestedString(volumes[0].(map[string]interface{}), "secret", "secretName"); secretName != "metal3-ironic-password" {
+		t.Errorf("got secretName %q, the references must not be redacted", secretName)
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(objs[0].Object, "data"); !found {
+		t.Errorf("the input objects were changed")
+	}
+}
@@ -0,0 +1,525 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// bundleConfigFileName is the name of the metal3ctl config file inside a bundle.
+	bundleConfigFileName = "metal3ctl.yaml"
+
+	// bundleImagesFolder is the folder inside a bundle where image tarballs are stored.
+	bundleImagesFolder = "images"
+
+	// bundleImagesIndexFileName is the name of the file mapping images to tarballs inside a bundle.
+	bundleImagesIndexFileName = "images.yaml"
+)
+
+// BundleOptions carries the options supported by CreateBundle.
+type BundleOptions struct {
+	// OutputPath is the path of the bundle archive (a .tar.gz file).
+	OutputPath string
+
+	// IncludeImages adds a tarball for each image required for initializing the management cluster to the bundle.
+	IncludeImages bool
+
+	// ContainerTool is the container tool used to pull and save images (e.g. docker or podman).
+	ContainerTool string
+}
+
+// BundleImage maps an image to its tarball inside a bundle.
+type BundleImage struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// CreateBundle renders the BMO and CAPI provider manifests, the local clusterctl repository, the metal3ctl config
+// and optionally the image tarballs into a single archive, that can be installed with init --bundle without
+// resolving any kustomize or URL source.
+func CreateBundle(input config.LoadMetal3CtlConfigInput, options *BundleOptions) error {
+	log := logf.Log
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	if options.OutputPath == "" {
+		return errors.New("please specify the bundle output path")
+	}
+	if options.ContainerTool == "" {
+		options.ContainerTool = "docker"
+	}
+
+	stagingPath, err := ioutil.TempDir("", "metal3ctl-bundle")
+	if err != nil {
+		return errors.Wrap(err, "error creating the bundle staging folder")
+	}
+	defer os.RemoveAll(stagingPath)
+
+	// The bundled config points to the rendered manifests, with paths relative to the bundle root; image overrides,
+	// registry mirror and replacements are already applied to the rendered manifests, so they are dropped.
+	// The settings of the selected context are already applied too, so the contexts are dropped.
+	bundleConf := *conf
+	bundleConf.ImageOverrides = nil
+	bundleConf.RegistryMirror = ""
+	bundleConf.Contexts = nil
+	bundleConf.CurrentContext = ""
+	images, err := conf.ImagesToLoad()
+	if err != nil {
+		return errors.Wrap(err, "error applying image overrides to the images list")
+	}
+	bundleConf.Images = images
+	bundleConf.Variables, bundleConf.BMOVariables, bundleConf.SecretVariables = bundleVariables(conf)
+
+	log.Info("Rendering the baremetal-operator manifests")
+	bmoProvider := conf.BMOProvider
+	bmoProvider.ImageOverrides = nil
+	bmoProvider.Versions = nil
+	for _, version := range conf.BMOProvider.Versions {
+		manifest, err := config.ComponentGeneratorForComponentSource(conf.BMOProvider.Ironic.Source(version)).Manifests(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "error generating the manifest for %q / %q", conf.BMOProvider.Name, version.Name)
+		}
+		manifest, err = resolveManifestImages(manifest, conf, conf.BMOProvider)
+		if err != nil {
+			return errors.Wrapf(err, "error applying image overrides for %q / %q", conf.BMOProvider.Name, version.Name)
+		}
+		relPath := filepath.Join("bmo", version.Name, "components.yaml")
+		if err := writeBundleFile(stagingPath, relPath, manifest); err != nil {
+			return err
+		}
+		bmoProvider.Versions = append(bmoProvider.Versions, config.ComponentSource{
+			Name:    version.Name,
+			Type:    config.URLSource,
+			Value:   relPath,
+			Default: version.Default,
+		})
+	}
+	bmoProvider.Files = nil
+	for _, file := range conf.BMOProvider.Files {
+		data, err := ioutil.ReadFile(file.SourcePath)
+		if err != nil {
+			return errors.Wrapf(err, "error reading file %q / %q", conf.BMOProvider.Name, file.SourcePath)
+		}
+		relPath := filepath.Join("bmo", "files", file.TargetName)
+		if err := writeBundleFile(stagingPath, relPath, data); err != nil {
+			return err
+		}
+		file.SourcePath = relPath
+		bmoProvider.Files = append(bmoProvider.Files, file)
+	}
+	bundleConf.BMOProvider = bmoProvider
+
+	log.Info("Rendering the cluster-api providers repository")
+	if _, err := CreateCAPIRepository(ctx, CreateCAPIRepositoryInput{
+		config:        conf,
+		artifactsPath: stagingPath,
+	}); err != nil {
+		return errors.Wrapf(err, "error creating local cluster-api repository")
+	}
+	bundleConf.CAPIProviders = nil
+	for _, provider := range conf.CAPIProviders {
+		providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+		bundleProvider := provider
+		bundleProvider.ImageOverrides = nil
+		bundleProvider.Versions = nil
+		for _, version := range provider.Versions {
+			bundleProvider.Versions = append(bundleProvider.Versions, config.ComponentSource{
+				Name:     version.Name,
+				Type:     config.URLSource,
+				Value:    filepath.Join("repository", providerLabel, version.Name, componentsFileName),
+				Default:  version.Default,
+				Metadata: filepath.Join("repository", providerLabel, version.Name, metadataFileName),
+			})
+		}
+		bundleProvider.Files = nil
+		for _, file := range provider.Files {
+			// CreateCAPIRepository copies the files into the folder of each version, the default one included.
+			file.SourcePath = filepath.Join("repository", providerLabel, provider.DefaultVersion().Name, file.TargetName)
+			bundleProvider.Files = append(bundleProvider.Files, file)
+		}
+		bundleProvider.ClusterTemplates = nil
+		for _, template := range provider.ClusterTemplates {
+			// CreateCAPIRepository copies the cluster templates into the folder of each version, as well.
+			template.SourcePath = filepath.Join("repository", providerLabel, provider.DefaultVersion().Name, template.FileName())
+			bundleProvider.ClusterTemplates = append(bundleProvider.ClusterTemplates, template)
+		}
+		bundleConf.CAPIProviders = append(bundleConf.CAPIProviders, bundleProvider)
+	}
+
+	if options.IncludeImages {
+		if err := saveBundleImages(ctx, conf, stagingPath, options.ContainerTool); err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(bundleConf)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the bundle config")
+	}
+	if err := writeBundleFile(stagingPath, bundleConfigFileName, data); err != nil {
+		return err
+	}
+
+	log.Info("Writing the bundle", "Path", options.OutputPath)
+	return writeTarGz(stagingPath, options.OutputPath)
+}
+
+func saveBundleImages(ctx context.Context, conf *config.Metal3CtlConfig, stagingPath, tool string) error {
+	log := logf.Log
+	images, err := ListImages(ctx, conf, &InitOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing images")
+	}
+	imagesPath := filepath.Join(stagingPath, bundleImagesFolder)
+	if err := os.MkdirAll(imagesPath, 0755); err != nil {
+		return errors.Wrap(err, "error creating the bundle images folder")
+	}
+	index := []BundleImage{}
+	for _, image := range images {
+		log.Info("Saving image", "Image", image)
+		if err := runContainerTool(ctx, tool, "pull", image); err != nil {
+			return err
+		}
+		fileName := strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(image) + ".tar"
+		if err := runContainerTool(ctx, tool, "save", "-o", filepath.Join(imagesPath, fileName), image); err != nil {
+			return err
+		}
+		index = append(index, BundleImage{Name: image, File: filepath.Join(bundleImagesFolder, fileName)})
+	}
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the bundle images index")
+	}
+	return writeBundleFile(stagingPath, filepath.Join(bundleImagesFolder, bundleImagesIndexFileName), data)
+}
+
+// bundleVariables returns the variables to be written into the bundled config: the variables whose name looks like
+// the name of a secret (see config.IsSensitiveVariableName) are not written into the bundle, and they are turned into
+// secret variables read from the environment variable with the same name, so their values must be provided when the
+// bundle is installed. Secret variables are written as they are, because they hold only a reference to their values.
+func bundleVariables(conf *config.Metal3CtlConfig) (map[string]string, map[string]string, []config.SecretVariable) {
+	log := logf.Log
+	secretVariables := append([]config.SecretVariable{}, conf.SecretVariables...)
+	defined := map[string]bool{}
+	for _, variable := range secretVariables {
+		defined[variable.Name] = true
+	}
+	strip := func(variables map[string]string) map[string]string {
+		if variables == nil {
+			return nil
+		}
+		ret := map[string]string{}
+		for name, value := range variables {
+			if !config.IsSensitiveVariableName(name) {
+				ret[name] = value
+				continue
+			}
+			log.Info("The variable is not written into the bundle, please set it in the environment when installing the bundle", "Variable", name)
+			if !defined[name] {
+				secretVariables = append(secretVariables, config.SecretVariable{Name: name, ValueFrom: config.VariableSource{Env: name}})
+				defined[name] = true
+			}
+		}
+		return ret
+	}
+	variables := strip(conf.Variables)
+	bmoVariables := strip(conf.BMOVariables)
+	sort.Slice(secretVariables, func(i, j int) bool { return secretVariables[i].Name < secretVariables[j].Name })
+	return variables, bmoVariables, secretVariables
+}
+
+// ImportBundle extracts a bundle created by CreateBundle into the bundle folder under the local artifacts path given
+// in the options, and returns the path and the content of the bundled config, with the artifacts path replaced by the
+// local one and all the sources pointing to the extracted files. The artifacts path of the bundled config, a path on
+// the host which created the bundle, is never used.
+// If the bundle contains image tarballs, they are loaded with the container tool selected in the options, unless
+// SkipImageLoad is set.
+func ImportBundle(bundlePath string, options *InitOptions) (string, []byte, error) {
+	artifactsPath, err := bundleArtifactsPath(options.ArtifactsPath)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := readTarGzFile(bundlePath, bundleConfigFileName)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error reading the config from bundle %q", bundlePath)
+	}
+	conf, err := config.DecodeConfig(data)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "error loading the config from bundle %q", bundlePath)
+	}
+	conf.ArtifactsPath = artifactsPath
+	for i := range conf.Contexts {
+		conf.Contexts[i].ArtifactsPath = ""
+	}
+
+	bundleDir := util.GetBundlePath(artifactsPath)
+	if err := os.RemoveAll(bundleDir); err != nil {
+		return "", nil, errors.Wrapf(err, "error cleaning up the bundle folder %q", bundleDir)
+	}
+	if err := extractTarGz(bundlePath, bundleDir); err != nil {
+		return "", nil, errors.Wrapf(err, "error extracting bundle %q", bundlePath)
+	}
+
+	// the bundled sources are relative to the bundle folder; absolute paths would point outside of the bundle, to
+	// files of the host which created it.
+	var pathErr error
+	toAbs := func(relPath string) string {
+		if filepath.IsAbs(relPath) && pathErr == nil {
+			pathErr = errors.Errorf("invalid bundle %q: the source %q is not a path within the bundle", bundlePath, relPath)
+		}
+		return filepath.Join(bundleDir, relPath)
+	}
+	providers := []*config.ProviderConfig{&conf.BMOProvider}
+	for i := range conf.CAPIProviders {
+		providers = append(providers, &conf.CAPIProviders[i])
+	}
+	for _, provider := range providers {
+		for i := range provider.Versions {
+			version := &provider.Versions[i]
+			if version.Type == config.URLSource && !strings.Contains(version.Value, "://") {
+				version.Value = "file://" + toAbs(version.Value)
+			}
+			if version.Metadata != "" {
+				version.Metadata = toAbs(version.Metadata)
+			}
+		}
+		for i := range provider.Files {
+			provider.Files[i].SourcePath = toAbs(provider.Files[i].SourcePath)
+		}
+		for i := range provider.ClusterTemplates {
+			provider.ClusterTemplates[i].SourcePath = toAbs(provider.ClusterTemplates[i].SourcePath)
+		}
+	}
+	if pathErr != nil {
+		return "", nil, pathErr
+	}
+
+	data, err = yaml.Marshal(conf)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to convert to yaml the bundle config")
+	}
+	// Keep a copy of the resolved config next to the extracted bundle, so it can be used for later operations, e.g. delete.
+	configPath := filepath.Join(bundleDir, bundleConfigFileName)
+	if err := ioutil.WriteFile(configPath, data, 0644); err != nil {
+		return "", nil, errors.Wrap(err, "error writing the bundle config")
+	}
+
+	if !options.SkipImageLoad {
+		if err := loadBundleImages(context.TODO(), bundleDir, options.ContainerTool, runContainerTool); err != nil {
+			return "", nil, err
+		}
+	}
+	return configPath, data, nil
+}
+
+// bundleArtifactsPath returns the absolute artifacts path a bundle is imported into; the bundle folder under it is
+// removed before extracting the bundle, so the root folder is rejected.
+func bundleArtifactsPath(artifactsPath string) (string, error) {
+	if artifactsPath == "" {
+		return "", errors.New("the artifacts path for importing the bundle is empty")
+	}
+	path, err := filepath.Abs(artifactsPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "error converting %q to an absolute path", artifactsPath)
+	}
+	if path == filepath.VolumeName(path)+string(filepath.Separator) {
+		return "", errors.Errorf("the artifacts path for importing the bundle can't be the root folder %q", path)
+	}
+	return path, nil
+}
+
+// loadBundleImages loads the image tarballs listed in the images index of an extracted bundle with the container tool;
+// bundles created without images have no index, and nothing is loaded.
+func loadBundleImages(ctx context.Context, bundleDir, tool string, run containerToolRunner) error {
+	log := logf.Log
+	data, err := ioutil.ReadFile(filepath.Join(bundleDir, bundleImagesFolder, bundleImagesIndexFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error reading the bundle images index")
+	}
+	index := []BundleImage{}
+	if err := yaml.Unmarshal(data, &index); err != nil {
+		return errors.Wrap(err, "error parsing the bundle images index")
+	}
+	if tool == "" {
+		tool = "docker"
+	}
+	for _, image := range index {
+		path := filepath.Join(bundleDir, filepath.FromSlash(image.File))
+		if !strings.HasPrefix(path, filepath.Clean(bundleDir)+string(os.PathSeparator)) {
+			return errors.Errorf("invalid file path %q for image %q in the bundle images index", image.File, image.Name)
+		}
+		log.Info("Loading image", "Image", image.Name)
+		if err := run(ctx, tool, "load", "-i", path); err != nil {
+			return errors.Wrapf(err, "error loading image %q", image.Name)
+		}
+	}
+	return nil
+}
+
+func writeBundleFile(stagingPath, relPath string, data []byte) error {
+	path := filepath.Join(stagingPath, relPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrapf(err, "error creating the bundle folder for %q", relPath)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return errors.Wrapf(err, "error writing %q into the bundle", relPath)
+	}
+	return nil
+}
+
+func writeTarGz(sourcePath, archivePath string) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %q", archivePath)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil || relPath == "." {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error writing %q", archivePath)
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrapf(err, "error writing %q", archivePath)
+	}
+	if err := gw.Close(); err != nil {
+		return errors.Wrapf(err, "error writing %q", archivePath)
+	}
+	return nil
+}
+
+func readTarGzFile(archivePath, name string) ([]byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf("%q not found", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+func extractTarGz(archivePath, targetPath string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(targetPath, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(targetPath)+string(os.PathSeparator)) {
+			return errors.Errorf("invalid file path %q in archive", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
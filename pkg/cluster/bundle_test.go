@@ -0,0 +1,330 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+)
+
+func TestTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metal3ctl-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"metal3ctl.yaml":             "artifactsPath: /tmp/metal3ctl\n",
+		"bmo/v0.2.0/components.yaml": "kind: Deployment\n",
+		"repository/infrastructure-metal3/v0.3.0/metadata.yaml": "kind: Metadata\n",
+	}
+	sourcePath := filepath.Join(dir, "source")
+	for name, content := range files {
+		if err := writeBundleFile(sourcePath, name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archivePath := filepath.Join(dir, "bundle.tar.gz")
+	if err := writeTarGz(sourcePath, archivePath); err != nil {
+		t.Fatalf("writeTarGz() error = %v", err)
+	}
+
+	data, err := readTarGzFile(archivePath, "bmo/v0.2.0/components.yaml")
+	if err != nil {
+		t.Fatalf("readTarGzFile() error = %v", err)
+	}
+	if string(data) != files["bmo/v0.2.0/components.yaml"] {
+		t.Errorf("readTarGzFile() = %q, want %q", data, files["bmo/v0.2.0/components.yaml"])
+	}
+	if _, err := readTarGzFile(archivePath, "missing.yaml"); err == nil {
+		t.Errorf("readTarGzFile() of a missing file should fail")
+	}
+
+	targetPath := filepath.Join(dir, "target")
+	if err := extractTarGz(archivePath, targetPath); err != nil {
+		t.Fatalf("extractTarGz() error = %v", err)
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(targetPath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("file %q was not extracted: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("got %q for %q, want %q", data, name, content)
+		}
+	}
+}
+
+func TestExtractTarGzPathTraversal(t *testing.T) {
+	tests := []struct {
+		name      string
+		entryName string
+		wantErr   bool
+	}{
+		{name: "file in a sub folder", entryName: "bmo/components.yaml", wantErr: false},
+		{name: "parent folder", entryName: "../evil.yaml", wantErr: true},
+		{name: "parent folder in a sub folder", entryName: "bmo/../../evil.yaml", wantErr: true},
+		{name: "sibling folder with the same prefix", entryName: "../target-evil/evil.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-bundle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			archivePath := filepath.Join(dir, "bundle.tar.gz")
+			f, err := os.Create(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gw := gzip.NewWriter(f)
+			tw := tar.NewWriter(gw)
+			content := []byte("kind: ConfigMap\n")
+			if err := tw.WriteHeader(&tar.Header{Name: tt.entryName, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []interface{ Close() error }{tw, gw, f} {
+				if err := c.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			targetPath := filepath.Join(dir, "target")
+			err = extractTarGz(archivePath, targetPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTarGz() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			escaped := 0
+			_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && path != archivePath && !strings.HasPrefix(path, targetPath+string(os.PathSeparator)) {
+					escaped++
+				}
+				return nil
+			})
+			if escaped > 0 {
+				t.Errorf("extractTarGz() wrote %d files outside of the target folder", escaped)
+			}
+		})
+	}
+}
+
+func TestLoadBundleImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metal3ctl-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmds := []string{}
+	run := func(_ context.Context, tool string, args ...string) error {
+		cmds = append(cmds, strings.Join(append([]string{tool}, args...), " "))
+		return nil
+	}
+	if err := loadBundleImages(context.Background(), dir, "podman", run); err != nil {
+		t.Fatalf("loadBundleImages() without images error = %v", err)
+	}
+	if len(cmds) != 0 {
+		t.Errorf("got commands %v for a bundle without images, want none", cmds)
+	}
+
+	index := "- name: quay.io/metal3-io/baremetal-operator:v0.2.0\n" +
+		"  file: images/quay.io_metal3-io_baremetal-operator_v0.2.0.tar\n"
+	if err := writeBundleFile(dir, filepath.Join(bundleImagesFolder, bundleImagesIndexFileName), []byte(index)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadBundleImages(context.Background(), dir, "podman", run); err != nil {
+		t.Fatalf("loadBundleImages() error = %v", err)
+	}
+	want := []string{"podman load -i " + filepath.Join(dir, "images", "quay.io_metal3-io_baremetal-operator_v0.2.0.tar")}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("got commands %v, want %v", cmds, want)
+	}
+
+	index = "- name: evil\n" +
+		"  file: ../evil.tar\n"
+	if err := writeBundleFile(dir, filepath.Join(bundleImagesFolder, bundleImagesIndexFileName), []byte(index)); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadBundleImages(context.Background(), dir, "podman", run); err == nil {
+		t.Errorf("loadBundleImages() should reject files outside of the bundle")
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	bundledConfig := func(sourcePath string) string {
+		return "apiVersion: metal3ctl.metal3.io/v1alpha1\n" +
+			"kind: Metal3CtlConfig\n" +
+			"artifactsPath: /\n" +
+			"bmoProvider:\n" +
+			"  name: baremetal-operator\n" +
+			"  type: BareMetalOperator\n" +
+			"  versions:\n" +
+			"  - name: v0.2.0\n" +
+			"    type: url\n" +
+			"    value: bmo/v0.2.0/components.yaml\n" +
+			"  files:\n" +
+			"  - sourcePath: " + sourcePath + "\n"
+	}
+	tests := []struct {
+		name          string
+		config        string
+		artifactsPath string
+		wantErr       bool
+	}{
+		{
+			name:          "bundle extracted into the local artifacts path",
+			config:        bundledConfig("repository/bmo/ironic.env"),
+			artifactsPath: "artifacts",
+		},
+		{
+			name:          "empty artifacts path",
+			config:        bundledConfig("repository/bmo/ironic.env"),
+			artifactsPath: "",
+			wantErr:       true,
+		},
+		{
+			name:          "root artifacts path",
+			config:        bundledConfig("repository/bmo/ironic.env"),
+			artifactsPath: string(filepath.Separator),
+			wantErr:       true,
+		},
+		{
+			name:          "absolute source path in the bundle",
+			config:        bundledConfig("/etc/passwd"),
+			artifactsPath: "artifacts",
+			wantErr:       true,
+		},
+		{
+			name:          "bundled config not matching the schema",
+			config:        "apiVersion: metal3ctl.metal3.io/v1alpha1\nkind: Metal3CtlConfig\nbmoProvider: baremetal-operator\n",
+			artifactsPath: "artifacts",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-bundle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			sourcePath := filepath.Join(dir, "source")
+			files := map[string]string{
+				bundleConfigFileName:         tt.config,
+				"bmo/v0.2.0/components.yaml": "kind: Deployment\n",
+				"repository/bmo/ironic.env":  "HTTP_PORT=6180\n",
+			}
+			for name, content := range files {
+				if err := writeBundleFile(sourcePath, name, []byte(content)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			archivePath := filepath.Join(dir, "bundle.tar.gz")
+			if err := writeTarGz(sourcePath, archivePath); err != nil {
+				t.Fatal(err)
+			}
+
+			artifactsPath := tt.artifactsPath
+			if artifactsPath == "artifacts" {
+				artifactsPath = filepath.Join(dir, artifactsPath)
+			}
+			configPath, data, err := ImportBundle(archivePath, &InitOptions{ArtifactsPath: artifactsPath, SkipImageLoad: true})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			bundleDir := filepath.Join(artifactsPath, "bundle")
+			if want := filepath.Join(bundleDir, bundleConfigFileName); configPath != want {
+				t.Errorf("got config path %q, want %q", configPath, want)
+			}
+			saved, err := ioutil.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(saved) != string(data) {
+				t.Errorf("the saved config %q doesn't match the returned one %q", saved, data)
+			}
+			conf, err := config.DecodeConfig(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.ArtifactsPath != artifactsPath {
+				t.Errorf("got artifactsPath %q, want the local artifacts path %q", conf.ArtifactsPath, artifactsPath)
+			}
+			if want := "file://" + filepath.Join(bundleDir, "bmo/v0.2.0/components.yaml"); conf.BMOProvider.Versions[0].Value != want {
+				t.Errorf("got version %q, want %q", conf.BMOProvider.Versions[0].Value, want)
+			}
+			if want := filepath.Join(bundleDir, "repository/bmo/ironic.env"); conf.BMOProvider.Files[0].SourcePath != want {
+				t.Errorf("got file %q, want %q", conf.BMOProvider.Files[0].SourcePath, want)
+			}
+		})
+	}
+}
+
+func TestBundleVariables(t *testing.T) {
+	conf := &config.Metal3CtlConfig{
+		Variables: map[string]string{
+			"CLUSTER_NAME":    "test",
+			"IRONIC_PASSWORD": "changeme",
+		},
+		BMOVariables: map[string]string{
+			"PROVISIONING_INTERFACE": "eth1",
+			"DEPLOY_TOKEN":           "abc",
+		},
+		SecretVariables: []config.SecretVariable{
+			{Name: "GITHUB_TOKEN", ValueFrom: config.VariableSource{Env: "GH_TOKEN"}},
+		},
+	}
+	variables, bmoVariables, secretVariables := bundleVariables(conf)
+	if want := map[string]string{"CLUSTER_NAME": "test"}; !reflect.DeepEqual(variables, want) {
+		t.Errorf("got variables %v, want %v", variables, want)
+	}
+	if want := map[string]string{"PROVISIONING_INTERFACE": "eth1"}; !reflect.DeepEqual(bmoVariables, want) {
+		t.Errorf("got bmoVariables %v, want %v", bmoVariables, want)
+	}
+	wantSecretVariables := []config.SecretVariable{
+		{Name: "DEPLOY_TOKEN", ValueFrom: config.VariableSource{Env: "DEPLOY_TOKEN"}},
+		{Name: "GITHUB_TOKEN", ValueFrom: config.VariableSource{Env: "GH_TOKEN"}},
+		{Name: "IRONIC_PASSWORD", ValueFrom: config.VariableSource{Env: "IRONIC_PASSWORD"}},
+	}
+	if !reflect.DeepEqual(secretVariables, wantSecretVariables) {
+		t.Errorf("got secretVariables %v, want %v", secretVariables, wantSecretVariables)
+	}
+	if _, ok := conf.Variables["IRONIC_PASSWORD"]; !ok {
+		t.Errorf("the input config was changed")
+	}
+}
@@ -27,6 +27,9 @@ import (
 type ClusterctlConfig struct {
 	Path   string
 	Values map[string]interface{}
+
+	// secrets are the values of the secret variables, passed to clusterctl by NewClient and never written to Path.
+	secrets map[string]string
 }
 
 // ClusterctlConfigProvider mirrors clusterctl config.Provider interface and allows serialization of the corresponding info
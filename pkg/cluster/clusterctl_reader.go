@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	"sigs.k8s.io/yaml"
+)
+
+// clusterctlConfigReader is the clusterctl config reader for the clusterctl config created by CreateCAPIRepository;
+// it reads the values of the secret variables from memory, so they are never written to the clusterctl config file
+// nor to the environment of metal3ctl. As with the clusterctl default reader, environment variables take precedence
+// over the values of the clusterctl config file.
+type clusterctlConfigReader struct {
+	values  map[string]interface{}
+	secrets map[string]string
+}
+
+var _ clusterctlconfig.Reader = &clusterctlConfigReader{}
+
+func newClusterctlConfigReader(values map[string]interface{}, secrets map[string]string) *clusterctlConfigReader {
+	r := &clusterctlConfigReader{values: map[string]interface{}{}, secrets: secrets}
+	for key, value := range values {
+		r.values[key] = value
+	}
+	return r
+}
+
+// Init does nothing, as the values are already in memory.
+func (r *clusterctlConfigReader) Init(path string) error {
+	return nil
+}
+
+func (r *clusterctlConfigReader) Get(key string) (string, error) {
+	if value, ok := r.secrets[key]; ok {
+		return value, nil
+	}
+	if value, ok := os.LookupEnv(key); ok {
+		return value, nil
+	}
+	if value, ok := r.values[key]; ok {
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return "", errors.Errorf("the value of variable %q is not a string", key)
+	}
+	return "", errors.Errorf("failed to get value for variable %q. Please set the variable value using os env variables or using the metal3ctl config file", key)
+}
+
+func (r *clusterctlConfigReader) Set(key, value string) {
+	r.values[key] = value
+}
+
+func (r *clusterctlConfigReader) UnmarshalKey(key string, rawval interface{}) error {
+	value, ok := r.values[key]
+	if !ok {
+		return nil
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert to yaml the value of %q", key)
+	}
+	return yaml.Unmarshal(data, rawval)
+}
+
+// NewClient returns a clusterctl client using the clusterctl config; the values of the secret variables are passed
+// to clusterctl through the config reader.
+func (c *ClusterctlConfig) NewClient() (clusterctlclient.Client, error) {
+	configClient, err := clusterctlconfig.New(c.Path, clusterctlconfig.InjectReader(newClusterctlConfigReader(c.Values, c.secrets)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the clusterctl config client")
+	}
+	return clusterctlclient.New(c.Path, clusterctlclient.InjectConfig(configClient))
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestClusterctlConfigReader(t *testing.T) {
+	os.Setenv("METAL3CTL_TEST_FROM_ENV", "env")
+	defer os.Unsetenv("METAL3CTL_TEST_FROM_ENV")
+	os.Setenv("METAL3CTL_TEST_PASSWORD", "env")
+	defer os.Unsetenv("METAL3CTL_TEST_PASSWORD")
+
+	providers := []ClusterctlConfigProvider{{Name: "metal3", URL: "/tmp/repository/infrastructure-metal3/v0.3.0/infrastructure-components.yaml", Type: "InfrastructureProvider"}}
+	r := newClusterctlConfigReader(map[string]interface{}{
+		"providers":               providers,
+		"CLUSTER_NAME":            "test",
+		"METAL3CTL_TEST_FROM_ENV": "file",
+	}, map[string]string{"METAL3CTL_TEST_PASSWORD": "secret"})
+
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "value of the config file", key: "CLUSTER_NAME", want: "test"},
+		{name: "environment variables take precedence", key: "METAL3CTL_TEST_FROM_ENV", want: "env"},
+		{name: "secret variables take precedence", key: "METAL3CTL_TEST_PASSWORD", want: "secret"},
+		{name: "missing variable", key: "METAL3CTL_TEST_MISSING", wantErr: true},
+		{name: "not a string", key: "providers", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Get(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	got := []ClusterctlConfigProvider{}
+	if err := r.UnmarshalKey("providers", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, providers) {
+		t.Errorf("UnmarshalKey() = %+v, want %+v", got, providers)
+	}
+}
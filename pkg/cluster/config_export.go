@@ -0,0 +1,452 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/clientcmd"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// bmoProviderType is the provider type of the baremetal-operator.
+	bmoProviderType = "BareMetalOperator"
+
+	// bmoRepository is the kustomize remote target of the baremetal-operator deploy folder, used for the exported
+	// baremetal-operator versions.
+	bmoRepository = "github.com/metal3-io/baremetal-operator/deploy"
+
+	// keepalivedContainer is the name of the container managing the provisioning IP in the keepalived Ironic mode.
+	keepalivedContainer = "ironic-endpoint-keepalived"
+)
+
+// providerTypeOrder is the order of the exported CAPI providers.
+var providerTypeOrder = map[string]int{
+	string(clusterctlv1.CoreProviderType):           0,
+	string(clusterctlv1.BootstrapProviderType):      1,
+	string(clusterctlv1.ControlPlaneProviderType):   2,
+	string(clusterctlv1.InfrastructureProviderType): 3,
+}
+
+// ExportConfigOptions are the options for ExportConfig.
+type ExportConfigOptions struct {
+	// Kubeconfig is the path to the kubeconfig of the mgmt cluster; default is the kubeconfig in the standard locations.
+	Kubeconfig string
+
+	// Name is the name of the mgmt cluster; default is the current context of the kubeconfig.
+	Name string
+
+	// ArtifactsPath is the path where all the generated artifacts will be stored; default is /tmp/_artifacts/<name>/.
+	ArtifactsPath string
+}
+
+// ExportConfig generates a metal3ctl config for a mgmt cluster not initialized with metal3ctl, e.g. set up by hand or
+// with plain clusterctl. CAPI providers are read from the clusterctl provider inventory, using the released manifests
+// of the installed versions as sources, and BMO from its deployment; the images running in the mgmt cluster are
+// pinned with image overrides, so that metal3ctl installs exactly what is running. The generated config is validated
+// before being returned.
+func ExportConfig(ctx context.Context, options *ExportConfigOptions) ([]byte, error) {
+	log := logf.Log
+	kubeconfig := options.Kubeconfig
+	if kubeconfig == "" {
+		kubeconfig = clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
+	}
+	name := options.Name
+	if name == "" {
+		kubeconfigData, err := clientcmd.LoadFromFile(kubeconfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load Kubeconfig file from %q", kubeconfig)
+		}
+		name = kubeconfigData.CurrentContext
+	}
+	artifactsPath := options.ArtifactsPath
+	if artifactsPath == "" {
+		artifactsPath = fmt.Sprintf("/tmp/_artifacts/%s/", name)
+	}
+
+	c, err := proxy.NewProxy(kubeconfig).NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create controller-runtime client")
+	}
+	inventory := &clusterctlv1.ProviderList{}
+	if err := c.List(ctx, inventory); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, errors.New("the clusterctl provider inventory is not installed in the mgmt cluster, please install the cluster-api providers with clusterctl init first")
+		}
+		return nil, errors.Wrap(err, "failed to list the clusterctl inventory")
+	}
+	clusterctlConfig, err := clusterctlconfig.New("")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the clusterctl config client")
+	}
+
+	conf := &config.Metal3CtlConfig{
+		ManagementClusterName: name,
+		Kubeconfig:            homeRelative(kubeconfig),
+		ArtifactsPath:         artifactsPath,
+	}
+	conf.APIVersion = config.GroupVersion
+	conf.Kind = config.Kind
+
+	for i := range inventory.Items {
+		provider := &inventory.Items[i]
+		if isBMOInventory(*provider) {
+			continue
+		}
+		providerConfig, err := exportCAPIProvider(ctx, c, clusterctlConfig, provider)
+		if err != nil {
+			return nil, err
+		}
+		if providerConfig == nil {
+			log.Info("Skipping provider without released manifests, please add it to the config by hand", "Provider", provider.ProviderName, "Type", provider.Type, "Version", provider.Version)
+			continue
+		}
+		log.Info("Exporting", "Provider", provider.ProviderName, "Type", provider.Type, "Version", provider.Version)
+		conf.CAPIProviders = append(conf.CAPIProviders, *providerConfig)
+	}
+	sort.SliceStable(conf.CAPIProviders, func(i, j int) bool {
+		return providerTypeOrder[conf.CAPIProviders[i].Type] < providerTypeOrder[conf.CAPIProviders[j].Type]
+	})
+
+	bmoInventories, err := listBMOInventory(ctx, c, nil)
+	if err != nil {
+		return nil, err
+	}
+	bmoProvider, err := exportBMOProvider(ctx, c, bmoInventories)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Exporting", "Provider", bmoProvider.Name, "Type", bmoProvider.Type, "Version", bmoProvider.Versions[0].Name, "Instances", len(bmoProvider.BMOInstances()))
+	conf.BMOProvider = *bmoProvider
+
+	data, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert to yaml the config")
+	}
+	if _, err := config.LoadMetal3CtlConfig(ctx, config.LoadMetal3CtlConfigInput{ConfigData: data}); err != nil {
+		return nil, errors.Wrap(err, "the exported config is not valid")
+	}
+	return data, nil
+}
+
+// exportCAPIProvider returns the config of a CAPI provider recorded in the clusterctl provider inventory; the released
+// manifest of the installed version is read from the provider repository known to clusterctl. If clusterctl does not
+// know the provider, nil is returned.
+func exportCAPIProvider(ctx context.Context, c client.Client, clusterctlConfig clusterctlconfig.Client, inventory *clusterctlv1.Provider) (*config.ProviderConfig, error) {
+	// Providers().Get fails both for unknown providers and for invalid clusterctl configs, so the providers are
+	// listed to tell the two apart.
+	repositories, err := clusterctlConfig.Providers().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the providers known to clusterctl")
+	}
+	var repository clusterctlconfig.Provider
+	for _, r := range repositories {
+		if r.Name() == inventory.ProviderName && r.Type() == clusterctlv1.ProviderType(inventory.Type) {
+			repository = r
+		}
+	}
+	if repository == nil {
+		return nil, nil
+	}
+
+	deployments, err := listDeployments(ctx, c, client.MatchingLabels{clusterv1.ProviderLabelName: clusterctlv1.ManifestLabel(inventory.ProviderName, clusterctlv1.ProviderType(inventory.Type))})
+	if err != nil {
+		return nil, err
+	}
+	imageOverrides, err := runningImageOverrides(ctx, c, deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &config.ProviderConfig{
+		Name: inventory.ProviderName,
+		Type: inventory.Type,
+		Versions: []config.ComponentSource{{
+			Name:  inventory.Version,
+			Value: releasedComponentsURL(repository.URL(), inventory.Version),
+			Type:  config.URLSource,
+		}},
+		ImageOverrides: imageOverrides,
+	}
+	for _, deployment := range deployments {
+		waiter := config.ProviderWaiter{Type: config.DeploymentWaiter, Name: deployment.GetName()}
+		if deployment.GetNamespace() == inventory.Namespace {
+			waiter.DefaultNamespace = deployment.GetNamespace()
+		} else {
+			waiter.Namespace = deployment.GetNamespace()
+		}
+		provider.Waiters = append(provider.Waiters, waiter)
+	}
+	return provider, nil
+}
+
+// releasedComponentsURL pins the URL of the latest release of a provider, as defined in the clusterctl provider
+// list, to the given version; URLs not pointing to a GitHub release are returned unchanged.
+func releasedComponentsURL(url, version string) string {
+	return strings.Replace(url, "/releases/latest/", fmt.Sprintf("/releases/download/%s/", version), 1)
+}
+
+// exportBMOProvider returns the config of the BMO installed in the mgmt cluster; BMO is found by the inventory
+// records, if any, or by the image of its deployment. The kustomize overlay matching the Ironic topology of the
+// deployment is used as a source; when several instances are recorded, all of them are exported, using the version
+// and the images of the first one.
+func exportBMOProvider(ctx context.Context, c client.Client, inventories []BMOInventory) (*config.ProviderConfig, error) {
+	var inventory *BMOInventory
+	if len(inventories) > 0 {
+		inventory = &inventories[0]
+	}
+	opts := []client.ListOption{}
+	if inventory != nil {
+		opts = append(opts, client.InNamespace(inventory.Namespace))
+	}
+	deployments, err := listDeployments(ctx, c, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var bmoDeployment *unstructured.Unstructured
+	var bmoImage config.ImageReference
+	containers := map[string]bool{}
+	ironicEnv := map[string]string{}
+	envConfigMaps := []string{}
+	for i := range deployments {
+		deployment := &deployments[i]
+		found := false
+		names := map[string]bool{}
+		_ = util.VisitContainers([]unstructured.Unstructured{*deployment}, func(_ *unstructured.Unstructured, _, container map[string]interface{}) error {
+			name, _ := container["name"].(string)
+			names[name] = true
+			image, _ := container["image"].(string)
+			ref, err := config.ParseImageReference(image)
+			if err != nil || path.Base(ref.Repository) != "baremetal-operator" {
+				return nil
+			}
+			found, bmoImage = true, ref
+			env, _ := container["env"].([]interface{})
+			for _, e := range env {
+				if v, ok := e.(map[string]interface{}); ok {
+					if key, _ := v["name"].(string); key == "IRONIC_ENDPOINT" || key == "IRONIC_INSPECTOR_ENDPOINT" {
+						ironicEnv[key], _ = v["value"].(string)
+					}
+				}
+			}
+			envFrom, _ := container["envFrom"].([]interface{})
+			for _, e := range envFrom {
+				if v, ok := e.(map[string]interface{}); ok {
+					if name, _, _ := unstructured.NestedString(v, "configMapRef", "name"); name != "" {
+						envConfigMaps = append(envConfigMaps, name)
+					}
+				}
+			}
+			return nil
+		})
+		if found {
+			bmoDeployment, containers = deployment, names
+			break
+		}
+	}
+	if bmoDeployment == nil {
+		return nil, errors.New("failed to find the baremetal-operator deployment in the mgmt cluster")
+	}
+
+	version := bmoDeployment.GetLabels()[bmoVersionLabel]
+	switch {
+	case inventory != nil:
+		version = inventory.Version
+	case version == "" && bmoImage.Tag != "" && bmoImage.Tag != "latest":
+		version = bmoImage.Tag
+	case version == "":
+		version = "master"
+	}
+
+	ironic := &config.IronicConfig{Mode: config.IronicExternalMode}
+	for name := range containers {
+		if ironicContainers[name] {
+			ironic.Mode = config.IronicBundledMode
+		}
+	}
+	switch {
+	case ironic.Mode == config.IronicBundledMode && containers[keepalivedContainer]:
+		ironic.Mode = config.IronicKeepalivedMode
+	case ironic.Mode == config.IronicExternalMode:
+		// Values defined in env take precedence over the ones in envFrom, as in Kubernetes.
+		for _, name := range envConfigMaps {
+			configMap := &unstructured.Unstructured{}
+			configMap.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"})
+			if err := c.Get(ctx, client.ObjectKey{Namespace: bmoDeployment.GetNamespace(), Name: name}, configMap); err != nil {
+				return nil, errors.Wrapf(err, "failed to get the ConfigMap %s/%s used by the baremetal-operator", bmoDeployment.GetNamespace(), name)
+			}
+			data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+			for _, key := range []string{"IRONIC_ENDPOINT", "IRONIC_INSPECTOR_ENDPOINT"} {
+				if _, ok := ironicEnv[key]; !ok && data[key] != "" {
+					ironicEnv[key] = data[key]
+				}
+			}
+		}
+		ironic.Endpoint = ironicEnv["IRONIC_ENDPOINT"]
+		ironic.InspectorEndpoint = ironicEnv["IRONIC_INSPECTOR_ENDPOINT"]
+	}
+	ironic.Defaults()
+
+	imageOverrides, err := runningImageOverrides(ctx, c, []unstructured.Unstructured{*bmoDeployment})
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &config.ProviderConfig{
+		Name: "baremetal-operator",
+		Type: bmoProviderType,
+		Versions: []config.ComponentSource{{
+			Name:  version,
+			Value: fmt.Sprintf("%s/%s?ref=%s", bmoRepository, ironic.Overlay, version),
+			Type:  config.KustomizeSource,
+		}},
+		// The waiter uses the default namespace, so it applies to the target namespace of each instance.
+		Waiters: []config.ProviderWaiter{{
+			Type:             config.DeploymentWaiter,
+			DefaultNamespace: bmoDeployment.GetNamespace(),
+			Name:             bmoDeployment.GetName(),
+		}},
+		ImageOverrides: imageOverrides,
+		Ironic:         ironic,
+	}
+	switch {
+	case len(inventories) > 1:
+		provider.Name = inventory.Name
+		for _, i := range inventories {
+			if i.Version != version {
+				logf.Log.Info("The baremetal-operator instances run different versions, the exported config installs the same version in all of them", "Namespace", i.Namespace, "Version", i.Version, "ExportedVersion", version)
+			}
+			provider.Instances = append(provider.Instances, config.BMOInstance{TargetNamespace: i.Namespace, WatchingNamespace: i.WatchingNamespace})
+		}
+	case inventory != nil:
+		provider.Name = inventory.Name
+		provider.TargetNamespace = exportedTargetNamespace(inventory.Namespace)
+		provider.WatchingNamespace = inventory.WatchingNamespace
+	default:
+		provider.TargetNamespace = exportedTargetNamespace(bmoDeployment.GetNamespace())
+		provider.WatchingNamespace = bmoWatchingNamespace([]unstructured.Unstructured{*bmoDeployment})
+	}
+	return provider, nil
+}
+
+// exportedTargetNamespace returns the targetNamespace of a BMO installed in the given namespace; the namespace of
+// the BMO manifests is the default one.
+func exportedTargetNamespace(namespace string) string {
+	if namespace == defaultBMONamespace {
+		return ""
+	}
+	return namespace
+}
+
+func listDeployments(ctx context.Context, c client.Client, opts ...client.ListOption) ([]unstructured.Unstructured, error) {
+	deployments := &unstructured.UnstructuredList{}
+	deployments.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DeploymentList"})
+	if err := c.List(ctx, deployments, opts...); err != nil {
+		return nil, errors.Wrap(err, "failed to list the deployments")
+	}
+	sort.Slice(deployments.Items, func(i, j int) bool {
+		a, b := deployments.Items[i], deployments.Items[j]
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return deployments.Items, nil
+}
+
+// runningImageOverrides returns the image overrides pinning the images of the deployments to the images running in
+// the mgmt cluster: images are pinned to the digest reported by the running pods or, if missing, e.g. for images
+// built locally, to the tag in the deployment. The images of the pods are normalized by the container runtime, e.g.
+// busybox is reported as docker.io/library/busybox:latest, so images are matched by normalized reference.
+func runningImageOverrides(ctx context.Context, c client.Client, deployments []unstructured.Unstructured) ([]config.ImageOverride, error) {
+	digests := map[string]string{}
+	for _, deployment := range deployments {
+		selector, _, err := unstructured.NestedStringMap(deployment.Object, "spec", "selector", "matchLabels")
+		if err != nil || len(selector) == 0 {
+			continue
+		}
+		pods := &unstructured.UnstructuredList{}
+		pods.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "PodList"})
+		if err := c.List(ctx, pods, client.InNamespace(deployment.GetNamespace()), client.MatchingLabels(selector)); err != nil {
+			return nil, errors.Wrapf(err, "failed to list the pods of deployment %s/%s", deployment.GetNamespace(), deployment.GetName())
+		}
+		for _, pod := range pods.Items {
+			for _, field := range []string{"initContainerStatuses", "containerStatuses"} {
+				statuses, _, _ := unstructured.NestedSlice(pod.Object, "status", field)
+				for _, s := range statuses {
+					status, ok := s.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					image, _ := status["image"].(string)
+					imageID, _ := status["imageID"].(string)
+					if i := strings.LastIndex(imageID, "@"); i >= 0 && image != "" {
+						digests[normalizedImage(image)] = imageID[i+1:]
+					}
+				}
+			}
+		}
+	}
+
+	images, err := util.InspectImages(deployments)
+	if err != nil {
+		return nil, err
+	}
+	overrides := []config.ImageOverride{}
+	for _, image := range images {
+		ref, err := config.ParseImageReference(image)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse image %q", image)
+		}
+		override := config.ImageOverride{Image: ref.Name(), Tag: ref.Tag, Digest: ref.Digest}
+		if digest, ok := digests[normalizedImage(image)]; ok && ref.Digest == "" {
+			override.Tag, override.Digest = "", digest
+		}
+		if override.Tag == "" && override.Digest == "" {
+			continue
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
+
+// normalizedImage returns the normalized reference of an image, or the image itself if it is not a valid reference.
+func normalizedImage(image string) string {
+	ref, err := config.ParseImageReference(image)
+	if err != nil {
+		return image
+	}
+	return ref.Normalized().String()
+}
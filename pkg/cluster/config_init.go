@@ -0,0 +1,327 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/config/exec"
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
+)
+
+// ScaffoldConfigOptions are the options for ScaffoldConfig.
+type ScaffoldConfigOptions struct {
+	// Roots are the folders searched for the local checkouts, either GOPATH like (e.g. ~/go/src) or plain folders
+	// containing the checkouts; default is the src folder of each GOPATH entry.
+	Roots []string
+
+	// Name is the name of the mgmt cluster.
+	Name string
+
+	// Kubeconfig is the path to the kubeconfig of the mgmt cluster.
+	Kubeconfig string
+
+	// ArtifactsPath is the path where all the generated artifacts will be stored; default is /tmp/_artifacts/<name>/.
+	ArtifactsPath string
+}
+
+// devSourceTreeVersion is the version of checkouts with neither git tags nor clusterctl metadata.
+const devSourceTreeVersion = "v0.0.0-dev"
+
+// sourceTree is a local checkout of a provider repository.
+type sourceTree struct {
+	Path    string
+	Version string
+}
+
+// sourceTreeLayout describes where a repository is found under a root, and the folders identifying its checkouts.
+type sourceTreeLayout struct {
+	name    string
+	paths   []string
+	markers []string
+}
+
+var sourceTreeLayouts = []sourceTreeLayout{
+	{
+		name:    "cluster-api",
+		paths:   []string{"sigs.k8s.io/cluster-api", "cluster-api"},
+		markers: []string{"config", "bootstrap/kubeadm/config", "controlplane/kubeadm/config"},
+	},
+	{
+		name:    "cluster-api-provider-metal3",
+		paths:   []string{"github.com/metal3-io/cluster-api-provider-metal3", "cluster-api-provider-metal3"},
+		markers: []string{"config"},
+	},
+	{
+		name:    "baremetal-operator",
+		paths:   []string{"github.com/metal3-io/baremetal-operator", "baremetal-operator"},
+		markers: []string{"deploy"},
+	},
+}
+
+//go:generate go run scaffold_generate.go
+
+// scaffoldSourcePrefix is the folder of the local checkouts in the example config; the paths starting with it are
+// replaced with the paths of the checkouts found by ScaffoldConfig.
+const scaffoldSourcePrefix = "${HOME}/go/src/"
+
+// scaffoldHeader is prepended to the config generated by ScaffoldConfig.
+const scaffoldHeader = `# Generated by metal3ctl config init from examples/metal3ctl.dev.conf and the local checkouts of cluster-api,
+# cluster-api-provider-metal3 and baremetal-operator; manifests are built from the source trees, so local changes
+# are installed too.
+`
+
+// ScaffoldConfig generates a metal3ctl config for a dev setup, using the local checkouts of cluster-api,
+// cluster-api-provider-metal3 and baremetal-operator; provider versions are read from the git tags of the checkouts,
+// or from their clusterctl metadata.yaml. The generated config is validated before being returned.
+func ScaffoldConfig(ctx context.Context, options *ScaffoldConfigOptions) ([]byte, error) {
+	log := logf.Log
+	roots := options.Roots
+	if len(roots) == 0 {
+		for _, gopath := range filepath.SplitList(build.Default.GOPATH) {
+			roots = append(roots, filepath.Join(gopath, "src"))
+		}
+	}
+
+	trees := map[string]sourceTree{}
+	missing := []string{}
+	for _, layout := range sourceTreeLayouts {
+		path := findSourceTree(roots, layout)
+		if path == "" {
+			missing = append(missing, layout.name)
+			continue
+		}
+		version := sourceTreeVersion(ctx, path)
+		log.Info("Found local checkout", "Repository", layout.name, "Path", path, "Version", version)
+		trees[layout.name] = sourceTree{Path: homeRelative(path), Version: version}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("local checkouts of [%s] not found in [%s], please use --root to set the folders containing them", strings.Join(missing, ", "), strings.Join(roots, ", "))
+	}
+
+	artifactsPath := options.ArtifactsPath
+	if artifactsPath == "" {
+		artifactsPath = fmt.Sprintf("/tmp/_artifacts/%s/", options.Name)
+	}
+	data, err := scaffold([]byte(scaffoldConfig), config.ManagementClusterContext{
+		Name:          options.Name,
+		Kubeconfig:    homeRelative(options.Kubeconfig),
+		ArtifactsPath: artifactsPath,
+	}, trees)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate the config")
+	}
+
+	if _, err := config.LoadMetal3CtlConfig(ctx, config.LoadMetal3CtlConfigInput{ConfigData: data}); err != nil {
+		return nil, errors.Wrap(err, "the generated config is not valid")
+	}
+	return data, nil
+}
+
+// scaffold generates a config from the example config: the contexts are replaced with the given one, and the paths
+// of the local checkouts in the provider versions with the ones found, together with their versions. The values are
+// set in the YAML node tree, so they are quoted if needed, and the comments of the example are preserved.
+func scaffold(example []byte, mgmtContext config.ManagementClusterContext, trees map[string]sourceTree) ([]byte, error) {
+	root := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(example, root); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the example config")
+	}
+	if root.Kind != yamlv3.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yamlv3.MappingNode {
+		return nil, errors.New("the example config is not a YAML mapping")
+	}
+	mapping := root.Content[0]
+
+	setMappingValue(mapping, "currentContext", scalarNode(mgmtContext.Name))
+	setMappingValue(mapping, "contexts", &yamlv3.Node{Kind: yamlv3.SequenceNode, Content: []*yamlv3.Node{
+		{Kind: yamlv3.MappingNode, Content: []*yamlv3.Node{
+			scalarNode("name"), scalarNode(mgmtContext.Name),
+			scalarNode("kubeconfig"), scalarNode(mgmtContext.Kubeconfig),
+			scalarNode("artifactsPath"), scalarNode(mgmtContext.ArtifactsPath),
+		}},
+	}})
+
+	providers := []*yamlv3.Node{mappingValue(mapping, "bmoProvider")}
+	if capiProviders := mappingValue(mapping, "capiProviders"); capiProviders != nil {
+		providers = append(providers, capiProviders.Content...)
+	}
+	used := map[string]bool{}
+	for _, provider := range providers {
+		versions := mappingValue(provider, "versions")
+		if versions == nil {
+			continue
+		}
+		for _, version := range versions.Content {
+			value := mappingValue(version, "value")
+			if value == nil {
+				continue
+			}
+			for _, layout := range sourceTreeLayouts {
+				prefix := scaffoldSourcePrefix + layout.paths[0]
+				if value.Value != prefix && !strings.HasPrefix(value.Value, prefix+"/") {
+					continue
+				}
+				tree := trees[layout.name]
+				value.Value = tree.Path + strings.TrimPrefix(value.Value, prefix)
+				value.Style = 0
+				setMappingValue(version, "name", scalarNode(tree.Version))
+				used[layout.name] = true
+			}
+		}
+	}
+	for _, layout := range sourceTreeLayouts {
+		if !used[layout.name] {
+			return nil, errors.Errorf("the example config does not use the %s checkout in %s", layout.name, scaffoldSourcePrefix+layout.paths[0])
+		}
+	}
+
+	hoistItemComments(root)
+
+	var b bytes.Buffer
+	b.WriteString(scaffoldHeader)
+	encoder := yamlv3.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, errors.Wrap(err, "failed to convert to yaml the config")
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to convert to yaml the config")
+	}
+	return b.Bytes(), nil
+}
+
+// hoistItemComments moves the comments before the first field of list items to the items, so they are written
+// before the items rather than after the dashes.
+func hoistItemComments(node *yamlv3.Node) {
+	for _, n := range node.Content {
+		if node.Kind == yamlv3.SequenceNode && n.Kind == yamlv3.MappingNode && len(n.Content) > 0 && n.HeadComment == "" {
+			n.HeadComment, n.Content[0].HeadComment = n.Content[0].HeadComment, ""
+		}
+		hoistItemComments(n)
+	}
+}
+
+func scalarNode(value string) *yamlv3.Node {
+	return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func mappingValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	if mapping == nil || mapping.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets a field of a YAML mapping, preserving the comments of the replaced value; missing fields are
+// added at the end of the mapping.
+func setMappingValue(mapping *yamlv3.Node, key string, value *yamlv3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value.HeadComment = mapping.Content[i+1].HeadComment
+			value.LineComment = mapping.Content[i+1].LineComment
+			value.FootComment = mapping.Content[i+1].FootComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+// findSourceTree returns the path of the first checkout of the repository found under the given roots.
+func findSourceTree(roots []string, layout sourceTreeLayout) string {
+	for _, root := range roots {
+		for _, p := range layout.paths {
+			path := filepath.Join(root, p)
+			found := true
+			for _, marker := range layout.markers {
+				if info, err := os.Stat(filepath.Join(path, marker)); err != nil || !info.IsDir() {
+					found = false
+					break
+				}
+			}
+			if found {
+				if abs, err := filepath.Abs(path); err == nil {
+					return abs
+				}
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// sourceTreeVersion returns the version of a checkout, that is the latest git tag reachable from the checked out
+// commit or, if missing, the latest release series in the clusterctl metadata.yaml; v0.0.0-dev is returned if both
+// are missing, as clusterctl requires semantic versions.
+func sourceTreeVersion(ctx context.Context, path string) string {
+	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+		git := exec.NewCommand(
+			exec.WithCommand("git"),
+			exec.WithArgs("-C", path, "describe", "--tags", "--abbrev=0"))
+		if stdout, _, err := git.Run(ctx); err == nil {
+			if version := strings.TrimSpace(string(stdout)); version != "" {
+				return version
+			}
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "metadata.yaml"))
+	if err != nil {
+		return devSourceTreeVersion
+	}
+	metadata := &clusterctlv1.Metadata{}
+	if err := yaml.Unmarshal(data, metadata); err != nil || len(metadata.ReleaseSeries) == 0 {
+		return devSourceTreeVersion
+	}
+	series := metadata.ReleaseSeries
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Major != series[j].Major {
+			return series[i].Major > series[j].Major
+		}
+		return series[i].Minor > series[j].Minor
+	})
+	return fmt.Sprintf("v%d.%d.0", series[0].Major, series[0].Minor)
+}
+
+// homeRelative replaces the user home folder with ${HOME}, so the generated config can be shared.
+func homeRelative(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home || strings.HasPrefix(path, home+string(filepath.Separator)) {
+		return "${HOME}" + strings.TrimPrefix(path, home)
+	}
+	return path
+}
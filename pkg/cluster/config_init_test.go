@@ -0,0 +1,214 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"sigs.k8s.io/yaml"
+)
+
+func TestScaffoldConfigIsGenerated(t *testing.T) {
+	example, err := ioutil.ReadFile("../../examples/metal3ctl.dev.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(example) != scaffoldConfig {
+		t.Error("zz_generated.scaffold.go is out of date, please run go generate ./pkg/cluster")
+	}
+}
+
+func TestFindSourceTree(t *testing.T) {
+	layout := sourceTreeLayout{
+		name:    "cluster-api",
+		paths:   []string{"sigs.k8s.io/cluster-api", "cluster-api"},
+		markers: []string{"config", "bootstrap/kubeadm/config"},
+	}
+	tests := []struct {
+		name  string
+		dirs  []string
+		roots []string
+		want  string
+	}{
+		{
+			name:  "GOPATH like root",
+			dirs:  []string{"gopath/sigs.k8s.io/cluster-api/config", "gopath/sigs.k8s.io/cluster-api/bootstrap/kubeadm/config"},
+			roots: []string{"gopath"},
+			want:  "gopath/sigs.k8s.io/cluster-api",
+		},
+		{
+			name:  "plain root",
+			dirs:  []string{"src/cluster-api/config", "src/cluster-api/bootstrap/kubeadm/config"},
+			roots: []string{"src"},
+			want:  "src/cluster-api",
+		},
+		{
+			name: "first root wins",
+			dirs: []string{
+				"src/cluster-api/config", "src/cluster-api/bootstrap/kubeadm/config",
+				"gopath/sigs.k8s.io/cluster-api/config", "gopath/sigs.k8s.io/cluster-api/bootstrap/kubeadm/config",
+			},
+			roots: []string{"missing", "src", "gopath"},
+			want:  "src/cluster-api",
+		},
+		{
+			name:  "checkouts missing a marker are skipped",
+			dirs:  []string{"src/cluster-api/config"},
+			roots: []string{"src"},
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-source-tree")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, d := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			roots := []string{}
+			for _, root := range tt.roots {
+				roots = append(roots, filepath.Join(dir, root))
+			}
+			want := ""
+			if tt.want != "" {
+				want = filepath.Join(dir, tt.want)
+			}
+			if got := findSourceTree(roots, layout); got != want {
+				t.Errorf("findSourceTree() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSourceTreeVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		gitTag   string
+		want     string
+	}{
+		{
+			name:     "latest git tag",
+			gitTag:   "v0.3.3",
+			metadata: "releaseSeries:\n- major: 0\n  minor: 2\n",
+			want:     "v0.3.3",
+		},
+		{
+			name: "latest release series of the clusterctl metadata",
+			metadata: "releaseSeries:\n" +
+				"- major: 0\n  minor: 2\n" +
+				"- major: 1\n  minor: 0\n" +
+				"- major: 0\n  minor: 3\n",
+			want: "v1.0.0",
+		},
+		{
+			name: "no version",
+			want: devSourceTreeVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-source-tree")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if tt.metadata != "" {
+				if err := ioutil.WriteFile(filepath.Join(dir, "metadata.yaml"), []byte(tt.metadata), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.gitTag != "" {
+				if _, err := osexec.LookPath("git"); err != nil {
+					t.Skip("git is not installed")
+				}
+				for _, args := range [][]string{
+					{"init", "-q"},
+					{"-c", "user.name=metal3ctl", "-c", "user.email=metal3ctl@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+					{"tag", tt.gitTag},
+				} {
+					if out, err := osexec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+						t.Fatalf("git %v failed: %v: %s", args, err, out)
+					}
+				}
+			}
+			if got := sourceTreeVersion(context.Background(), dir); got != tt.want {
+				t.Errorf("sourceTreeVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScaffold(t *testing.T) {
+	trees := map[string]sourceTree{
+		"cluster-api":                 {Path: "/src/dev: #1/cluster-api", Version: "v0.3.3"},
+		"cluster-api-provider-metal3": {Path: "${HOME}/src/cluster-api-provider-metal3", Version: "v0.3.0"},
+		"baremetal-operator":          {Path: "'/src/baremetal-operator'", Version: devSourceTreeVersion},
+	}
+	mgmtContext := config.ManagementClusterContext{
+		Name:          "dev",
+		Kubeconfig:    "${HOME}/.kube/config: dev",
+		ArtifactsPath: "/tmp/_artifacts/dev #1/",
+	}
+	data, err := scaffold([]byte(scaffoldConfig), mgmtContext, trees)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), scaffoldHeader) {
+		t.Errorf("the generated config does not start with the header")
+	}
+	if !strings.Contains(string(data), "# keepalived, bundled or external") {
+		t.Errorf("the comments of the example are not preserved")
+	}
+
+	conf := &config.Metal3CtlConfig{}
+	if err := yaml.Unmarshal(data, conf); err != nil {
+		t.Fatalf("the generated config is not valid YAML: %v\n%s", err, data)
+	}
+	if conf.CurrentContext != mgmtContext.Name || !reflect.DeepEqual(conf.Contexts, []config.ManagementClusterContext{mgmtContext}) {
+		t.Errorf("got current context %q and contexts %+v, want %+v", conf.CurrentContext, conf.Contexts, mgmtContext)
+	}
+	versions := map[string]config.ComponentSource{"baremetal-operator": conf.BMOProvider.Versions[0]}
+	for _, provider := range conf.CAPIProviders {
+		versions[provider.Name+"/"+string(provider.Type)] = provider.Versions[0]
+	}
+	want := map[string][2]string{
+		"baremetal-operator":            {devSourceTreeVersion, "'/src/baremetal-operator'/deploy/ironic-keepalived-config"},
+		"cluster-api/CoreProvider":      {"v0.3.3", "/src/dev: #1/cluster-api/config"},
+		"kubeadm/BootstrapProvider":     {"v0.3.3", "/src/dev: #1/cluster-api/bootstrap/kubeadm/config"},
+		"kubeadm/ControlPlaneProvider":  {"v0.3.3", "/src/dev: #1/cluster-api/controlplane/kubeadm/config"},
+		"metal3/InfrastructureProvider": {"v0.3.0", "${HOME}/src/cluster-api-provider-metal3/config"},
+	}
+	for name, w := range want {
+		if got := versions[name]; got.Name != w[0] || got.Value != w[1] {
+			t.Errorf("got version %q with value %q for %s, want %q with value %q", got.Name, got.Value, name, w[0], w[1])
+		}
+	}
+}
@@ -24,7 +24,6 @@ import (
 	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
 	"github.com/pkg/errors"
 	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
-	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
 )
 
 type DeleteOptions struct {
@@ -40,6 +39,11 @@ func DeleteFromMgmtCluster(input config.LoadMetal3CtlConfigInput, options *Delet
 	if err != nil {
 		return errors.Wrapf(err, "error loading metal3ctl config file")
 	}
+	cleanup, err := useKubeContext(config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
 	if !options.SkipBMO {
 		err = DeleteBMOComponents(ctx, config, options)
@@ -56,16 +60,18 @@ func DeleteFromMgmtCluster(input config.LoadMetal3CtlConfigInput, options *Delet
 			return errors.Wrapf(err, "error creating clusterctl client")
 		}
 
-		if err := cctlClient.Delete(clusterctlclient.DeleteOptions{
-			Kubeconfig:              config.Kubeconfig,
-			IncludeNamespace:        options.IncludeNamespace,
-			IncludeCRDs:             options.IncludeCRDs,
-			CoreProvider:            clusterctlconfig.ClusterAPIProviderName,
-			BootstrapProviders:      []string{clusterctlconfig.KubeadmBootstrapProviderName},
-			ControlPlaneProviders:   []string{clusterctlconfig.KubeadmControlPlaneProviderName},
-			InfrastructureProviders: []string{config.InfraProvider()},
-			DeleteAll:               true,
-		}); err != nil {
+		// DeleteAll deletes all the providers recorded in the clusterctl inventory, whatever their name and type,
+		// so the providers installed with a different config are deleted as well.
+		// The BMO inventory records are hidden, so they are not deleted with the CAPI providers.
+		err = withoutBMOInventory(ctx, config, func() error {
+			return cctlClient.Delete(clusterctlclient.DeleteOptions{
+				Kubeconfig:       config.Kubeconfig,
+				IncludeNamespace: options.IncludeNamespace,
+				IncludeCRDs:      options.IncludeCRDs,
+				DeleteAll:        true,
+			})
+		})
+		if err != nil {
 			return errors.Wrapf(err, "error during clusterctl delete")
 		}
 	}
@@ -0,0 +1,275 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/config/exec"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
+)
+
+// resolveImages rewrites all the containers and init containers images in the objects according to the
+// image overrides and registry mirror defined for the provider.
+func resolveImages(objs []unstructured.Unstructured, conf *config.Metal3CtlConfig, provider config.ProviderConfig) error {
+	if len(conf.ImageOverridesFor(provider)) == 0 && conf.RegistryMirror == "" {
+		return nil
+	}
+	return util.FixImages(objs, conf.ImageResolverFor(provider))
+}
+
+// resolveManifestImages rewrites all the containers and init containers images in the YAML manifest according to the
+// image overrides and registry mirror defined for the provider.
+// If there is nothing to rewrite, the manifest is returned unchanged.
+func resolveManifestImages(manifest []byte, conf *config.Metal3CtlConfig, provider config.ProviderConfig) ([]byte, error) {
+	if len(conf.ImageOverridesFor(provider)) == 0 && conf.RegistryMirror == "" {
+		return manifest, nil
+	}
+	objs, err := util.ToUnstructured(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse yaml")
+	}
+	if err := resolveImages(objs, conf, provider); err != nil {
+		return nil, errors.Wrap(err, "failed to apply image overrides")
+	}
+	return util.FromUnstructured(objs)
+}
+
+// ListImages returns the list of container images required for initializing the management cluster, that is
+// the images in the BMO and CAPI provider manifests plus the images to be loaded into the mgmt cluster.
+func ListImages(ctx context.Context, conf *config.Metal3CtlConfig, options *InitOptions) ([]string, error) {
+	images := []string{}
+	seen := map[string]bool{}
+	add := func(list ...string) {
+		for _, image := range list {
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+
+	// providers are listed with the versions whose images are required; unless all the versions are requested, only
+	// the versions to be installed are kept.
+	providers := []config.ProviderConfig{}
+	if !options.SkipBMO {
+		bmoProvider := conf.BMOProvider
+		if !options.AllVersions {
+			version, err := bmoProvider.GetVersion(options.BMOVersion)
+			if err != nil {
+				return nil, err
+			}
+			bmoProvider.Versions = []config.ComponentSource{version}
+		}
+		providers = append(providers, bmoProvider)
+	}
+	if !options.SkipCAPI {
+		versions, err := selectCAPIVersions(conf, options.ProviderVersions)
+		if err != nil {
+			return nil, err
+		}
+		for _, provider := range conf.CAPIProviders {
+			providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+			if name, ok := versions[providerLabel]; ok && !options.AllVersions {
+				version, err := provider.GetVersion(name)
+				if err != nil {
+					return nil, err
+				}
+				provider.Versions = []config.ComponentSource{version}
+			}
+			providers = append(providers, provider)
+		}
+	}
+	for _, provider := range providers {
+		for _, version := range provider.Versions {
+			providerImages, err := versionImages(ctx, conf, provider, version)
+			if err != nil {
+				return nil, err
+			}
+			add(providerImages...)
+		}
+	}
+
+	containerImages, err := conf.ImagesToLoad()
+	if err != nil {
+		return nil, errors.Wrap(err, "error applying image overrides to the images list")
+	}
+	for _, image := range containerImages {
+		add(image.Name)
+	}
+	return images, nil
+}
+
+// versionImages returns the container images used in the manifest of a provider version.
+func versionImages(ctx context.Context, conf *config.Metal3CtlConfig, provider config.ProviderConfig, version config.ComponentSource) ([]string, error) {
+	version = provider.Ironic.Source(version)
+	generator := config.ComponentGeneratorForComponentSource(version)
+	manifest, err := generator.Manifests(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error generating the manifest for %q / %q", provider.Name, version.Name)
+	}
+	objs, err := util.ToUnstructured(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse yaml")
+	}
+	if err := resolveImages(objs, conf, provider); err != nil {
+		return nil, errors.Wrapf(err, "error applying image overrides for %q / %q", provider.Name, version.Name)
+	}
+	providerImages, err := util.InspectImages(objs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error inspecting images for %q / %q", provider.Name, version.Name)
+	}
+	return providerImages, nil
+}
+
+// MirrorImagesOptions carries the options supported by MirrorImages.
+type MirrorImagesOptions struct {
+	// ToRegistry is the private registry (optionally followed by a path prefix) the images are pushed to.
+	ToRegistry string
+
+	// ContainerTool is the container tool used to pull, tag and push images (e.g. docker or podman).
+	ContainerTool string
+
+	// ReportPath is the path of the file where the mapping between source and mirrored images is written.
+	// Defaults to image-mirror-report.yaml in the artifacts folder.
+	ReportPath string
+
+	// Insecure allows pushing to a registry without TLS verification (e.g. a local registry:2 instance); only podman
+	// supports this per command, with docker the registry should be configured as an insecure registry.
+	Insecure bool
+
+	// DryRun only writes the mapping report, without pulling or pushing any image.
+	DryRun bool
+
+	// AllVersions mirrors the images of all the provider versions defined in the config, instead of only the images
+	// of the versions installed by default.
+	AllVersions bool
+}
+
+// containerToolRunner runs a container tool command, e.g. docker push; it can be replaced in tests.
+type containerToolRunner func(ctx context.Context, tool string, args ...string) error
+
+// ImageMirrorReport is the mapping between source and mirrored images written by MirrorImages.
+type ImageMirrorReport struct {
+	Registry string             `json:"registry"`
+	Images   []ImageMirrorEntry `json:"images"`
+}
+
+// ImageMirrorEntry maps a source image to the image in the registry mirror.
+type ImageMirrorEntry struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// MirrorImages pulls all the images required for initializing the management cluster, retags them for the given
+// registry and pushes them, so the mgmt cluster can be initialized using registryMirror in air-gapped environments.
+func MirrorImages(input config.LoadMetal3CtlConfigInput, options *MirrorImagesOptions) (*ImageMirrorReport, error) {
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	if options.ReportPath == "" {
+		options.ReportPath = filepath.Join(conf.ArtifactsPath, "image-mirror-report.yaml")
+	}
+
+	// Images should be pulled from their original location, so the registry mirror eventually set in the config is ignored.
+	sourceConf := *conf
+	sourceConf.RegistryMirror = ""
+	images, err := ListImages(ctx, &sourceConf, &InitOptions{AllVersions: options.AllVersions})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing images")
+	}
+	return mirrorImages(ctx, images, options, runContainerTool)
+}
+
+// mirrorImages pulls, retags and pushes the given images using run, and writes the mapping report.
+func mirrorImages(ctx context.Context, images []string, options *MirrorImagesOptions, run containerToolRunner) (*ImageMirrorReport, error) {
+	log := logf.Log
+	if options.ToRegistry == "" {
+		return nil, errors.New("please specify the target registry")
+	}
+	if options.ContainerTool == "" {
+		options.ContainerTool = "docker"
+	}
+	insecureArgs := []string{}
+	if options.Insecure {
+		// docker has no per command option for skipping TLS verification
+		if filepath.Base(options.ContainerTool) != "podman" {
+			return nil, errors.Errorf("insecure is supported only with podman; with %s, please add %s to the insecure registries of the daemon", options.ContainerTool, options.ToRegistry)
+		}
+		insecureArgs = append(insecureArgs, "--tls-verify=false")
+	}
+
+	report := &ImageMirrorReport{Registry: options.ToRegistry}
+	for _, image := range images {
+		target, err := config.MirrorImage(image, options.ToRegistry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error mirroring image %q", image)
+		}
+		report.Images = append(report.Images, ImageMirrorEntry{Source: image, Target: target})
+	}
+
+	if !options.DryRun {
+		for _, entry := range report.Images {
+			log.Info("Mirroring image", "Source", entry.Source, "Target", entry.Target)
+			if err := run(ctx, options.ContainerTool, "pull", entry.Source); err != nil {
+				return nil, err
+			}
+			if err := run(ctx, options.ContainerTool, "tag", entry.Source, entry.Target); err != nil {
+				return nil, err
+			}
+			pushArgs := append(append([]string{"push"}, insecureArgs...), entry.Target)
+			if err := run(ctx, options.ContainerTool, pushArgs...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert to yaml the image mirror report")
+	}
+	if err := os.MkdirAll(filepath.Dir(options.ReportPath), 0755); err != nil {
+		return nil, errors.Wrapf(err, "error creating the folder for the image mirror report")
+	}
+	if err := ioutil.WriteFile(options.ReportPath, data, 0644); err != nil {
+		return nil, errors.Wrapf(err, "error writing the image mirror report")
+	}
+	return report, nil
+}
+
+func runContainerTool(ctx context.Context, tool string, args ...string) error {
+	cmd := exec.NewCommand(
+		exec.WithCommand(tool),
+		exec.WithArgs(args...))
+	_, stderr, err := cmd.Run(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "failed to execute %s: %s", fmt.Sprintf("%s %v", tool, args), stderr)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMirrorImages(t *testing.T) {
+	images := []string{
+		"quay.io/metal3-io/baremetal-operator:v0.2.0",
+		"gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+	}
+	tests := []struct {
+		name     string
+		options  MirrorImagesOptions
+		wantCmds []string
+		wantErr  bool
+	}{
+		{
+			name:    "images are pulled, retagged and pushed",
+			options: MirrorImagesOptions{ToRegistry: "localhost:5000/metal3"},
+			wantCmds: []string{
+				"docker pull quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"docker tag quay.io/metal3-io/baremetal-operator:v0.2.0 localhost:5000/metal3/quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"docker push localhost:5000/metal3/quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"docker pull gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+				"docker tag gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3 localhost:5000/metal3/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+				"docker push localhost:5000/metal3/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+			},
+		},
+		{
+			name:    "podman pushes without TLS verification",
+			options: MirrorImagesOptions{ToRegistry: "localhost:5000", ContainerTool: "podman", Insecure: true},
+			wantCmds: []string{
+				"podman pull quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"podman tag quay.io/metal3-io/baremetal-operator:v0.2.0 localhost:5000/quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"podman push --tls-verify=false localhost:5000/quay.io/metal3-io/baremetal-operator:v0.2.0",
+				"podman pull gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+				"podman tag gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3 localhost:5000/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+				"podman push --tls-verify=false localhost:5000/gcr.io/k8s-staging-cluster-api/cluster-api-controller:v0.3.3",
+			},
+		},
+		{
+			name:     "dry run does not run the container tool",
+			options:  MirrorImagesOptions{ToRegistry: "localhost:5000", DryRun: true},
+			wantCmds: []string{},
+		},
+		{
+			name:    "insecure is not supported with docker",
+			options: MirrorImagesOptions{ToRegistry: "localhost:5000", Insecure: true},
+			wantErr: true,
+		},
+		{
+			name:    "target registry is required",
+			options: MirrorImagesOptions{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-mirror")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			cmds := []string{}
+			run := func(_ context.Context, tool string, args ...string) error {
+				cmds = append(cmds, strings.Join(append([]string{tool}, args...), " "))
+				return nil
+			}
+			options := tt.options
+			options.ReportPath = filepath.Join(dir, "report.yaml")
+			report, err := mirrorImages(context.Background(), images, &options, run)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mirrorImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(cmds, tt.wantCmds) {
+				t.Errorf("got commands %v, want %v", cmds, tt.wantCmds)
+			}
+			if len(report.Images) != len(images) {
+				t.Errorf("got %d images in the report, want %d", len(report.Images), len(images))
+			}
+			if _, err := os.Stat(options.ReportPath); err != nil {
+				t.Errorf("the report was not written: %v", err)
+			}
+		})
+	}
+}
+
+// TestMirrorImagesToRegistry mirrors an image to a real registry, e.g. a local registry:2 instance started with
+// docker run -d -p 5000:5000 registry:2; it runs only if METAL3CTL_TEST_REGISTRY is set, e.g. to localhost:5000.
+func TestMirrorImagesToRegistry(t *testing.T) {
+	registry := os.Getenv("METAL3CTL_TEST_REGISTRY")
+	if registry == "" {
+		t.Skip("METAL3CTL_TEST_REGISTRY is not set")
+	}
+	dir, err := ioutil.TempDir("", "metal3ctl-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tool := os.Getenv("METAL3CTL_TEST_CONTAINER_TOOL")
+	options := &MirrorImagesOptions{
+		ToRegistry:    registry,
+		ContainerTool: tool,
+		Insecure:      filepath.Base(tool) == "podman",
+		ReportPath:    filepath.Join(dir, "report.yaml"),
+	}
+	report, err := mirrorImages(context.Background(), []string{"docker.io/library/busybox:1.31"}, options, runContainerTool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullArgs := []string{"pull", report.Images[0].Target}
+	if options.Insecure {
+		pullArgs = []string{"pull", "--tls-verify=false", report.Images[0].Target}
+	}
+	if err := runContainerTool(context.Background(), options.ContainerTool, pullArgs...); err != nil {
+		t.Errorf("the mirrored image can't be pulled: %v", err)
+	}
+}
@@ -18,17 +18,41 @@ package cluster
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
 	"github.com/pkg/errors"
 	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
-	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
 )
 
 type InitOptions struct {
 	ListImages bool
 	SkipBMO    bool
 	SkipCAPI   bool
+	Bundle     string
+	OutputDir  string
+	BMOVersion string
+
+	// ArtifactsPath is the local artifacts path a bundle is extracted into, ContainerTool is the container tool used
+	// to load the image tarballs of a bundle (e.g. docker or podman), and SkipImageLoad skips loading them; they are
+	// used only together with Bundle.
+	ArtifactsPath string
+	ContainerTool string
+	SkipImageLoad bool
+
+	// AllVersions lists the images of all the provider versions defined in the config, instead of only the images of
+	// the versions to be installed; it is used only together with ListImages.
+	AllVersions bool
+
+	// TargetNamespace and WatchingNamespace are the namespaces used by the CAPI providers and by the baremetal-operator
+	// which don't define their own namespaces in the config.
+	TargetNamespace   string
+	WatchingNamespace string
+
+	// ProviderVersions are the versions of the CAPI providers to be installed, by provider name or provider label
+	// (e.g. metal3=v0.3.0 or bootstrap-kubeadm=v0.3.3); the other providers are installed with the default version.
+	ProviderVersions map[string]string
 }
 
 func InitMgmtCluster(input config.LoadMetal3CtlConfigInput, options *InitOptions) error {
@@ -38,6 +62,16 @@ func InitMgmtCluster(input config.LoadMetal3CtlConfigInput, options *InitOptions
 	if err != nil {
 		return errors.Wrapf(err, " error loading metal3ctl config file")
 	}
+	setDefaultNamespaces(config, options)
+
+	if options.OutputDir != "" {
+		return renderMgmtCluster(ctx, config, options)
+	}
+	cleanup, err := useKubeContext(config)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 
 	// TODO: Prefetch Images into mgmt cluster.
 	// TODO: This is minikube specific. Make it more generic.
@@ -45,41 +79,165 @@ func InitMgmtCluster(input config.LoadMetal3CtlConfigInput, options *InitOptions
 	// for _, containerImage := range config.Images {
 	// 	fmt.Printf("Fetching image %q", containerImage.Name)
 	// }
-	if !options.SkipBMO {
-		_, err = InstallBMOComponents(ctx, config)
+	t := newTimings()
+	defer t.log()
+
+	// The BMO manifests and the CAPI provider manifests are built in parallel; for CAPI providers, only the versions
+	// to be installed are built.
+	var versions map[string]string
+	if !options.SkipCAPI {
+		versions, err = selectCAPIVersions(config, options.ProviderVersions)
 		if err != nil {
-			return errors.Wrapf(err, "error installing baremetal-operator components")
+			return err
 		}
 	}
+	p := proxy.NewProxy(config.Kubeconfig)
+	c, err := p.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create controller-runtime client")
+	}
 
-	if !options.SkipCAPI {
-		// Creates a local provider repository based on the configuration and a clusterctl config file that reads from this repository.
-		clusterctlConfig, err := CreateCAPIRepository(ctx, CreateCAPIRepositoryInput{
-			config:        config,
-			artifactsPath: config.ArtifactsPath,
-		})
+	// The BMO instances and the CAPI provider versions are built concurrently, sharing the same limit.
+	buildLimiter := newLimiter(maxParallelBuilds)
+	builds := []func() error{}
+	var bmoInstalls []*bmoInstall
+	if !options.SkipBMO {
+		version, err := config.BMOProvider.GetVersion(options.BMOVersion)
 		if err != nil {
-			return errors.Wrapf(err, "error creating local cluster-api repository")
+			return err
 		}
+		instances := config.BMOProvider.BMOInstances()
+		bmoInstalls = make([]*bmoInstall, len(instances))
+		builds = append(builds, func() error {
+			prepares := []func() error{}
+			for i := range instances {
+				i := i
+				prepares = append(prepares, func() error {
+					return t.track(fmt.Sprintf("build %s", bmoInstanceName(config.BMOProvider, instances[i])), func() error {
+						var err error
+						bmoInstalls[i], err = prepareBMOInstance(ctx, config, c, version, instances[i])
+						return err
+					})
+				})
+			}
+			return errors.Wrap(runParallel(buildLimiter, prepares...), "error generating baremetal-operator components")
+		})
+	}
+	var clusterctlConfig *ClusterctlConfig
+	if !options.SkipCAPI {
+		builds = append(builds, func() error {
+			// Creates a local provider repository based on the configuration and a clusterctl config file that reads from this repository.
+			var err error
+			clusterctlConfig, err = CreateCAPIRepository(ctx, CreateCAPIRepositoryInput{
+				config:               config,
+				artifactsPath:        config.ArtifactsPath,
+				versions:             versions,
+				onlySelectedVersions: true,
+				timings:              t,
+				limiter:              buildLimiter,
+			})
+			return errors.Wrap(err, "error creating local cluster-api repository")
+		})
+	}
+	if err := runParallel(nil, builds...); err != nil {
+		return err
+	}
 
-		cctlClient, err := clusterctlclient.New(clusterctlConfig.Path)
+	// BMO is installed in parallel with the core, bootstrap and control-plane providers, because they don't depend on
+	// each other; the infrastructure providers, e.g. CAPM3, depend on the BareMetalHost CRDs installed by BMO, so they
+	// are installed only once BMO is ready. The BMO inventory records are written once all the providers are installed.
+	var cctlClient clusterctlclient.Client
+	var providerInitOptions, infrastructureInitOptions []clusterctlclient.InitOptions
+	if !options.SkipCAPI {
+		cctlClient, err = clusterctlConfig.NewClient()
 		if err != nil {
 			return errors.Wrapf(err, "error creating clusterctl client")
 		}
-
-		initOpt := clusterctlclient.InitOptions{
-			Kubeconfig:              config.Kubeconfig,
-			CoreProvider:            clusterctlconfig.ClusterAPIProviderName,
-			BootstrapProviders:      []string{clusterctlconfig.KubeadmBootstrapProviderName},
-			ControlPlaneProviders:   []string{clusterctlconfig.KubeadmControlPlaneProviderName},
-			InfrastructureProviders: []string{config.InfraProvider()},
+		initOptions, err := capiInitOptions(config, versions)
+		if err != nil {
+			return err
 		}
-
-		_, err = cctlClient.Init(initOpt)
+		providerInitOptions, infrastructureInitOptions = splitInfrastructureProviders(initOptions)
+	}
+	installs := []func() error{}
+	if !options.SkipBMO {
+		installs = append(installs, func() error {
+			return t.track(fmt.Sprintf("install %s", config.BMOProvider.Name), func() error {
+				for _, install := range bmoInstalls {
+					if err := applyBMOInstance(ctx, config, p, c, install); err != nil {
+						return errors.Wrapf(err, "error installing baremetal-operator components")
+					}
+				}
+				return nil
+			})
+		})
+	}
+	if len(providerInitOptions) > 0 {
+		installs = append(installs, func() error {
+			return t.track("clusterctl init", func() error {
+				return clusterctlInit(ctx, config, cctlClient, providerInitOptions)
+			})
+		})
+	}
+	if err := runParallel(nil, installs...); err != nil {
+		return err
+	}
+	if len(infrastructureInitOptions) > 0 {
+		err := t.track("clusterctl init infrastructure providers", func() error {
+			return clusterctlInit(ctx, config, cctlClient, infrastructureInitOptions)
+		})
 		if err != nil {
-			return errors.Wrap(err, "failed to run clusterctl init")
+			return err
 		}
 	}
 
+	for _, install := range bmoInstalls {
+		if err := recordBMOInstance(ctx, config, p, install); err != nil {
+			return errors.Wrapf(err, "error installing baremetal-operator components")
+		}
+	}
 	return nil
 }
+
+// clusterctlInit runs clusterctl init with each of the given init options, in order, with the BMO inventory records
+// hidden from clusterctl.
+func clusterctlInit(ctx context.Context, conf *config.Metal3CtlConfig, cctlClient clusterctlclient.Client, initOptions []clusterctlclient.InitOptions) error {
+	return withoutBMOInventory(ctx, conf, func() error {
+		for _, initOpt := range initOptions {
+			if _, err := cctlClient.Init(initOpt); err != nil {
+				return errors.Wrap(err, "failed to run clusterctl init")
+			}
+		}
+		return nil
+	})
+}
+
+// InitImages returns the list of container images required for initializing the management cluster.
+func InitImages(input config.LoadMetal3CtlConfigInput, options *InitOptions) ([]string, error) {
+	ctx := context.TODO()
+	config, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	return ListImages(ctx, config, options)
+}
+
+// setDefaultNamespaces sets the target and watching namespaces given in the init options on the providers which don't
+// define their own namespaces; BMO instances always define their own namespaces.
+func setDefaultNamespaces(conf *config.Metal3CtlConfig, options *InitOptions) {
+	providers := []*config.ProviderConfig{}
+	for i := range conf.CAPIProviders {
+		providers = append(providers, &conf.CAPIProviders[i])
+	}
+	if len(conf.BMOProvider.Instances) == 0 {
+		providers = append(providers, &conf.BMOProvider)
+	}
+	for _, provider := range providers {
+		if provider.TargetNamespace == "" {
+			provider.TargetNamespace = options.TargetNamespace
+		}
+		if provider.WatchingNamespace == "" {
+			provider.WatchingNamespace = options.WatchingNamespace
+		}
+	}
+}
@@ -0,0 +1,773 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ironicCASecretName                   = "ironic-ca"
+	ironicTLSSecretName                  = "ironic-tls"
+	ironicCredentialsSecretName          = "ironic-credentials"
+	ironicInspectorCredentialsSecretName = "ironic-inspector-credentials"
+
+	// ironicCredentialsLabel marks the Secrets generated by metal3ctl for securing Ironic.
+	ironicCredentialsLabel = "metal3ctl.metal3.io/ironic-credentials"
+
+	// ironicRotatedAtAnnotation is added to the pod templates using the Ironic credentials when they are rotated,
+	// so the pods are restarted and pick up the new Secrets.
+	ironicRotatedAtAnnotation = "metal3ctl.metal3.io/ironic-credentials-rotated-at"
+
+	ironicCAValidity   = 10 * 365 * 24 * time.Hour
+	ironicCertValidity = 365 * 24 * time.Hour
+)
+
+// ironicSecretKeys are the keys of each Secret mounted into the containers; the CA private key is never mounted.
+var ironicSecretKeys = map[string][]string{
+	ironicCASecretName:                   {"tls.crt"},
+	ironicTLSSecretName:                  {"tls.crt", "tls.key"},
+	ironicCredentialsSecretName:          {"username", "password"},
+	ironicInspectorCredentialsSecretName: {"username", "password"},
+}
+
+var (
+	// bmoContainers are the names of the baremetal-operator containers, acting as Ironic clients.
+	bmoContainers = map[string]bool{"manager": true, "baremetal-operator": true}
+
+	// ironicContainers are the names of the containers serving the Ironic and Ironic Inspector APIs.
+	ironicContainers = map[string]bool{"ironic": true, "ironic-api": true, "ironic-conductor": true, "ironic-httpd": true, "ironic-inspector": true}
+)
+
+type secretMount struct {
+	secret    string
+	mountPath string
+}
+
+type secretEnv struct {
+	name   string
+	secret string
+	key    string
+}
+
+// ironicSecretMounts returns the Secrets to be mounted into a container, depending on the container role.
+func ironicSecretMounts(ironic *config.IronicConfig, container string) []secretMount {
+	mounts := []secretMount{}
+	switch {
+	case bmoContainers[container]:
+		if ironic.TLS {
+			mounts = append(mounts, secretMount{secret: ironicCASecretName, mountPath: "/opt/metal3/certs/ca"})
+		}
+		if ironic.BasicAuth {
+			mounts = append(mounts,
+				secretMount{secret: ironicCredentialsSecretName, mountPath: "/opt/metal3/auth/ironic"},
+				secretMount{secret: ironicInspectorCredentialsSecretName, mountPath: "/opt/metal3/auth/ironic-inspector"},
+			)
+		}
+	case ironicContainers[container]:
+		if ironic.TLS {
+			mounts = append(mounts,
+				secretMount{secret: ironicTLSSecretName, mountPath: "/certs/ironic"},
+				secretMount{secret: ironicTLSSecretName, mountPath: "/certs/ironic-inspector"},
+				secretMount{secret: ironicCASecretName, mountPath: "/certs/ca/ironic"},
+				secretMount{secret: ironicCASecretName, mountPath: "/certs/ca/ironic-inspector"},
+			)
+		}
+		if ironic.BasicAuth {
+			mounts = append(mounts,
+				secretMount{secret: ironicCredentialsSecretName, mountPath: "/auth/ironic"},
+				secretMount{secret: ironicInspectorCredentialsSecretName, mountPath: "/auth/ironic-inspector"},
+			)
+		}
+	}
+	return mounts
+}
+
+// ironicSecretEnvs returns the environment variables to be read from Secrets in a container, depending on the container role.
+func ironicSecretEnvs(ironic *config.IronicConfig, container string) []secretEnv {
+	if !ironicContainers[container] || !ironic.BasicAuth {
+		return nil
+	}
+	return []secretEnv{
+		{name: "IRONIC_HTPASSWD", secret: ironicCredentialsSecretName, key: "htpasswd"},
+		{name: "INSPECTOR_HTPASSWD", secret: ironicInspectorCredentialsSecretName, key: "htpasswd"},
+	}
+}
+
+// secureIronic enables TLS and/or basic-auth on the Ironic endpoints: the Ironic endpoints in the Ironic ConfigMap
+// are switched to https, the certificates and the credentials are generated as Secrets in the BMO namespace and
+// the Secrets are wired into the BMO and Ironic containers.
+func secureIronic(objs []unstructured.Unstructured, ironic *config.IronicConfig) ([]unstructured.Unstructured, error) {
+	namespace := bmoNamespace(objs)
+	if ironic.TLS {
+		env := map[string]string{}
+		for _, key := range []string{"IRONIC_ENDPOINT", "IRONIC_INSPECTOR_ENDPOINT"} {
+			if endpoint, ok := configMapData(objs, config.IronicBMOConfigMapName)[key]; ok {
+				env[key] = strings.Replace(endpoint, "http://", "https://", 1)
+			}
+		}
+		if len(env) > 0 {
+			objs = injectConfigMap(objs, config.IronicBMOConfigMapName, namespace, env)
+		}
+	}
+
+	hosts, err := ironicHosts(objs)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := newIronicSecrets(namespace, ironic, hosts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = util.VisitContainers(objs, func(obj *unstructured.Unstructured, podSpec, container map[string]interface{}) error {
+		name, _ := container["name"].(string)
+		for _, mount := range ironicSecretMounts(ironic, name) {
+			addSecretVolume(podSpec, mount.secret, ironicSecretKeys[mount.secret])
+			addVolumeMount(container, mount.secret, mount.mountPath)
+		}
+		for _, env := range ironicSecretEnvs(ironic, name) {
+			addSecretEnv(container, env.name, env.secret, env.key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The Secrets are added right after the namespaces, so they already exist when the deployments are created.
+	return insertAfterNamespaces(objs, secrets...), nil
+}
+
+// configMapData returns the data of the ConfigMap with the given name, eventually with the hash suffix added by kustomize.
+func configMapData(objs []unstructured.Unstructured, name string) map[string]string {
+	for _, obj := range objs {
+		if obj.GetKind() != "ConfigMap" || !isGeneratedName(obj.GetName(), name) {
+			continue
+		}
+		data, _, _ := unstructured.NestedStringMap(obj.Object, "data")
+		return data
+	}
+	return nil
+}
+
+// ironicHosts returns the hosts the Ironic certificates should be valid for, as defined in the Ironic ConfigMap.
+func ironicHosts(objs []unstructured.Unstructured) ([]string, error) {
+	data := configMapData(objs, config.IronicBMOConfigMapName)
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, key := range []string{"IRONIC_ENDPOINT", "IRONIC_INSPECTOR_ENDPOINT", "PROVISIONING_IP"} {
+		value, ok := data[key]
+		if !ok || value == "" {
+			continue
+		}
+		host := value
+		if strings.Contains(value, "://") {
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s %q in the %s ConfigMap", key, value, config.IronicBMOConfigMapName)
+			}
+			host = u.Hostname()
+		}
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.Errorf("failed to find the Ironic endpoints in the %s ConfigMap, please set provisioningNetwork", config.IronicBMOConfigMapName)
+	}
+	return hosts, nil
+}
+
+// newIronicSecrets generates the Secrets with the Ironic certificates and credentials; if ca is nil, a new CA is generated.
+func newIronicSecrets(namespace string, ironic *config.IronicConfig, hosts []string, ca *ironicCA) ([]unstructured.Unstructured, error) {
+	secrets := []unstructured.Unstructured{}
+	if ironic.TLS {
+		if ca == nil {
+			var err error
+			ca, err = newIronicCA()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to generate the Ironic CA")
+			}
+		}
+		certPEM, keyPEM, err := ca.newServerCert(hosts)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate the Ironic certificate")
+		}
+		secrets = append(secrets,
+			newSecret(ironicCASecretName, namespace, "Opaque", map[string][]byte{
+				"tls.crt": ca.certPEM(),
+				"tls.key": ca.keyPEM(),
+			}),
+			newSecret(ironicTLSSecretName, namespace, "kubernetes.io/tls", map[string][]byte{
+				"tls.crt": certPEM,
+				"tls.key": keyPEM,
+				"ca.crt":  ca.certPEM(),
+			}),
+		)
+	}
+	if ironic.BasicAuth {
+		for _, c := range []struct{ secret, username string }{
+			{secret: ironicCredentialsSecretName, username: "ironic-user"},
+			{secret: ironicInspectorCredentialsSecretName, username: "inspector-user"},
+		} {
+			data, err := newBasicAuthCredentials(c.username)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to generate the %s credentials", c.secret)
+			}
+			secrets = append(secrets, newSecret(c.secret, namespace, "Opaque", data))
+		}
+	}
+	return secrets, nil
+}
+
+func newSecret(name, namespace, secretType string, data map[string][]byte) unstructured.Unstructured {
+	values := map[string]interface{}{}
+	for k, v := range data {
+		values[k] = base64.StdEncoding.EncodeToString(v)
+	}
+	secret := unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetName(name)
+	secret.SetNamespace(namespace)
+	secret.SetLabels(map[string]string{ironicCredentialsLabel: "true"})
+	secret.Object["type"] = secretType
+	secret.Object["data"] = values
+	return secret
+}
+
+// newBasicAuthCredentials generates a random password for the user, plus the corresponding htpasswd entry.
+func newBasicAuthCredentials(username string) (map[string][]byte, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	password := base64.RawURLEncoding.EncodeToString(buf)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"username": []byte(username),
+		"password": []byte(password),
+		"htpasswd": []byte(username + ":" + string(hash)),
+	}, nil
+}
+
+func addSecretVolume(podSpec map[string]interface{}, secret string, keys []string) {
+	volumes, _ := podSpec["volumes"].([]interface{})
+	for _, v := range volumes {
+		if volume, ok := v.(map[string]interface{}); ok && volume["name"] == secret {
+			return
+		}
+	}
+	items := []interface{}{}
+	for _, key := range keys {
+		items = append(items, map[string]interface{}{"key": key, "path": key})
+	}
+	podSpec["volumes"] = append(volumes, map[string]interface{}{
+		"name": secret,
+		"secret": map[string]interface{}{
+			"secretName": secret,
+			"items":      items,
+		},
+	})
+}
+
+func addVolumeMount(container map[string]interface{}, volume, mountPath string) {
+	mounts, _ := container["volumeMounts"].([]interface{})
+	for _, m := range mounts {
+		if mount, ok := m.(map[string]interface{}); ok && mount["mountPath"] == mountPath {
+			return
+		}
+	}
+	container["volumeMounts"] = append(mounts, map[string]interface{}{
+		"name":      volume,
+		"mountPath": mountPath,
+		"readOnly":  true,
+	})
+}
+
+func addSecretEnv(container map[string]interface{}, name, secret, key string) {
+	env, _ := container["env"].([]interface{})
+	value := map[string]interface{}{
+		"name": name,
+		"valueFrom": map[string]interface{}{
+			"secretKeyRef": map[string]interface{}{"name": secret, "key": key},
+		},
+	}
+	for i, e := range env {
+		if v, ok := e.(map[string]interface{}); ok && v["name"] == name {
+			env[i] = value
+			return
+		}
+	}
+	container["env"] = append(env, value)
+}
+
+// keepIronicCredentials replaces the generated Ironic certificates and credentials with the ones already existing in
+// the mgmt cluster, if any, so running init again does not rotate them; use rotate-credentials for renewing them.
+func keepIronicCredentials(ctx context.Context, c client.Client, objs []unstructured.Unstructured) error {
+	return reuseIronicCredentials(objs, func(namespace, name string) (interface{}, error) {
+		secret := &unstructured.Unstructured{}
+		secret.SetAPIVersion("v1")
+		secret.SetKind("Secret")
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "failed to get the %s/%s Secret", namespace, name)
+		}
+		return secret.Object["data"], nil
+	})
+}
+
+// ironicSecretGetter returns the data of an existing Ironic Secret, or nil if the Secret does not exist.
+type ironicSecretGetter func(namespace, name string) (interface{}, error)
+
+// reuseIronicCredentials replaces the data of the generated Ironic Secrets with the data of the existing ones, as
+// returned by get; the CA and the server certificate are reused only if both exist, because the server certificate
+// must be signed by the CA. If the Ironic endpoints changed, a new server certificate is issued by the existing CA.
+func reuseIronicCredentials(objs []unstructured.Unstructured, get ironicSecretGetter) error {
+	current := map[string]interface{}{}
+	for _, obj := range objs {
+		if obj.GetKind() != "Secret" || obj.GetLabels()[ironicCredentialsLabel] == "" {
+			continue
+		}
+		data, err := get(obj.GetNamespace(), obj.GetName())
+		if err != nil {
+			return err
+		}
+		current[obj.GetName()] = data
+	}
+
+	if (current[ironicCASecretName] == nil) != (current[ironicTLSSecretName] == nil) {
+		delete(current, ironicCASecretName)
+		delete(current, ironicTLSSecretName)
+	}
+	if current[ironicTLSSecretName] != nil {
+		hosts, err := ironicHosts(objs)
+		if err != nil {
+			return err
+		}
+		current[ironicTLSSecretName], err = reissueIronicServerCert(current[ironicCASecretName], current[ironicTLSSecretName], hosts)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range objs {
+		obj := &objs[i]
+		if obj.GetKind() != "Secret" || obj.GetLabels()[ironicCredentialsLabel] == "" {
+			continue
+		}
+		if data, ok := current[obj.GetName()]; ok && data != nil {
+			obj.Object["data"] = data
+		}
+	}
+	return nil
+}
+
+// reissueIronicServerCert returns the data of the ironic-tls Secret, with a new server certificate signed by the CA
+// if the existing one is not valid for exactly the given hosts; the data is returned unchanged otherwise.
+func reissueIronicServerCert(caData, tlsData interface{}, hosts []string) (interface{}, error) {
+	log := logf.Log
+	tls, _ := tlsData.(map[string]interface{})
+	encodedCert, _ := tls["tls.crt"].(string)
+	if certHosts, err := serverCertHosts(encodedCert); err == nil && sameHosts(certHosts, hosts) {
+		return tlsData, nil
+	}
+
+	caSecret, _ := caData.(map[string]interface{})
+	encodedCACert, _ := caSecret["tls.crt"].(string)
+	encodedCAKey, _ := caSecret["tls.key"].(string)
+	ca, err := parseIronicCA(encodedCACert, encodedCAKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid CA in the %s Secret", ironicCASecretName)
+	}
+	log.Info("Issuing a new Ironic server certificate for the changed Ironic endpoints", "Hosts", strings.Join(hosts, ","))
+	certPEM, keyPEM, err := ca.newServerCert(hosts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate the Ironic certificate")
+	}
+	return map[string]interface{}{
+		"tls.crt": base64.StdEncoding.EncodeToString(certPEM),
+		"tls.key": base64.StdEncoding.EncodeToString(keyPEM),
+		"ca.crt":  base64.StdEncoding.EncodeToString(ca.certPEM()),
+	}, nil
+}
+
+// serverCertHosts returns the hosts a base64 encoded PEM server certificate is valid for.
+func serverCertHosts(encodedCert string) ([]string, error) {
+	der, err := decodePEM(encodedCert, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	hosts := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts, nil
+}
+
+// sameHosts returns true if a and b contain the same hosts, in any order; IP addresses are compared by value.
+func sameHosts(a, b []string) bool {
+	normalize := func(hosts []string) []string {
+		ret := make([]string, 0, len(hosts))
+		for _, host := range hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				host = ip.String()
+			}
+			ret = append(ret, host)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// withIronicSecrets replaces the Ironic Secrets in objs with the given ones; the Secrets not found in objs are added
+// right after the namespaces.
+func withIronicSecrets(objs []unstructured.Unstructured, secrets []unstructured.Unstructured) []unstructured.Unstructured {
+	ret := make([]unstructured.Unstructured, 0, len(objs))
+	replaced := map[string]bool{}
+	for _, obj := range objs {
+		if obj.GetKind() == "Secret" {
+			for _, secret := range secrets {
+				if secret.GetName() == obj.GetName() && secret.GetNamespace() == obj.GetNamespace() {
+					obj = secret
+					replaced[secret.GetName()] = true
+				}
+			}
+		}
+		ret = append(ret, obj)
+	}
+	missing := []unstructured.Unstructured{}
+	for _, secret := range secrets {
+		if !replaced[secret.GetName()] {
+			missing = append(missing, secret)
+		}
+	}
+	return insertAfterNamespaces(ret, missing...)
+}
+
+// RotateCredentialsOptions carries the options supported by RotateIronicCredentials.
+type RotateCredentialsOptions struct {
+	// RotateCA generates a new CA too; by default the new server certificate is signed with the existing CA,
+	// so clients trusting the CA do not need to be updated.
+	RotateCA bool
+}
+
+// RotateIronicCredentials renews the Ironic certificates and basic-auth credentials in the mgmt cluster, and restarts
+// the BMO and Ironic pods so they pick up the new Secrets.
+func RotateIronicCredentials(input config.LoadMetal3CtlConfigInput, options *RotateCredentialsOptions) error {
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	cleanup, err := useKubeContext(conf)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	ironic := conf.BMOProvider.Ironic
+	if !ironic.IsSecured() {
+		return errors.New("neither TLS nor basic-auth are enabled for Ironic, please set bmoProvider.ironic.tls or bmoProvider.ironic.basicAuth")
+	}
+
+	p := proxy.NewProxy(conf.Kubeconfig)
+	c, err := p.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create controller-runtime client")
+	}
+
+	instances, err := installedBMOInstances(ctx, c, conf.BMOProvider)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if err := rotateIronicCredentials(ctx, conf, p, c, instance, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateIronicCredentials renews the Ironic credentials of a BMO instance.
+func rotateIronicCredentials(ctx context.Context, conf *config.Metal3CtlConfig, p *proxy.Proxy, c client.Client, instance config.BMOInstance, options *RotateCredentialsOptions) error {
+	log := logf.Log
+	ironic := conf.BMOProvider.Ironic
+
+	// Use the objects recorded in the BMO repository at install time, if any.
+	objs, err := installedBMOComponents(ctx, c, conf, instance)
+	if err != nil {
+		return err
+	}
+	namespace := bmoNamespace(objs)
+	hosts, err := ironicHosts(objs)
+	if err != nil {
+		return err
+	}
+
+	var ca *ironicCA
+	if ironic.TLS && !options.RotateCA {
+		ca, err = getIronicCA(ctx, c, namespace)
+		if err != nil {
+			return err
+		}
+	}
+	secrets, err := newIronicSecrets(namespace, ironic, hosts, ca)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		log.Info("Rotating", "Secret", secret.GetName(), "Namespace", secret.GetNamespace())
+	}
+	if err := createComponents(ctx, p, secrets); err != nil {
+		return errors.Wrap(err, "failed to update the Ironic credentials in mgmt cluster")
+	}
+
+	// The BMO repository records the objects installed in the mgmt cluster, so the copy of the Secrets is updated too.
+	installed, err := installedBMOVersion(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+	if err != nil {
+		return err
+	}
+	objs = withIronicSecrets(objs, secrets)
+	if err := updateBMORepositoryManifest(conf, instance, installed, objs); err != nil {
+		return err
+	}
+
+	if err := restartIronicWorkloads(ctx, c, objs); err != nil {
+		return err
+	}
+	if err := waitForProvider(ctx, c, conf.BMOProvider, instance.TargetNamespace, objs); err != nil {
+		return errors.Wrap(err, "error waiting for bmo components")
+	}
+	return nil
+}
+
+// restartIronicWorkloads restarts the workloads mounting the Ironic Secrets by changing an annotation in their pod template.
+func restartIronicWorkloads(ctx context.Context, c client.Client, objs []unstructured.Unstructured) error {
+	log := logf.Log
+	rotatedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, obj := range objs {
+		switch obj.GetKind() {
+		case "Deployment", "DaemonSet", "StatefulSet":
+		default:
+			continue
+		}
+		if !mountsIronicSecrets(obj) {
+			continue
+		}
+
+		current := &unstructured.Unstructured{}
+		current.SetGroupVersionKind(obj.GroupVersionKind())
+		if err := c.Get(ctx, client.ObjectKey{Namespace: obj.GetNamespace(), Name: obj.GetName()}, current); err != nil {
+			return errors.Wrapf(err, "failed to get %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		}
+		patch := client.MergeFrom(current.DeepCopy())
+		if err := unstructured.SetNestedField(current.Object, rotatedAt, "spec", "template", "metadata", "annotations", ironicRotatedAtAnnotation); err != nil {
+			return errors.Wrapf(err, "failed to set the pod template annotations in %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		}
+		log.Info("Restarting", "Kind", obj.GetKind(), "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		if err := c.Patch(ctx, current, patch); err != nil {
+			return errors.Wrapf(err, "failed to restart %s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+		}
+	}
+	return nil
+}
+
+func mountsIronicSecrets(obj unstructured.Unstructured) bool {
+	volumes, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "volumes")
+	for _, v := range volumes {
+		volume, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		secretName, _, _ := unstructured.NestedString(volume, "secret", "secretName")
+		if _, ok := ironicSecretKeys[secretName]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ironicCA is the self-signed CA used for signing the Ironic certificates.
+type ironicCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+func newIronicCA() (*ironicCA, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "metal3ctl-ironic-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(ironicCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &ironicCA{cert: cert, key: key}, nil
+}
+
+// getIronicCA reads the Ironic CA from the mgmt cluster; if it does not exist, nil is returned.
+func getIronicCA(ctx context.Context, c client.Client, namespace string) (*ironicCA, error) {
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ironicCASecretName}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to get the %s/%s Secret", namespace, ironicCASecretName)
+	}
+	data, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+	ca, err := parseIronicCA(data["tls.crt"], data["tls.key"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid CA in the %s/%s Secret", namespace, ironicCASecretName)
+	}
+	return ca, nil
+}
+
+// parseIronicCA parses the base64 encoded PEM certificate and key of the Ironic CA.
+func parseIronicCA(encodedCert, encodedKey string) (*ironicCA, error) {
+	certDER, err := decodePEM(encodedCert, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := decodePEM(encodedKey, "RSA PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+	return &ironicCA{cert: cert, key: key}, nil
+}
+
+// decodePEM decodes a base64 encoded PEM block of the given type, as found in the data of a Secret.
+func decodePEM(encoded, blockType string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, errors.Errorf("failed to decode the %s PEM block", blockType)
+	}
+	return block.Bytes, nil
+}
+
+// newServerCert generates a server certificate signed by the CA, valid for the given hosts.
+func (ca *ironicCA) newServerCert(hosts []string) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(ironicCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		nil
+}
+
+func (ca *ironicCA) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+func (ca *ironicCA) keyPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(ca.key)})
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIronicServerCert(t *testing.T) {
+	ca, err := newIronicCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := ca.newServerCert([]string{"172.22.0.2", "ironic.metal3.svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("invalid server certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "RSA PRIVATE KEY" {
+		t.Fatalf("invalid server key PEM")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, host := range []string{"172.22.0.2", "ironic.metal3.svc"} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err != nil {
+			t.Errorf("the server certificate is not valid for %s: %v", host, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "172.22.0.3", Roots: roots}); err == nil {
+		t.Errorf("the server certificate should not be valid for 172.22.0.3")
+	}
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("172.22.0.2")) {
+		t.Errorf("got IP SANs %v, want [172.22.0.2]", cert.IPAddresses)
+	}
+	if !reflect.DeepEqual(cert.DNSNames, []string{"ironic.metal3.svc"}) {
+		t.Errorf("got DNS SANs %v, want [ironic.metal3.svc]", cert.DNSNames)
+	}
+
+	parsed, err := parseIronicCA(base64.StdEncoding.EncodeToString(ca.certPEM()), base64.StdEncoding.EncodeToString(ca.keyPEM()))
+	if err != nil {
+		t.Fatalf("parseIronicCA() error = %v", err)
+	}
+	if !parsed.cert.Equal(ca.cert) || parsed.key.N.Cmp(ca.key.N) != 0 {
+		t.Errorf("parseIronicCA() did not return the original CA")
+	}
+	if _, err := parseIronicCA(base64.StdEncoding.EncodeToString(ca.keyPEM()), base64.StdEncoding.EncodeToString(ca.keyPEM())); err == nil {
+		t.Errorf("parseIronicCA() should fail with a key instead of a certificate")
+	}
+}
+
+func TestNewBasicAuthCredentials(t *testing.T) {
+	data, err := newBasicAuthCredentials("ironic-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data["username"]) != "ironic-user" {
+		t.Errorf("got username %q, want %q", data["username"], "ironic-user")
+	}
+	entry := strings.SplitN(string(data["htpasswd"]), ":", 2)
+	if len(entry) != 2 || entry[0] != "ironic-user" {
+		t.Fatalf("invalid htpasswd entry %q", data["htpasswd"])
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(entry[1]), data["password"]); err != nil {
+		t.Errorf("the htpasswd entry does not match the password: %v", err)
+	}
+	other, err := newBasicAuthCredentials("ironic-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(other["password"]) == string(data["password"]) {
+		t.Errorf("newBasicAuthCredentials() generated the same password twice")
+	}
+}
+
+func TestReuseIronicCredentials(t *testing.T) {
+	ironic := &config.IronicConfig{TLS: true, BasicAuth: true}
+	existing, err := newIronicSecrets("metal3", ironic, []string{"172.22.0.2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existingData := map[string]interface{}{}
+	for _, secret := range existing {
+		existingData[secret.GetName()] = secret.Object["data"]
+	}
+
+	tests := []struct {
+		name     string
+		existing []string
+		// endpoint is the Ironic endpoint of the generated objects.
+		endpoint   string
+		wantReused []string
+	}{
+		{
+			name:       "all the credentials are reused",
+			existing:   []string{ironicCASecretName, ironicTLSSecretName, ironicCredentialsSecretName, ironicInspectorCredentialsSecretName},
+			endpoint:   "https://172.22.0.2:6385/v1/",
+			wantReused: []string{ironicCASecretName, ironicTLSSecretName, ironicCredentialsSecretName, ironicInspectorCredentialsSecretName},
+		},
+		{
+			name:       "the server certificate is issued again by the same CA when the endpoints change",
+			existing:   []string{ironicCASecretName, ironicTLSSecretName, ironicCredentialsSecretName, ironicInspectorCredentialsSecretName},
+			endpoint:   "https://ironic.metal3.svc:6385/v1/",
+			wantReused: []string{ironicCASecretName, ironicCredentialsSecretName, ironicInspectorCredentialsSecretName},
+		},
+		{
+			name:       "the CA is not reused without the server certificate",
+			existing:   []string{ironicCASecretName, ironicCredentialsSecretName},
+			endpoint:   "https://172.22.0.2:6385/v1/",
+			wantReused: []string{ironicCredentialsSecretName},
+		},
+		{
+			name:       "nothing is reused on the first install",
+			existing:   []string{},
+			endpoint:   "https://172.22.0.2:6385/v1/",
+			wantReused: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := newIronicSecrets("metal3", ironic, []string{"172.22.0.2"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configMap := unstructured.Unstructured{}
+			configMap.SetKind("ConfigMap")
+			configMap.SetName(config.IronicBMOConfigMapName)
+			configMap.SetNamespace("metal3")
+			configMap.Object["data"] = map[string]interface{}{"IRONIC_ENDPOINT": tt.endpoint}
+			objs = append(objs, configMap)
+			get := func(namespace, name string) (interface{}, error) {
+				for _, n := range tt.existing {
+					if namespace == "metal3" && n == name {
+						return existingData[name], nil
+					}
+				}
+				return nil, nil
+			}
+			if err := reuseIronicCredentials(objs, get); err != nil {
+				t.Fatal(err)
+			}
+			reused := []string{}
+			for _, obj := range objs {
+				if reflect.DeepEqual(obj.Object["data"], existingData[obj.GetName()]) {
+					reused = append(reused, obj.GetName())
+				}
+			}
+			if !reflect.DeepEqual(reused, tt.wantReused) {
+				t.Errorf("got reused Secrets %v, want %v", reused, tt.wantReused)
+			}
+			hosts, err := ironicHosts(objs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := map[string]map[string]interface{}{}
+			for _, obj := range objs {
+				data[obj.GetName()], _ = obj.Object["data"].(map[string]interface{})
+			}
+			ca, err := parseIronicCA(data[ironicCASecretName]["tls.crt"].(string), data[ironicCASecretName]["tls.key"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			certDER, err := decodePEM(data[ironicTLSSecretName]["tls.crt"].(string), "CERTIFICATE")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := x509.ParseCertificate(certDER)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignatureFrom(ca.cert); err != nil {
+				t.Errorf("the server certificate is not signed by the CA: %v", err)
+			}
+			if err := cert.VerifyHostname(hosts[0]); err != nil {
+				t.Errorf("the server certificate is not valid for the Ironic endpoint: %v", err)
+			}
+
+			// Reusing the same credentials again does not change them.
+			again := make([]unstructured.Unstructured, len(objs))
+			for i := range objs {
+				again[i] = *objs[i].DeepCopy()
+			}
+			if err := reuseIronicCredentials(again, get); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, objs) {
+				t.Errorf("reusing the credentials twice changed them")
+			}
+		})
+	}
+}
+
+func TestWithIronicSecrets(t *testing.T) {
+	namespace := unstructured.Unstructured{}
+	namespace.SetKind("Namespace")
+	namespace.SetName("metal3")
+	old := newSecret(ironicCredentialsSecretName, "metal3", "Opaque", map[string][]byte{"password": []byte("old")})
+	rotated := newSecret(ironicCredentialsSecretName, "metal3", "Opaque", map[string][]byte{"password": []byte("new")})
+	added := newSecret(ironicInspectorCredentialsSecretName, "metal3", "Opaque", map[string][]byte{"password": []byte("new")})
+
+	got := withIronicSecrets([]unstructured.Unstructured{namespace, old}, []unstructured.Unstructured{rotated, added})
+	want := []unstructured.Unstructured{namespace, added, rotated}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withIronicSecrets() = %v, want %v", got, want)
+	}
+}
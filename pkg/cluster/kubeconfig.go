@@ -0,0 +1,85 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+)
+
+// useKubeContext replaces the kubeconfig of the mgmt cluster with a copy using the selected kube context, if any;
+// this is required because clusterctl always uses the current context of the kubeconfig. The copy holds the
+// credentials of the mgmt cluster, so it is a temporary file readable only by the owner, removed by the returned
+// cleanup function.
+func useKubeContext(conf *config.Metal3CtlConfig) (func(), error) {
+	if conf.KubeContext == "" {
+		return func() {}, nil
+	}
+
+	kubeconfigPath := conf.Kubeconfig
+	if kubeconfigPath == "" {
+		kubeconfigPath = clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
+	}
+	kubeconfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load Kubeconfig file from %q", kubeconfigPath)
+	}
+	if _, ok := kubeconfig.Contexts[conf.KubeContext]; !ok {
+		return nil, errors.Errorf("context %q is not defined in Kubeconfig file %q", conf.KubeContext, kubeconfigPath)
+	}
+	kubeconfig.CurrentContext = conf.KubeContext
+	if err := clientcmdapi.MinifyConfig(kubeconfig); err != nil {
+		return nil, errors.Wrapf(err, "failed to select context %q", conf.KubeContext)
+	}
+	// The copy is stored in a different folder, so certificates and keys are embedded.
+	if err := clientcmdapi.FlattenConfig(kubeconfig); err != nil {
+		return nil, errors.Wrapf(err, "failed to embed the certificates of context %q", conf.KubeContext)
+	}
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to serialize the Kubeconfig for context %q", conf.KubeContext)
+	}
+
+	// TempFile creates the file with mode 0600.
+	f, err := ioutil.TempFile("", "metal3ctl-kubeconfig-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a temporary Kubeconfig file")
+	}
+	cleanup := func() {
+		if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+			logf.Log.Info("Failed to remove the temporary Kubeconfig file", "Kubeconfig", f.Name(), "Error", err.Error())
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		cleanup()
+		return nil, errors.Wrapf(err, "failed to write Kubeconfig file %q", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, errors.Wrapf(err, "failed to write Kubeconfig file %q", f.Name())
+	}
+	logf.Log.V(3).Info("Using kube context", "Context", conf.KubeContext, "Kubeconfig", f.Name())
+	conf.Kubeconfig = f.Name()
+	return cleanup, nil
+}
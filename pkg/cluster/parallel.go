@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+)
+
+// maxParallelBuilds is the maximum number of manifests built at the same time; building a manifest usually runs
+// kustomize, which is CPU and IO bound.
+const maxParallelBuilds = 4
+
+// limiter limits the number of tasks running at the same time; it can be shared by runParallel calls running
+// concurrently, e.g. the BMO and CAPI provider builds, so that together they don't exceed the limit. A nil *limiter
+// doesn't limit the tasks.
+type limiter struct {
+	sem chan struct{}
+}
+
+func newLimiter(limit int) *limiter {
+	return &limiter{sem: make(chan struct{}, limit)}
+}
+
+// run runs f once fewer tasks than the limit are running.
+func (l *limiter) run(f func() error) error {
+	if l == nil {
+		return f()
+	}
+	l.sem <- struct{}{}
+	defer func() { <-l.sem }()
+	return f()
+}
+
+// runParallel runs the tasks concurrently, with at most as many tasks running at the same time as allowed by the
+// limiter, and waits for all of them to complete; the errors of all the failed tasks are returned as an aggregate.
+func runParallel(l *limiter, tasks ...func() error) error {
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i := range tasks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = l.run(tasks[i])
+		}(i)
+	}
+	wg.Wait()
+	return kerrors.NewAggregate(errs)
+}
+
+// timings records how long the steps of an operation take; it is safe for concurrent use, and a nil *timings
+// runs the steps without recording them.
+type timings struct {
+	lock  sync.Mutex
+	start time.Time
+	steps []timing
+}
+
+type timing struct {
+	name     string
+	duration time.Duration
+}
+
+func newTimings() *timings {
+	return &timings{start: time.Now()}
+}
+
+// track runs f and records its duration as the given step.
+func (t *timings) track(name string, f func() error) error {
+	if t == nil {
+		return f()
+	}
+	start := time.Now()
+	err := f()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.steps = append(t.steps, timing{name: name, duration: time.Since(start)})
+	return err
+}
+
+// log prints the duration of each step, in completion order, and the total duration; steps running in parallel
+// overlap, so their sum can exceed the total.
+func (t *timings) log() {
+	if t == nil {
+		return
+	}
+	log := logf.Log
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, step := range t.steps {
+		log.Info("Timing", "Step", step.name, "Duration", step.duration.Round(time.Millisecond).String())
+	}
+	log.Info("Timing", "Step", "total", "Duration", time.Since(t.start).Round(time.Millisecond).String())
+}
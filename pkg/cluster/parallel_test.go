@@ -0,0 +1,212 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+func TestRunParallel(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		groups      int
+		tasks       int
+		failing     int
+		wantMaxRuns int32
+	}{
+		{
+			name:        "no limit",
+			groups:      1,
+			tasks:       5,
+			wantMaxRuns: 5,
+		},
+		{
+			name:        "limit",
+			limit:       2,
+			groups:      1,
+			tasks:       5,
+			wantMaxRuns: 2,
+		},
+		{
+			name:        "limit shared by concurrent calls",
+			limit:       3,
+			groups:      2,
+			tasks:       4,
+			wantMaxRuns: 3,
+		},
+		{
+			name:        "errors of all the failed tasks",
+			limit:       2,
+			groups:      1,
+			tasks:       4,
+			failing:     3,
+			wantMaxRuns: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l *limiter
+			if tt.limit > 0 {
+				l = newLimiter(tt.limit)
+			}
+			var running, maxRuns, done int32
+			// Every task waits until the limit is reached, or all the tasks are running, so the test doesn't depend
+			// on the scheduling of the goroutines.
+			started := make(chan struct{}, tt.groups*tt.tasks)
+			release := make(chan struct{})
+			go func() {
+				for i := int32(0); i < tt.wantMaxRuns; i++ {
+					<-started
+				}
+				close(release)
+			}()
+			errs := make([]error, tt.groups)
+			var wg sync.WaitGroup
+			for g := 0; g < tt.groups; g++ {
+				tasks := []func() error{}
+				for i := 0; i < tt.tasks; i++ {
+					i := i
+					tasks = append(tasks, func() error {
+						n := atomic.AddInt32(&running, 1)
+						defer atomic.AddInt32(&running, -1)
+						for {
+							max := atomic.LoadInt32(&maxRuns)
+							if n <= max || atomic.CompareAndSwapInt32(&maxRuns, max, n) {
+								break
+							}
+						}
+						started <- struct{}{}
+						<-release
+						atomic.AddInt32(&done, 1)
+						if i < tt.failing {
+							return errors.Errorf("task %d failed", i)
+						}
+						return nil
+					})
+				}
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					errs[g] = runParallel(l, tasks...)
+				}(g)
+			}
+			wg.Wait()
+
+			if got := atomic.LoadInt32(&maxRuns); got != tt.wantMaxRuns {
+				t.Errorf("tasks running at the same time = %d, want %d", got, tt.wantMaxRuns)
+			}
+			if got := int(atomic.LoadInt32(&done)); got != tt.groups*tt.tasks {
+				t.Errorf("completed tasks = %d, want %d", got, tt.groups*tt.tasks)
+			}
+			for _, err := range errs {
+				if tt.failing == 0 {
+					if err != nil {
+						t.Errorf("runParallel() error = %v", err)
+					}
+					continue
+				}
+				agg, ok := err.(kerrors.Aggregate)
+				if !ok {
+					t.Fatalf("runParallel() error = %v, want an aggregate", err)
+				}
+				if len(agg.Errors()) != tt.failing {
+					t.Errorf("runParallel() errors = %v, want %d errors", agg.Errors(), tt.failing)
+				}
+			}
+		})
+	}
+}
+
+func TestTimings(t *testing.T) {
+	tests := []struct {
+		name      string
+		timings   *timings
+		steps     []string
+		failing   string
+		wantSteps []string
+	}{
+		{
+			name:      "records the steps",
+			timings:   newTimings(),
+			steps:     []string{"build bmo", "build capm3", "install bmo"},
+			wantSteps: []string{"build bmo", "build capm3", "install bmo"},
+		},
+		{
+			name:      "records the failed steps",
+			timings:   newTimings(),
+			steps:     []string{"build bmo", "build capm3"},
+			failing:   "build capm3",
+			wantSteps: []string{"build bmo", "build capm3"},
+		},
+		{
+			name:    "nil timings run the steps without recording them",
+			steps:   []string{"build bmo", "build capm3"},
+			failing: "build bmo",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran int32
+			tasks := []func() error{}
+			for _, step := range tt.steps {
+				step := step
+				tasks = append(tasks, func() error {
+					return tt.timings.track(step, func() error {
+						atomic.AddInt32(&ran, 1)
+						time.Sleep(time.Millisecond)
+						if step == tt.failing {
+							return fmt.Errorf("%s failed", step)
+						}
+						return nil
+					})
+				})
+			}
+			err := runParallel(nil, tasks...)
+			if (err != nil) != (tt.failing != "") {
+				t.Errorf("track() error = %v, want error %v", err, tt.failing != "")
+			}
+			if int(ran) != len(tt.steps) {
+				t.Errorf("steps run = %d, want %d", ran, len(tt.steps))
+			}
+			tt.timings.log()
+
+			if tt.timings == nil {
+				return
+			}
+			gotSteps := []string{}
+			for _, step := range tt.timings.steps {
+				gotSteps = append(gotSteps, step.name)
+				if step.duration < time.Millisecond {
+					t.Errorf("duration of %q = %v, want at least 1ms", step.name, step.duration)
+				}
+			}
+			sort.Strings(gotSteps)
+			if fmt.Sprint(gotSteps) != fmt.Sprint(tt.wantSteps) {
+				t.Errorf("steps = %v, want %v", gotSteps, tt.wantSteps)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	clusterctlembedded "sigs.k8s.io/cluster-api/cmd/clusterctl/config"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// embeddedInventoryCRDPath is the path of the clusterctl inventory CRD embedded in clusterctl.
+	embeddedInventoryCRDPath = "cmd/clusterctl/config/manifest/clusterctl-api.yaml"
+
+	// embeddedCertManagerPath is the path of the cert-manager manifest embedded in clusterctl.
+	embeddedCertManagerPath = "cmd/clusterctl/config/assets/cert-manager.yaml"
+)
+
+const (
+	// renderedSecretsSuffix is added to the name of the files holding the Secrets written by renderMgmtCluster.
+	renderedSecretsSuffix = "-secrets"
+
+	// renderedSecretsFileMode are the permissions of the files holding Secrets or secret variable values.
+	renderedSecretsFileMode os.FileMode = 0600
+)
+
+// kustomization is the kustomization.yaml file written by renderMgmtCluster.
+type kustomization struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Resources  []string `json:"resources"`
+}
+
+// renderMgmtCluster writes the fully processed BMO and CAPI provider manifests, including the clusterctl provider
+// inventory recording both BMO and the CAPI providers, as ordered files into the output dir, plus a kustomization.yaml,
+// instead of applying them to the mgmt cluster.
+func renderMgmtCluster(ctx context.Context, conf *config.Metal3CtlConfig, options *InitOptions) error {
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return errors.Wrapf(err, "error creating the output dir %q", options.OutputDir)
+	}
+
+	// The Ironic credentials rendered by a previous run, if any, are reused, so rendering again gives the same output.
+	previousSecrets, err := readRenderedSecrets(options.OutputDir)
+	if err != nil {
+		return err
+	}
+
+	out := &renderOutput{dir: options.OutputDir}
+
+	// The inventory CRD is needed by both the BMO and the CAPI inventory records.
+	inventoryCRD, err := clusterctlembedded.Asset(embeddedInventoryCRDPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the clusterctl inventory CRD embedded in clusterctl")
+	}
+	if err := out.write("clusterctl-inventory-crd", inventoryCRD, 0644); err != nil {
+		return err
+	}
+	inventory := []unstructured.Unstructured{}
+
+	if !options.SkipBMO {
+		version, err := conf.BMOProvider.GetVersion(options.BMOVersion)
+		if err != nil {
+			return err
+		}
+		// The BMO CRDs are shared by the BMO instances, so they are rendered only with the first one.
+		for i, instance := range conf.BMOProvider.BMOInstances() {
+			_, objs, err := generateBMOComponents(ctx, conf, version, instance)
+			if err != nil {
+				return errors.Wrapf(err, "error generating baremetal-operator components")
+			}
+			name := conf.BMOProvider.Name
+			if instance.TargetNamespace != "" {
+				name = fmt.Sprintf("%s-%s", name, instance.TargetNamespace)
+			}
+			if i > 0 {
+				objs = withoutKind(objs, "CustomResourceDefinition")
+			}
+			if err := reuseIronicCredentials(objs, previousSecrets.get); err != nil {
+				return err
+			}
+			record, err := toUnstructured(newBMOInventory(conf.BMOProvider, instance, version.Name, bmoNamespace(objs), bmoWatchingNamespace(objs)))
+			if err != nil {
+				return errors.Wrapf(err, "error converting the inventory record for %q", conf.BMOProvider.Name)
+			}
+			inventory = append(inventory, *record)
+			if err := out.writeObjs(name, objs); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !options.SkipCAPI {
+		versions, err := selectCAPIVersions(conf, options.ProviderVersions)
+		if err != nil {
+			return err
+		}
+		clusterctlConfig, err := CreateCAPIRepository(ctx, CreateCAPIRepositoryInput{
+			config:               conf,
+			artifactsPath:        conf.ArtifactsPath,
+			versions:             versions,
+			onlySelectedVersions: true,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error creating local cluster-api repository")
+		}
+
+		cctlClient, err := clusterctlConfig.NewClient()
+		if err != nil {
+			return errors.Wrapf(err, "error creating clusterctl client")
+		}
+
+		certManager, err := clusterctlembedded.Asset(embeddedCertManagerPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to get the cert-manager manifest embedded in clusterctl")
+		}
+		if err := out.write("cert-manager", certManager, 0644); err != nil {
+			return err
+		}
+
+		for _, providerType := range []clusterctlv1.ProviderType{
+			clusterctlv1.CoreProviderType,
+			clusterctlv1.BootstrapProviderType,
+			clusterctlv1.ControlPlaneProviderType,
+			clusterctlv1.InfrastructureProviderType,
+		} {
+			for _, provider := range conf.CAPIProviders {
+				if clusterctlv1.ProviderType(provider.Type) != providerType {
+					continue
+				}
+				components, err := cctlClient.GetProviderComponents(providerReference(provider.Name, providerType, versions), providerType, provider.TargetNamespace, provider.WatchingNamespace)
+				if err != nil {
+					return errors.Wrapf(err, "error getting the components for %q", clusterctlv1.ManifestLabel(provider.Name, providerType))
+				}
+				if err := out.writeObjs(clusterctlv1.ManifestLabel(provider.Name, providerType), components.Objs()); err != nil {
+					return errors.Wrapf(err, "error writing the components for %q", clusterctlv1.ManifestLabel(provider.Name, providerType))
+				}
+
+				inventoryObj := components.InventoryObject()
+				obj, err := toUnstructured(&inventoryObj)
+				if err != nil {
+					return errors.Wrapf(err, "error converting the inventory object for %q", clusterctlv1.ManifestLabel(provider.Name, providerType))
+				}
+				obj.SetGroupVersionKind(clusterctlv1.GroupVersion.WithKind("Provider"))
+				inventory = append(inventory, *obj)
+			}
+		}
+	}
+
+	if len(inventory) > 0 {
+		data, err := util.FromUnstructured(inventory)
+		if err != nil {
+			return err
+		}
+		if err := out.write("clusterctl-inventory", data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return out.finish()
+}
+
+// renderedFileName matches the names of the files written by renderOutput.
+var renderedFileName = regexp.MustCompile(`^[0-9]{2}-.+\.yaml$`)
+
+// renderOutput writes rendered objects into an output dir, as ordered files listed in a kustomization.yaml.
+type renderOutput struct {
+	dir       string
+	resources []string
+}
+
+// write writes a rendered file; files containing the values of secret variables are readable only by the owner.
+func (o *renderOutput) write(name string, data []byte, mode os.FileMode) error {
+	log := logf.Log
+	fileName := fmt.Sprintf("%02d-%s.yaml", len(o.resources), name)
+	if mode != renderedSecretsFileMode && config.ContainsSecretValue(string(data)) {
+		log.Info("The file contains the values of secret variables, it is readable only by the owner", "File", fileName)
+		mode = renderedSecretsFileMode
+	}
+	path := filepath.Join(o.dir, fileName)
+	if err := ioutil.WriteFile(path, data, mode); err != nil {
+		return errors.Wrapf(err, "error writing %q", fileName)
+	}
+	// WriteFile keeps the permissions of an existing file, e.g. written by a previous run.
+	if err := os.Chmod(path, mode); err != nil {
+		return errors.Wrapf(err, "error setting the permissions of %q", fileName)
+	}
+	log.Info("Rendered", "File", path)
+	o.resources = append(o.resources, fileName)
+	return nil
+}
+
+// writeObjs writes rendered objects; the Secrets are written into a separate file, readable only by the owner, next
+// to the other objects.
+func (o *renderOutput) writeObjs(name string, objs []unstructured.Unstructured) error {
+	secrets, others := []unstructured.Unstructured{}, []unstructured.Unstructured{}
+	for _, obj := range objs {
+		if obj.GetKind() == "Secret" {
+			secrets = append(secrets, obj)
+		} else {
+			others = append(others, obj)
+		}
+	}
+	data, err := util.FromUnstructured(others)
+	if err != nil {
+		return err
+	}
+	if err := o.write(name, data, 0644); err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	data, err = util.FromUnstructured(secrets)
+	if err != nil {
+		return err
+	}
+	return o.write(name+renderedSecretsSuffix, data, renderedSecretsFileMode)
+}
+
+// finish writes the kustomization.yaml, then removes the files written by a previous run which are not part of the
+// output anymore, e.g. for a provider removed from the config, so applying the output dir with kubectl apply -f
+// gives the same result as with kustomize. The stale files are removed only at the end, so the Ironic credentials
+// rendered by the previous run are not lost if rendering fails.
+func (o *renderOutput) finish() error {
+	data, err := yaml.Marshal(kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  o.resources,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to yaml the kustomization file")
+	}
+	if err := ioutil.WriteFile(filepath.Join(o.dir, "kustomization.yaml"), data, 0644); err != nil {
+		return errors.Wrap(err, "error writing kustomization.yaml")
+	}
+
+	entries, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return errors.Wrapf(err, "error listing the output dir %q", o.dir)
+	}
+	current := map[string]bool{}
+	for _, r := range o.resources {
+		current[r] = true
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !renderedFileName.MatchString(entry.Name()) || current[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(o.dir, entry.Name())); err != nil {
+			return errors.Wrapf(err, "error removing the stale file %q", entry.Name())
+		}
+	}
+	return nil
+}
+
+// renderedSecrets are the Secrets written into an output dir by a previous run of renderMgmtCluster, by namespace and name.
+type renderedSecrets map[string]interface{}
+
+// readRenderedSecrets reads the Secrets written into the output dir by a previous run of renderMgmtCluster, if any.
+func readRenderedSecrets(outputDir string) (renderedSecrets, error) {
+	paths, err := filepath.Glob(filepath.Join(outputDir, "*"+renderedSecretsSuffix+".yaml"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing the Secrets in the output dir %q", outputDir)
+	}
+	secrets := renderedSecrets{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %q", path)
+		}
+		objs, err := util.ToUnstructured(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing %q", path)
+		}
+		for _, obj := range objs {
+			if obj.GetKind() == "Secret" {
+				secrets[obj.GetNamespace()+"/"+obj.GetName()] = obj.Object["data"]
+			}
+		}
+	}
+	return secrets, nil
+}
+
+// get returns the data of a Secret written by a previous run, or nil; it is an ironicSecretGetter.
+func (s renderedSecrets) get(namespace, name string) (interface{}, error) {
+	return s[namespace+"/"+name], nil
+}
+
+// toUnstructured converts a typed object into an Unstructured object.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
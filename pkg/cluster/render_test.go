@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func renderTestObj(kind, namespace, name string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"namespace": namespace,
+			"name":      name,
+		},
+	}}
+	if kind == "Secret" {
+		obj.Object["data"] = map[string]interface{}{"password": "c2VjcmV0"}
+	}
+	return obj
+}
+
+func TestRenderOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		previous  []string
+		objs      map[string][]unstructured.Unstructured
+		wantFiles []string
+		wantModes map[string]os.FileMode
+	}{
+		{
+			name: "empty output dir",
+			objs: map[string][]unstructured.Unstructured{
+				"bmo": {
+					renderTestObj("Deployment", "metal3", "baremetal-operator"),
+					renderTestObj("Secret", "metal3", "ironic-credentials"),
+				},
+			},
+			wantFiles: []string{"00-namespace.yaml", "01-bmo.yaml", "02-bmo-secrets.yaml", "kustomization.yaml"},
+			wantModes: map[string]os.FileMode{
+				"00-namespace.yaml":   0644,
+				"01-bmo.yaml":         0644,
+				"02-bmo-secrets.yaml": renderedSecretsFileMode,
+			},
+		},
+		{
+			name:     "stale files of a previous run are removed, other files are kept",
+			previous: []string{"00-namespace.yaml", "01-bmo.yaml", "02-bmo-secrets.yaml", "03-capm3.yaml", "README.md", "patch.yaml"},
+			objs: map[string][]unstructured.Unstructured{
+				"bmo": {
+					renderTestObj("Deployment", "metal3", "baremetal-operator"),
+				},
+			},
+			wantFiles: []string{"00-namespace.yaml", "01-bmo.yaml", "README.md", "kustomization.yaml", "patch.yaml"},
+			wantModes: map[string]os.FileMode{
+				"00-namespace.yaml": 0644,
+				"01-bmo.yaml":       0644,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-render")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, name := range tt.previous {
+				// A previous run may have left files readable by everyone, the permissions must be reset.
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("kind: Previous\n"), 0666); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			out := &renderOutput{dir: dir}
+			if err := out.write("namespace", []byte("kind: Namespace\n"), 0644); err != nil {
+				t.Fatalf("write() error = %v", err)
+			}
+			if err := out.writeObjs("bmo", tt.objs["bmo"]); err != nil {
+				t.Fatalf("writeObjs() error = %v", err)
+			}
+			if err := out.finish(); err != nil {
+				t.Fatalf("finish() error = %v", err)
+			}
+
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotFiles := []string{}
+			for _, entry := range entries {
+				gotFiles = append(gotFiles, entry.Name())
+				if want, ok := tt.wantModes[entry.Name()]; ok && entry.Mode().Perm() != want {
+					t.Errorf("mode of %q = %v, want %v", entry.Name(), entry.Mode().Perm(), want)
+				}
+			}
+			sort.Strings(gotFiles)
+			if !reflect.DeepEqual(gotFiles, tt.wantFiles) {
+				t.Errorf("files = %v, want %v", gotFiles, tt.wantFiles)
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			k := kustomization{}
+			if err := yaml.Unmarshal(data, &k); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(k.Resources, out.resources) {
+				t.Errorf("kustomization resources = %v, want %v", k.Resources, out.resources)
+			}
+
+			secrets, err := readRenderedSecrets(dir)
+			if err != nil {
+				t.Fatalf("readRenderedSecrets() error = %v", err)
+			}
+			for _, obj := range tt.objs["bmo"] {
+				if obj.GetKind() != "Secret" {
+					continue
+				}
+				got, _ := secrets.get(obj.GetNamespace(), obj.GetName())
+				if !reflect.DeepEqual(got, obj.Object["data"]) {
+					t.Errorf("rendered Secret %s/%s data = %v, want %v", obj.GetNamespace(), obj.GetName(), got, obj.Object["data"])
+				}
+			}
+		})
+	}
+}
@@ -18,50 +18,88 @@ package cluster
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/Arvinderpal/metal3ctl/config"
 	"github.com/Arvinderpal/metal3ctl/pkg/internal/util"
 	"github.com/pkg/errors"
 	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	clusterctlconfig "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
 )
 
 // CreateCAPIRepositoryInput is the input for CreateCAPIRepository.
 type CreateCAPIRepositoryInput struct {
 	artifactsPath string
 	config        *config.Metal3CtlConfig
+
+	// versions are the versions to be used as a default by clusterctl, by provider label; if a provider is not
+	// listed, its default version is used.
+	versions map[string]string
+
+	// onlySelectedVersions builds only the manifests of the versions to be used as a default by clusterctl, e.g. for
+	// init, instead of the manifests of all the versions, as required for planning upgrades.
+	onlySelectedVersions bool
+
+	// timings, if set, records the time spent building the manifest of each provider version.
+	timings *timings
+
+	// limiter, if set, limits the manifests built at the same time, together with the other builds sharing it;
+	// defaults to maxParallelBuilds.
+	limiter *limiter
 }
 
 // CreateCAPIRepository creates a local repository based on the metal3ctl config and returns a metal3ctl config
-// file to be used for working with such repository.
+// file to be used for working with such repository. The manifests of the provider versions are built in parallel.
 func CreateCAPIRepository(ctx context.Context, input CreateCAPIRepositoryInput) (*ClusterctlConfig, error) {
 	providers := []ClusterctlConfigProvider{}
 	repositoryPath := util.GetRepositoryPath(input.artifactsPath)
+
+	builds := []func() error{}
 	for _, provider := range input.config.CAPIProviders {
-		providerUrl := ""
-		for _, version := range provider.Versions {
-			providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
-
-			generator := config.ComponentGeneratorForComponentSource(version)
-			manifest, err := generator.Manifests(ctx)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error generating the manifest for %q / %q", providerLabel, version.Name)
-			}
-			sourcePath := filepath.Join(repositoryPath, providerLabel, version.Name)
-			if err := os.MkdirAll(sourcePath, 0755); err != nil {
-				return nil, errors.Wrapf(err, "error creating the repository folder for %q / %q", providerLabel, version.Name)
-			}
+		metadata, err := providerMetadata(provider)
+		if err != nil {
+			return nil, err
+		}
+		metadataData, err := yaml.Marshal(metadata)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert to yaml the metadata for %q", provider.Name)
+		}
+		providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+		for _, version := range repositoryVersions(provider, input) {
+			provider, version := provider, version
+			builds = append(builds, func() error {
+				return input.timings.track(fmt.Sprintf("build %s/%s", providerLabel, version.Name), func() error {
+					return writeCAPIVersion(ctx, input.config, repositoryPath, provider, version, metadataData)
+				})
+			})
+		}
+	}
+	limiter := input.limiter
+	if limiter == nil {
+		limiter = newLimiter(maxParallelBuilds)
+	}
+	if err := runParallel(limiter, builds...); err != nil {
+		return nil, err
+	}
 
-			filePath := filepath.Join(sourcePath, "components.yaml")
-			if err := ioutil.WriteFile(filePath, manifest, 0755); err != nil {
-				return nil, errors.Wrapf(err, "error writing manifest for %q / %q", providerLabel, version.Name)
-			}
+	for _, provider := range input.config.CAPIProviders {
+		providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+		defaultVersion := provider.DefaultVersion().Name
+		if version, ok := input.versions[providerLabel]; ok {
+			defaultVersion = version
+		}
+		providerUrl := filepath.Join(repositoryPath, providerLabel, defaultVersion, componentsFileName)
 
-			if providerUrl == "" {
-				providerUrl = filePath
-			}
+		if err := pruneStaleVersions(filepath.Join(repositoryPath, providerLabel), provider); err != nil {
+			return nil, errors.Wrapf(err, "error removing stale versions for %q", providerLabel)
 		}
 		providers = append(providers, ClusterctlConfigProvider{
 			Name: provider.Name,
@@ -69,20 +107,36 @@ func CreateCAPIRepository(ctx context.Context, input CreateCAPIRepositoryInput)
 			Type: provider.Type,
 		})
 
-		for _, file := range provider.Files {
-			data, err := ioutil.ReadFile(file.SourcePath)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error reading file %q / %q", provider.Name, file.SourcePath)
+		// The files and the cluster templates are copied into the folder of each version, so they are found
+		// whichever version clusterctl uses, e.g. when upgrading.
+		for _, version := range repositoryVersions(provider, input) {
+			versionPath := filepath.Join(repositoryPath, providerLabel, version.Name)
+			for _, file := range provider.Files {
+				data, err := ioutil.ReadFile(file.SourcePath)
+				if err != nil {
+					return nil, errors.Wrapf(err, "error reading file %q / %q", provider.Name, file.SourcePath)
+				}
+				if err := ioutil.WriteFile(filepath.Join(versionPath, file.TargetName), data, 0644); err != nil {
+					return nil, errors.Wrapf(err, "error writing file %q / %q / %q", provider.Name, version.Name, file.TargetName)
+				}
 			}
 
-			destinationFile := filepath.Join(filepath.Dir(providerUrl), file.TargetName)
-			err = ioutil.WriteFile(destinationFile, data, 0644)
-			if err != nil {
-				return nil, errors.Wrapf(err, "error writing file %q / %q", provider.Name, file.TargetName)
+			for _, template := range provider.ClusterTemplates {
+				data, err := ioutil.ReadFile(template.SourcePath)
+				if err != nil {
+					return nil, errors.Wrapf(err, "error reading cluster template %q / %q", provider.Name, template.SourcePath)
+				}
+				if err := ioutil.WriteFile(filepath.Join(versionPath, template.FileName()), data, 0644); err != nil {
+					return nil, errors.Wrapf(err, "error writing cluster template %q / %q / %q", provider.Name, version.Name, template.FileName())
+				}
 			}
 		}
 	}
 
+	if err := validateCAPIRepository(repositoryPath, input.config.CAPIProviders, input.versions, input.onlySelectedVersions); err != nil {
+		return nil, errors.Wrap(err, "invalid local cluster-api repository")
+	}
+
 	clusterctlConfigFile := &ClusterctlConfig{
 		Path: filepath.Join(repositoryPath, util.CLUSTERCTL_CONFIG_FILENAME),
 		Values: map[string]interface{}{
@@ -92,8 +146,210 @@ func CreateCAPIRepository(ctx context.Context, input CreateCAPIRepositoryInput)
 	for key, value := range input.config.Variables {
 		clusterctlConfigFile.Values[key] = value
 	}
+	// Secret variables are not written to the clusterctl config file; they are passed to clusterctl by the config
+	// reader of the clients created with NewClient.
+	secrets, err := resolveSecretVariables(ctx, input.config)
+	if err != nil {
+		return nil, err
+	}
+	clusterctlConfigFile.secrets = secrets
 	if err := clusterctlConfigFile.WriteFile(); err != nil {
 		return nil, errors.Wrapf(err, "error writing the clusterctl config file")
 	}
 	return clusterctlConfigFile, nil
 }
+
+// repositoryVersions returns the provider versions to be written into the local repository.
+func repositoryVersions(provider config.ProviderConfig, input CreateCAPIRepositoryInput) []config.ComponentSource {
+	if !input.onlySelectedVersions {
+		return provider.Versions
+	}
+	providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+	versions := []config.ComponentSource{}
+	for _, version := range provider.Versions {
+		if version.Name == input.versions[providerLabel] {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// writeCAPIVersion builds the manifest of a provider version and writes it into the local repository, together
+// with the provider metadata.
+func writeCAPIVersion(ctx context.Context, conf *config.Metal3CtlConfig, repositoryPath string, provider config.ProviderConfig, version config.ComponentSource, metadata []byte) error {
+	providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+	generator := config.ComponentGeneratorForComponentSource(version)
+	manifest, err := generator.Manifests(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "error generating the manifest for %q / %q", providerLabel, version.Name)
+	}
+	manifest, err = resolveManifestImages(manifest, conf, provider)
+	if err != nil {
+		return errors.Wrapf(err, "error applying image overrides for %q / %q", providerLabel, version.Name)
+	}
+	sourcePath := filepath.Join(repositoryPath, providerLabel, version.Name)
+	if err := os.MkdirAll(sourcePath, 0755); err != nil {
+		return errors.Wrapf(err, "error creating the repository folder for %q / %q", providerLabel, version.Name)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(sourcePath, componentsFileName), manifest, 0755); err != nil {
+		return errors.Wrapf(err, "error writing manifest for %q / %q", providerLabel, version.Name)
+	}
+	if err := ioutil.WriteFile(filepath.Join(sourcePath, metadataFileName), metadata, 0644); err != nil {
+		return errors.Wrapf(err, "error writing metadata for %q / %q", providerLabel, version.Name)
+	}
+	return nil
+}
+
+// pruneStaleVersions removes from the provider folder of the local repository the versions which are no longer
+// defined in the config, so clusterctl can't pick them.
+func pruneStaleVersions(providerPath string, provider config.ProviderConfig) error {
+	log := logf.Log
+	entries, err := ioutil.ReadDir(providerPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	versions := map[string]bool{}
+	for _, version := range provider.Versions {
+		versions[version.Name] = true
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || versions[entry.Name()] {
+			continue
+		}
+		log.Info("Removing stale version from the local repository", "Provider", filepath.Base(providerPath), "Version", entry.Name())
+		if err := os.RemoveAll(filepath.Join(providerPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectCAPIVersions returns the version of each CAPI provider to be installed, by provider label. Versions can be
+// selected by provider name or provider label, e.g. metal3=v0.3.0 or bootstrap-kubeadm=v0.3.3, with the label taking
+// precedence; for the other providers, the default version is used.
+func selectCAPIVersions(conf *config.Metal3CtlConfig, selected map[string]string) (map[string]string, error) {
+	versions := map[string]string{}
+	used := map[string]bool{}
+	for _, provider := range conf.CAPIProviders {
+		providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+		name := ""
+		for _, key := range []string{provider.Name, providerLabel} {
+			if v, ok := selected[key]; ok {
+				name = v
+				used[key] = true
+			}
+		}
+		if name == "" && len(provider.Versions) == 0 {
+			continue
+		}
+		version, err := provider.GetVersion(name)
+		if err != nil {
+			return nil, err
+		}
+		versions[providerLabel] = version.Name
+	}
+
+	unknown := []string{}
+	for key := range selected {
+		if !used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("providers [%s] are not defined in capiProviders", strings.Join(unknown, ", "))
+	}
+	return versions, nil
+}
+
+// providerReference returns the reference to a provider used by clusterctl, that is name:version, or only the name
+// if the provider has no versions.
+func providerReference(name string, providerType clusterctlv1.ProviderType, versions map[string]string) string {
+	if version, ok := versions[clusterctlv1.ManifestLabel(name, providerType)]; ok {
+		return name + ":" + version
+	}
+	return name
+}
+
+// capiInitOptions returns the clusterctl init options for the CAPI providers defined in the config; clusterctl applies
+// the target and watching namespace to all the providers installed by an init call, so providers are grouped by
+// namespaces, with one init call for each group. The group of the core provider comes first, because on the first
+// init clusterctl adds the kubeadm providers if no bootstrap or control-plane provider is given.
+func capiInitOptions(conf *config.Metal3CtlConfig, versions map[string]string) ([]clusterctlclient.InitOptions, error) {
+	type namespaces struct {
+		target   string
+		watching string
+	}
+	keys := []namespaces{}
+	groups := map[namespaces]*clusterctlclient.InitOptions{}
+	for _, provider := range conf.CAPIProviders {
+		key := namespaces{target: provider.TargetNamespace, watching: provider.WatchingNamespace}
+		group, ok := groups[key]
+		if !ok {
+			group = &clusterctlclient.InitOptions{
+				Kubeconfig:        conf.Kubeconfig,
+				TargetNamespace:   key.target,
+				WatchingNamespace: key.watching,
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+
+		providerType := clusterctlv1.ProviderType(provider.Type)
+		reference := providerReference(provider.Name, providerType, versions)
+		switch providerType {
+		case clusterctlv1.CoreProviderType:
+			group.CoreProvider = reference
+			// moves the group of the core provider first
+			for i := range keys {
+				if keys[i] == key {
+					keys = append(append([]namespaces{key}, keys[:i]...), keys[i+1:]...)
+					break
+				}
+			}
+		case clusterctlv1.BootstrapProviderType:
+			group.BootstrapProviders = append(group.BootstrapProviders, reference)
+		case clusterctlv1.ControlPlaneProviderType:
+			group.ControlPlaneProviders = append(group.ControlPlaneProviders, reference)
+		case clusterctlv1.InfrastructureProviderType:
+			group.InfrastructureProviders = append(group.InfrastructureProviders, reference)
+		}
+	}
+
+	initOptions := []clusterctlclient.InitOptions{}
+	for _, key := range keys {
+		initOptions = append(initOptions, *groups[key])
+	}
+	if len(initOptions) > 0 && (len(initOptions[0].BootstrapProviders) == 0 || len(initOptions[0].ControlPlaneProviders) == 0) {
+		return nil, errors.Errorf("the %s provider must have the same targetNamespace and watchingNamespace of at least one bootstrap and one control-plane provider, otherwise clusterctl installs the kubeadm ones", clusterctlconfig.ClusterAPIProviderName)
+	}
+	return initOptions, nil
+}
+
+// splitInfrastructureProviders splits the clusterctl init options into the options for the core, bootstrap and
+// control-plane providers, which don't depend on BMO, and the options for the infrastructure providers, which depend
+// on the BareMetalHost CRDs installed by BMO; init options left without providers are dropped.
+func splitInfrastructureProviders(initOptions []clusterctlclient.InitOptions) ([]clusterctlclient.InitOptions, []clusterctlclient.InitOptions) {
+	providers := []clusterctlclient.InitOptions{}
+	infrastructure := []clusterctlclient.InitOptions{}
+	for _, initOpt := range initOptions {
+		if len(initOpt.InfrastructureProviders) > 0 {
+			infraOpt := clusterctlclient.InitOptions{
+				Kubeconfig:              initOpt.Kubeconfig,
+				TargetNamespace:         initOpt.TargetNamespace,
+				WatchingNamespace:       initOpt.WatchingNamespace,
+				InfrastructureProviders: initOpt.InfrastructureProviders,
+			}
+			infrastructure = append(infrastructure, infraOpt)
+			initOpt.InfrastructureProviders = nil
+		}
+		if initOpt.CoreProvider != "" || len(initOpt.BootstrapProviders) > 0 || len(initOpt.ControlPlaneProviders) > 0 {
+			providers = append(providers, initOpt)
+		}
+	}
+	return providers, infrastructure
+}
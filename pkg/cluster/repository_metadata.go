@@ -0,0 +1,200 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	metadataFileName   = "metadata.yaml"
+	componentsFileName = "components.yaml"
+)
+
+// providerMetadata returns the clusterctl metadata.yaml for a provider, mapping the release series of all the
+// provider versions to API contracts. The release series are read from the metadata files of the versions, or from
+// the source trees of local kustomization roots, and the missing ones are generated from the version names; the
+// contract set on a version takes precedence.
+func providerMetadata(provider config.ProviderConfig) (*clusterctlv1.Metadata, error) {
+	series := map[string]clusterctlv1.ReleaseSeries{}
+	for _, version := range provider.Versions {
+		path := version.Metadata
+		if path == "" {
+			path = sourceTreeMetadata(version)
+		}
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading the metadata for %q / %q", provider.Name, version.Name)
+		}
+		metadata := &clusterctlv1.Metadata{}
+		if err := yaml.Unmarshal(data, metadata); err != nil {
+			return nil, errors.Wrapf(err, "error parsing the metadata %q for %q / %q", path, provider.Name, version.Name)
+		}
+		for _, s := range metadata.ReleaseSeries {
+			key := fmt.Sprintf("%d.%d", s.Major, s.Minor)
+			if _, ok := series[key]; !ok {
+				series[key] = s
+			}
+		}
+	}
+
+	for _, version := range provider.Versions {
+		v, err := utilversion.ParseSemantic(version.Name)
+		if err != nil {
+			// reported by validateCAPIRepository
+			continue
+		}
+		key := fmt.Sprintf("%d.%d", v.Major(), v.Minor())
+		if _, ok := series[key]; ok && version.Contract == "" {
+			continue
+		}
+		contract := version.Contract
+		if contract == "" {
+			contract = clusterv1.GroupVersion.Version
+		}
+		series[key] = clusterctlv1.ReleaseSeries{Major: v.Major(), Minor: v.Minor(), Contract: contract}
+	}
+
+	metadata := &clusterctlv1.Metadata{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clusterctlv1.GroupVersion.String(),
+			Kind:       "Metadata",
+		},
+	}
+	for _, s := range series {
+		metadata.ReleaseSeries = append(metadata.ReleaseSeries, s)
+	}
+	sort.Slice(metadata.ReleaseSeries, func(i, j int) bool {
+		a, b := metadata.ReleaseSeries[i], metadata.ReleaseSeries[j]
+		if a.Major != b.Major {
+			return a.Major < b.Major
+		}
+		return a.Minor < b.Minor
+	})
+	return metadata, nil
+}
+
+// sourceTreeMetadata returns the path of the metadata.yaml in the source tree of a local kustomization root, looking
+// into the kustomization root and its parent folders up to the root of the checkout; if there is no such file, an
+// empty string is returned.
+func sourceTreeMetadata(version config.ComponentSource) string {
+	if version.Type != config.KustomizeSource {
+		return ""
+	}
+	if info, err := os.Stat(version.Value); err != nil || !info.IsDir() {
+		return ""
+	}
+	dir, err := filepath.Abs(version.Value)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, metadataFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// validateCAPIRepository checks that the local repository has the layout expected by clusterctl, that is at least
+// one version for each provider, and components.yaml and metadata.yaml for each provider version, or only for the selected versions if onlySelected is
+// true, with the release series of the version defined in metadata.yaml; all the errors found are returned as an
+// aggregate.
+func validateCAPIRepository(repositoryPath string, providers []config.ProviderConfig, versions map[string]string, onlySelected bool) error {
+	log := logf.Log
+	errList := []error{}
+	for _, provider := range providers {
+		providerLabel := clusterctlv1.ManifestLabel(provider.Name, clusterctlv1.ProviderType(provider.Type))
+		if len(provider.Versions) == 0 {
+			errList = append(errList, errors.Errorf("no versions defined for %q", providerLabel))
+			continue
+		}
+		for _, version := range provider.Versions {
+			if onlySelected && versions[providerLabel] != version.Name {
+				continue
+			}
+			versionPath := filepath.Join(repositoryPath, providerLabel, version.Name)
+			if _, err := os.Stat(filepath.Join(versionPath, componentsFileName)); err != nil {
+				errList = append(errList, errors.Errorf("%s not found for %q / %q", componentsFileName, providerLabel, version.Name))
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(versionPath, metadataFileName))
+			if err != nil {
+				errList = append(errList, errors.Errorf("%s not found for %q / %q", metadataFileName, providerLabel, version.Name))
+				continue
+			}
+			metadata := &clusterctlv1.Metadata{}
+			if err := yaml.Unmarshal(data, metadata); err != nil {
+				errList = append(errList, errors.Wrapf(err, "error parsing %s for %q / %q", metadataFileName, providerLabel, version.Name))
+				continue
+			}
+			v, err := utilversion.ParseSemantic(version.Name)
+			if err != nil {
+				errList = append(errList, errors.Errorf("version %q of %q is not a semantic version, as required by clusterctl", version.Name, providerLabel))
+				continue
+			}
+			found := false
+			for _, s := range metadata.ReleaseSeries {
+				if s.Major == v.Major() && s.Minor == v.Minor() {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errList = append(errList, errors.Errorf("release series v%d.%d of %q / %q is not defined in %s, please set metadata or contract for the version", v.Major(), v.Minor(), providerLabel, version.Name, metadataFileName))
+			}
+		}
+
+		if provider.Type == string(clusterctlv1.InfrastructureProviderType) {
+			defaultVersion := provider.DefaultVersion().Name
+			if version, ok := versions[providerLabel]; ok {
+				defaultVersion = version
+			}
+			defaultPath := filepath.Join(repositoryPath, providerLabel, defaultVersion)
+			templates, _ := filepath.Glob(filepath.Join(defaultPath, "cluster-template*.yaml"))
+			if len(templates) == 0 {
+				log.Info("No cluster templates in the local repository, please set clusterTemplates for using clusterctl config cluster", "Provider", providerLabel)
+			}
+		}
+	}
+	return kerrors.NewAggregate(errList)
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// writeTestFiles writes files, by path relative to dir; paths ending with / are created as folders.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const testMetadata = `apiVersion: clusterctl.cluster.x-k8s.io/v1alpha3
+kind: Metadata
+releaseSeries:
+- major: 0
+  minor: 3
+  contract: v1alpha3
+- major: 0
+  minor: 2
+  contract: v1alpha2
+`
+
+func TestSourceTreeMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metal3ctl-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"capm3/.git/":                 "",
+		"capm3/metadata.yaml":         testMetadata,
+		"capm3/config/default/":       "",
+		"root/.git/":                  "",
+		"root/config/metadata.yaml":   testMetadata,
+		"nometadata/.git/":            "",
+		"nometadata/config/default/":  "",
+		"metadata.yaml":               testMetadata,
+		"capm3/config/components.yml": "kind: Deployment\n",
+	})
+
+	tests := []struct {
+		name    string
+		version config.ComponentSource
+		want    string
+	}{
+		{
+			name:    "metadata.yaml in a parent folder of the kustomization root",
+			version: config.ComponentSource{Type: config.KustomizeSource, Value: filepath.Join(dir, "capm3", "config", "default")},
+			want:    filepath.Join(dir, "capm3", "metadata.yaml"),
+		},
+		{
+			name:    "metadata.yaml in the kustomization root",
+			version: config.ComponentSource{Type: config.KustomizeSource, Value: filepath.Join(dir, "root", "config")},
+			want:    filepath.Join(dir, "root", "config", "metadata.yaml"),
+		},
+		{
+			name:    "the search stops at the root of the checkout",
+			version: config.ComponentSource{Type: config.KustomizeSource, Value: filepath.Join(dir, "nometadata", "config", "default")},
+			want:    "",
+		},
+		{
+			name:    "remote kustomization root",
+			version: config.ComponentSource{Type: config.KustomizeSource, Value: "https://github.com/metal3-io/cluster-api-provider-metal3//config/default?ref=v0.3.0"},
+			want:    "",
+		},
+		{
+			name:    "url source",
+			version: config.ComponentSource{Type: config.URLSource, Value: filepath.Join(dir, "capm3", "config", "components.yml")},
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceTreeMetadata(tt.version); got != tt.want {
+				t.Errorf("got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metal3ctl-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"metadata.yaml":         testMetadata,
+		"invalid.yaml":          "releaseSeries: 0.3\n",
+		"capm3/.git/":           "",
+		"capm3/metadata.yaml":   testMetadata,
+		"capm3/config/default/": "",
+	})
+
+	tests := []struct {
+		name     string
+		versions []config.ComponentSource
+		want     []clusterctlv1.ReleaseSeries
+		wantErr  bool
+	}{
+		{
+			name:     "release series generated from the version names",
+			versions: []config.ComponentSource{{Name: "v0.3.3"}, {Name: "v0.3.2"}, {Name: "v0.4.0"}},
+			want:     []clusterctlv1.ReleaseSeries{{Major: 0, Minor: 3, Contract: "v1alpha3"}, {Major: 0, Minor: 4, Contract: "v1alpha3"}},
+		},
+		{
+			name:     "release series read from the metadata of the versions",
+			versions: []config.ComponentSource{{Name: "v0.2.0", Metadata: filepath.Join(dir, "metadata.yaml")}},
+			want:     []clusterctlv1.ReleaseSeries{{Major: 0, Minor: 2, Contract: "v1alpha2"}, {Major: 0, Minor: 3, Contract: "v1alpha3"}},
+		},
+		{
+			name:     "release series read from the source tree",
+			versions: []config.ComponentSource{{Name: "v0.2.0", Type: config.KustomizeSource, Value: filepath.Join(dir, "capm3", "config", "default")}},
+			want:     []clusterctlv1.ReleaseSeries{{Major: 0, Minor: 2, Contract: "v1alpha2"}, {Major: 0, Minor: 3, Contract: "v1alpha3"}},
+		},
+		{
+			name:     "the contract of a version takes precedence",
+			versions: []config.ComponentSource{{Name: "v0.2.0", Metadata: filepath.Join(dir, "metadata.yaml"), Contract: "v1alpha3"}},
+			want:     []clusterctlv1.ReleaseSeries{{Major: 0, Minor: 2, Contract: "v1alpha3"}, {Major: 0, Minor: 3, Contract: "v1alpha3"}},
+		},
+		{
+			name:     "versions not semantic are skipped",
+			versions: []config.ComponentSource{{Name: "master"}, {Name: "v0.3.0"}},
+			want:     []clusterctlv1.ReleaseSeries{{Major: 0, Minor: 3, Contract: "v1alpha3"}},
+		},
+		{
+			name:     "missing metadata",
+			versions: []config.ComponentSource{{Name: "v0.3.0", Metadata: filepath.Join(dir, "missing.yaml")}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid metadata",
+			versions: []config.ComponentSource{{Name: "v0.3.0", Metadata: filepath.Join(dir, "invalid.yaml")}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := providerMetadata(config.ProviderConfig{Name: "metal3", Type: "InfrastructureProvider", Versions: tt.versions})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Kind != "Metadata" || got.APIVersion != clusterctlv1.GroupVersion.String() {
+				t.Errorf("got = %s/%s, want %s/Metadata", got.APIVersion, got.Kind, clusterctlv1.GroupVersion.String())
+			}
+			if !reflect.DeepEqual(got.ReleaseSeries, tt.want) {
+				t.Errorf("got = %v, want %v", got.ReleaseSeries, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCAPIRepository(t *testing.T) {
+	provider := config.ProviderConfig{
+		Name: "metal3",
+		Type: "InfrastructureProvider",
+		Versions: []config.ComponentSource{
+			{Name: "v0.3.0"},
+			{Name: "v0.2.0"},
+		},
+	}
+	tests := []struct {
+		name         string
+		files        map[string]string
+		provider     config.ProviderConfig
+		onlySelected bool
+		wantErr      []string
+	}{
+		{
+			name: "all the versions are in the repository",
+			files: map[string]string{
+				"infrastructure-metal3/v0.3.0/components.yaml": "kind: Deployment\n",
+				"infrastructure-metal3/v0.3.0/metadata.yaml":   testMetadata,
+				"infrastructure-metal3/v0.2.0/components.yaml": "kind: Deployment\n",
+				"infrastructure-metal3/v0.2.0/metadata.yaml":   testMetadata,
+			},
+			provider: provider,
+		},
+		{
+			name: "only the selected versions are checked",
+			files: map[string]string{
+				"infrastructure-metal3/v0.2.0/components.yaml": "kind: Deployment\n",
+				"infrastructure-metal3/v0.2.0/metadata.yaml":   testMetadata,
+			},
+			provider:     provider,
+			onlySelected: true,
+		},
+		{
+			name: "missing files are reported",
+			files: map[string]string{
+				"infrastructure-metal3/v0.3.0/metadata.yaml":   testMetadata,
+				"infrastructure-metal3/v0.2.0/components.yaml": "kind: Deployment\n",
+			},
+			provider: provider,
+			wantErr: []string{
+				`components.yaml not found for "infrastructure-metal3" / "v0.3.0"`,
+				`metadata.yaml not found for "infrastructure-metal3" / "v0.2.0"`,
+			},
+		},
+		{
+			name: "release series missing in the metadata",
+			files: map[string]string{
+				"infrastructure-metal3/v0.4.0/components.yaml": "kind: Deployment\n",
+				"infrastructure-metal3/v0.4.0/metadata.yaml":   testMetadata,
+			},
+			provider: config.ProviderConfig{Name: "metal3", Type: "InfrastructureProvider", Versions: []config.ComponentSource{{Name: "v0.4.0"}}},
+			wantErr:  []string{`release series v0.4 of "infrastructure-metal3" / "v0.4.0" is not defined in metadata.yaml`},
+		},
+		{
+			name: "versions must be semantic versions",
+			files: map[string]string{
+				"infrastructure-metal3/master/components.yaml": "kind: Deployment\n",
+				"infrastructure-metal3/master/metadata.yaml":   testMetadata,
+			},
+			provider: config.ProviderConfig{Name: "metal3", Type: "InfrastructureProvider", Versions: []config.ComponentSource{{Name: "master"}}},
+			wantErr:  []string{`version "master" of "infrastructure-metal3" is not a semantic version`},
+		},
+		{
+			name:     "providers must have at least one version",
+			provider: config.ProviderConfig{Name: "metal3", Type: "InfrastructureProvider"},
+			wantErr:  []string{`no versions defined for "infrastructure-metal3"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "metal3ctl-repository")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeTestFiles(t, dir, tt.files)
+
+			versions := map[string]string{"infrastructure-metal3": "v0.2.0"}
+			err = validateCAPIRepository(dir, []config.ProviderConfig{tt.provider}, versions, tt.onlySelected)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			for _, e := range tt.wantErr {
+				if !strings.Contains(err.Error(), e) {
+					t.Errorf("error = %v, should contain %v", err, e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This program generates zz_generated.scaffold.go from examples/metal3ctl.dev.conf, so the config generated by
+// metal3ctl config init is the example config; it is run by go generate.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	examplePath = "../../examples/metal3ctl.dev.conf"
+	outputPath  = "zz_generated.scaffold.go"
+)
+
+const header = `/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by scaffold_generate.go from examples/metal3ctl.dev.conf. DO NOT EDIT.
+
+package cluster
+`
+
+func main() {
+	data, err := ioutil.ReadFile(examplePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading %s: %v\n", examplePath, err)
+		os.Exit(1)
+	}
+	if strings.Contains(string(data), "`") {
+		fmt.Fprintf(os.Stderr, "%s can't contain backquotes\n", examplePath)
+		os.Exit(1)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(header)
+	fmt.Fprintf(&b, "\n// scaffoldConfig is the example config used by ScaffoldConfig, which replaces its context and the paths and the versions of the local checkouts.\n")
+	fmt.Fprintf(&b, "const scaffoldConfig = `%s`\n", data)
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error formatting %s: %v\n", outputPath, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing %s: %v\n", outputPath, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	"github.com/pkg/errors"
+	apicorev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveSecretVariables returns the values of the secret variables defined in the metal3ctl config; Secrets are read
+// from the mgmt cluster, so the client is created only if a variable references a Secret.
+func resolveSecretVariables(ctx context.Context, conf *config.Metal3CtlConfig) (map[string]string, error) {
+	if len(conf.SecretVariables) == 0 {
+		return map[string]string{}, nil
+	}
+	var c client.Client
+	return conf.ResolveSecretVariables(ctx, func(ctx context.Context, namespace, name, key string) (string, error) {
+		if c == nil {
+			var err error
+			c, err = proxy.NewProxy(conf.Kubeconfig).NewClient()
+			if err != nil {
+				return "", errors.Wrap(err, "failed to create controller-runtime client")
+			}
+		}
+		secret := &apicorev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+			return "", err
+		}
+		value, ok := secret.Data[key]
+		if !ok {
+			return "", errors.Errorf("key %q not found", key)
+		}
+		return string(value), nil
+	})
+}
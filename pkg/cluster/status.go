@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// ProviderStatus describes a provider instance installed in the mgmt cluster.
+type ProviderStatus struct {
+	Name      string
+	Type      string
+	Namespace string
+	Version   string
+
+	// WatchingNamespace is the namespace watched by the provider instance; empty means all namespaces.
+	WatchingNamespace string
+}
+
+// Status returns the BMO instances and the CAPI providers recorded in the clusterctl provider inventory of the mgmt
+// cluster; BMO instances are listed first, sorted by namespace. BMO inventory records left hidden by an interrupted
+// clusterctl operation are restored first.
+func Status(input config.LoadMetal3CtlConfigInput) ([]ProviderStatus, error) {
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	cleanup, err := useKubeContext(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	c, err := proxy.NewProxy(conf.Kubeconfig).NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create controller-runtime client")
+	}
+
+	if err := restoreHiddenBMOInventory(ctx, c, conf.ArtifactsPath); err != nil {
+		return nil, err
+	}
+
+	statuses := []ProviderStatus{}
+	bmoInventories, err := listBMOInventory(ctx, c, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, inventory := range bmoInventories {
+		statuses = append(statuses, ProviderStatus{
+			Name:              inventory.Name,
+			Type:              inventory.Type,
+			Namespace:         inventory.Namespace,
+			Version:           inventory.Version,
+			WatchingNamespace: inventory.WatchingNamespace,
+		})
+	}
+
+	providers := &clusterctlv1.ProviderList{}
+	if err := c.List(ctx, providers); err != nil && !meta.IsNoMatchError(err) {
+		return nil, errors.Wrap(err, "failed to list the clusterctl inventory")
+	}
+	sort.SliceStable(providers.Items, func(i, j int) bool {
+		return providerTypeOrder[providers.Items[i].Type] < providerTypeOrder[providers.Items[j].Type]
+	})
+	for _, provider := range providers.Items {
+		if isBMOInventory(provider) {
+			continue
+		}
+		statuses = append(statuses, ProviderStatus{
+			Name:              provider.ProviderName,
+			Type:              provider.Type,
+			Namespace:         provider.Namespace,
+			Version:           provider.Version,
+			WatchingNamespace: provider.WatchedNamespace,
+		})
+	}
+	return statuses, nil
+}
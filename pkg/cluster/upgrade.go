@@ -0,0 +1,406 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/Arvinderpal/metal3ctl/pkg/internal/proxy"
+	bmh "github.com/metal3-io/baremetal-operator/pkg/apis/metal3/v1alpha1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/version"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	clusterctlclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// upgradePausedValue is the value of the paused annotation set on the BareMetalHosts during a BMO upgrade; hosts
+// paused for other reasons are left untouched.
+const upgradePausedValue = "metal3ctl-upgrade"
+
+// UpgradeOptions carries the options supported by PlanUpgrade and ApplyUpgrade.
+type UpgradeOptions struct {
+	SkipBMO  bool
+	SkipCAPI bool
+
+	// BMOVersion is the baremetal-operator version to upgrade to, as listed in bmoProvider.versions.
+	// Defaults to the version marked as default, or the highest one.
+	BMOVersion string
+
+	// Contract is the API Version of Cluster API (contract) the CAPI providers should be upgraded to.
+	// Defaults to the current contract.
+	Contract string
+}
+
+// UpgradePlanItem describes the upgrade of a provider installed in the mgmt cluster.
+type UpgradePlanItem struct {
+	Name           string
+	Type           string
+	Namespace      string
+	Contract       string
+	CurrentVersion string
+	// NextVersion is empty if the provider is already up to date.
+	NextVersion string
+}
+
+// PlanUpgrade compares the provider versions installed in the mgmt cluster with the versions available in the
+// local repository.
+func PlanUpgrade(input config.LoadMetal3CtlConfigInput, options *UpgradeOptions) ([]UpgradePlanItem, error) {
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	cleanup, err := useKubeContext(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	items := []UpgradePlanItem{}
+	if !options.SkipBMO {
+		bmoItems, err := planBMOUpgrade(ctx, conf, options)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, bmoItems...)
+	}
+
+	if !options.SkipCAPI {
+		cctlClient, err := newUpgradeClusterctlClient(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		var plans []clusterctlclient.UpgradePlan
+		err = withoutBMOInventory(ctx, conf, func() error {
+			plans, err = cctlClient.PlanUpgrade(clusterctlclient.PlanUpgradeOptions{
+				Kubeconfig: conf.Kubeconfig,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to plan the cluster-api providers upgrade")
+		}
+		for _, plan := range plans {
+			for _, provider := range plan.Providers {
+				items = append(items, UpgradePlanItem{
+					Name:           provider.ProviderName,
+					Type:           provider.Type,
+					Namespace:      provider.Namespace,
+					Contract:       plan.Contract,
+					CurrentVersion: provider.Version,
+					NextVersion:    provider.NextVersion,
+				})
+			}
+		}
+	}
+	return items, nil
+}
+
+// planBMOUpgrade returns an upgrade plan item for each BMO instance.
+func planBMOUpgrade(ctx context.Context, conf *config.Metal3CtlConfig, options *UpgradeOptions) ([]UpgradePlanItem, error) {
+	version, err := conf.BMOProvider.GetVersion(options.BMOVersion)
+	if err != nil {
+		return nil, err
+	}
+	c, err := proxy.NewProxy(conf.Kubeconfig).NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create controller-runtime client")
+	}
+
+	instances, err := installedBMOInstances(ctx, c, conf.BMOProvider)
+	if err != nil {
+		return nil, err
+	}
+	items := []UpgradePlanItem{}
+	for _, instance := range instances {
+		installed, err := installedBMOVersion(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+		if err != nil {
+			return nil, err
+		}
+		if installed == "" {
+			return nil, errors.Errorf("%s is not installed in the mgmt cluster, please use metal3ctl init", bmoInstanceName(conf.BMOProvider, instance))
+		}
+		objs, err := installedBMOComponents(ctx, c, conf, instance)
+		if err != nil {
+			return nil, err
+		}
+
+		item := UpgradePlanItem{
+			Name:           conf.BMOProvider.Name,
+			Type:           conf.BMOProvider.Type,
+			Namespace:      bmoNamespace(objs),
+			CurrentVersion: installed,
+		}
+		inventory, err := getBMOInventory(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+		if err != nil {
+			return nil, err
+		}
+		if inventory != nil {
+			item.Namespace = inventory.Namespace
+		}
+		needed, err := bmoUpgradeNeeded(installed, version.Name, options.BMOVersion != "")
+		if err != nil {
+			return nil, err
+		}
+		if needed {
+			item.NextVersion = version.Name
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ApplyUpgrade upgrades the providers installed in the mgmt cluster. CAPI providers are upgraded using clusterctl;
+// BMO is upgraded to newer versions only, by pausing all the BareMetalHosts, replacing the BMO components and deleting
+// the obsolete ones, waiting for BMO to be ready and finally unpausing the BareMetalHosts.
+func ApplyUpgrade(input config.LoadMetal3CtlConfigInput, options *UpgradeOptions) error {
+	ctx := context.TODO()
+	conf, err := config.LoadMetal3CtlConfig(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "error loading metal3ctl config file")
+	}
+	cleanup, err := useKubeContext(conf)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if !options.SkipBMO {
+		if err := upgradeBMOComponents(ctx, conf, options.BMOVersion); err != nil {
+			return errors.Wrapf(err, "error upgrading baremetal-operator components")
+		}
+	}
+
+	if !options.SkipCAPI {
+		cctlClient, err := newUpgradeClusterctlClient(ctx, conf)
+		if err != nil {
+			return err
+		}
+		contract := options.Contract
+		if contract == "" {
+			contract = clusterv1.GroupVersion.Version
+		}
+		return withoutBMOInventory(ctx, conf, func() error {
+			plans, err := cctlClient.PlanUpgrade(clusterctlclient.PlanUpgradeOptions{
+				Kubeconfig: conf.Kubeconfig,
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to plan the cluster-api providers upgrade")
+			}
+			if len(plans) == 0 {
+				return errors.New("failed to find the cluster-api providers installed in the mgmt cluster, please use metal3ctl init")
+			}
+			if err := cctlClient.ApplyUpgrade(clusterctlclient.ApplyUpgradeOptions{
+				Kubeconfig:      conf.Kubeconfig,
+				ManagementGroup: plans[0].CoreProvider.InstanceName(),
+				Contract:        contract,
+			}); err != nil {
+				return errors.Wrap(err, "failed to run clusterctl upgrade")
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func newUpgradeClusterctlClient(ctx context.Context, conf *config.Metal3CtlConfig) (clusterctlclient.Client, error) {
+	// The local repository is created again, so it includes all the versions currently defined in the config file.
+	clusterctlConfig, err := CreateCAPIRepository(ctx, CreateCAPIRepositoryInput{
+		config:        conf,
+		artifactsPath: conf.ArtifactsPath,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating local cluster-api repository")
+	}
+	cctlClient, err := clusterctlConfig.NewClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating clusterctl client")
+	}
+	return cctlClient, nil
+}
+
+// upgradeBMOComponents replaces the installed BMO version with the given one, for all the BMO instances.
+func upgradeBMOComponents(ctx context.Context, conf *config.Metal3CtlConfig, versionName string) error {
+	version, err := conf.BMOProvider.GetVersion(versionName)
+	if err != nil {
+		return err
+	}
+
+	p := proxy.NewProxy(conf.Kubeconfig)
+	c, err := p.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create controller-runtime client")
+	}
+	instances, err := installedBMOInstances(ctx, c, conf.BMOProvider)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if err := upgradeBMOInstance(ctx, conf, p, c, version, instance, versionName != ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bmoUpgradeNeeded returns true if the installed BMO version should be replaced with the target version: only newer
+// semantic versions are upgrades, and older ones are rejected if selected explicitly. Versions which are not semantic
+// versions can't be compared, so they are replaced only if selected explicitly.
+func bmoUpgradeNeeded(installed, target string, explicit bool) (bool, error) {
+	if installed == target {
+		return false, nil
+	}
+	installedVersion, installedErr := version.ParseSemantic(installed)
+	targetVersion, targetErr := version.ParseSemantic(target)
+	if installedErr != nil || targetErr != nil {
+		return explicit, nil
+	}
+	if targetVersion.LessThan(installedVersion) {
+		if explicit {
+			return false, errors.Errorf("version %s is older than the installed version %s, downgrades are not supported", target, installed)
+		}
+		return false, nil
+	}
+	return installedVersion.LessThan(targetVersion), nil
+}
+
+// upgradeBMOInstance replaces the BMO version installed for a BMO instance with the given one, if it is newer (see
+// bmoUpgradeNeeded); only the BareMetalHosts watched by the instance are paused, and they are unpaused even if the
+// upgrade fails, so they are reconciled again by the BMO running in the mgmt cluster.
+func upgradeBMOInstance(ctx context.Context, conf *config.Metal3CtlConfig, p *proxy.Proxy, c client.Client, version config.ComponentSource, instance config.BMOInstance, explicit bool) (reterr error) {
+	log := logf.Log
+	installed, err := installedBMOVersion(ctx, c, conf.BMOProvider, instance.TargetNamespace)
+	if err != nil {
+		return err
+	}
+	if installed == "" {
+		return errors.Errorf("%s is not installed in the mgmt cluster, please use metal3ctl init", bmoInstanceName(conf.BMOProvider, instance))
+	}
+	needed, err := bmoUpgradeNeeded(installed, version.Name, explicit)
+	if err != nil {
+		return err
+	}
+	if !needed {
+		log.Info("Already up to date", "Provider", bmoInstanceName(conf.BMOProvider, instance), "CurrentVersion", installed)
+		return nil
+	}
+	currentObjs, err := installedBMOComponents(ctx, c, conf, instance)
+	if err != nil {
+		return err
+	}
+
+	bmoConfig, objs, err := generateBMOComponents(ctx, conf, version, instance)
+	if err != nil {
+		return err
+	}
+	if err := keepIronicCredentials(ctx, c, objs); err != nil {
+		return err
+	}
+
+	watchingNamespace := bmoWatchingNamespace(objs)
+	log.Info("Upgrading", "Provider", conf.BMOProvider.Name, "Namespace", bmoNamespace(objs), "CurrentVersion", installed, "TargetVersion", version.Name)
+	// The hosts are unpaused also if pausing fails half way, or if the upgrade fails; running upgrade apply again
+	// completes a failed upgrade.
+	defer func() {
+		if err := pauseBareMetalHosts(ctx, c, watchingNamespace, false); err != nil {
+			if reterr == nil {
+				reterr = err
+				return
+			}
+			log.Info("Failed to unpause the BareMetalHosts, please remove the paused annotation manually", "Namespace", watchingNamespace, "Value", upgradePausedValue, "Error", err.Error())
+		}
+	}()
+	if err := pauseBareMetalHosts(ctx, c, watchingNamespace, true); err != nil {
+		return err
+	}
+
+	if err := createComponents(ctx, p, objs); err != nil {
+		return errors.Wrapf(err, "failed to update bmo components in mgmt cluster")
+	}
+	if err := deleteComponents(ctx, p, obsoleteObjects(currentObjs, objs)); err != nil {
+		return errors.Wrapf(err, "failed to delete obsolete bmo components in mgmt cluster")
+	}
+	if err := waitForProvider(ctx, c, conf.BMOProvider, instance.TargetNamespace, objs); err != nil {
+		return errors.Wrap(err, "error waiting for bmo components")
+	}
+
+	if err := writeBMOInventory(ctx, p, conf.BMOProvider, instance, version.Name, bmoNamespace(objs), watchingNamespace); err != nil {
+		return errors.Wrap(err, "failed to record bmo in the inventory")
+	}
+	return writeBMORepository(conf, instance, version, objs, bmoConfig)
+}
+
+// obsoleteObjects returns the objects in current which are not in desired; CRDs and namespaces are never considered
+// obsolete, because deleting them would delete all the objects they contain.
+func obsoleteObjects(current, desired []unstructured.Unstructured) []unstructured.Unstructured {
+	type objKey struct {
+		kind      string
+		namespace string
+		name      string
+	}
+	desiredKeys := map[objKey]bool{}
+	for _, obj := range desired {
+		desiredKeys[objKey{kind: obj.GroupVersionKind().GroupKind().String(), namespace: obj.GetNamespace(), name: obj.GetName()}] = true
+	}
+
+	obsolete := []unstructured.Unstructured{}
+	for _, obj := range current {
+		switch obj.GetKind() {
+		case "CustomResourceDefinition", "Namespace":
+			continue
+		}
+		if !desiredKeys[objKey{kind: obj.GroupVersionKind().GroupKind().String(), namespace: obj.GetNamespace(), name: obj.GetName()}] {
+			obsolete = append(obsolete, obj)
+		}
+	}
+	return obsolete
+}
+
+// pauseBareMetalHosts pauses, or unpauses, all the BareMetalHosts in the given namespace, or in the mgmt cluster if
+// namespace is empty; when unpausing, only the hosts paused by pauseBareMetalHosts are unpaused.
+func pauseBareMetalHosts(ctx context.Context, c client.Client, namespace string, pause bool) error {
+	log := logf.Log
+	hosts := &bmh.BareMetalHostList{}
+	if err := c.List(ctx, hosts, client.InNamespace(namespace)); err != nil {
+		return errors.Wrap(err, "failed to list BMH objects")
+	}
+	for i := range hosts.Items {
+		host := &hosts.Items[i]
+		value, paused := host.Annotations[bmh.PausedAnnotation]
+		switch {
+		case pause && !paused:
+			if host.Annotations == nil {
+				host.Annotations = map[string]string{}
+			}
+			host.Annotations[bmh.PausedAnnotation] = upgradePausedValue
+			log.V(3).Info("Pausing", "BareMetalHost", host.Name, "Namespace", host.Namespace)
+		case !pause && paused && value == upgradePausedValue:
+			delete(host.Annotations, bmh.PausedAnnotation)
+			log.V(3).Info("Unpausing", "BareMetalHost", host.Name, "Namespace", host.Namespace)
+		default:
+			continue
+		}
+		if err := c.Update(ctx, host); err != nil {
+			return errors.Wrapf(err, "failed to update BMH %s/%s", host.Namespace, host.Name)
+		}
+	}
+	return nil
+}
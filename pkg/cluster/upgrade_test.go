@@ -0,0 +1,51 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"testing"
+)
+
+func TestBMOUpgradeNeeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed string
+		target    string
+		explicit  bool
+		want      bool
+		wantErr   bool
+	}{
+		{name: "newer version", installed: "v0.1.0", target: "v0.2.0", want: true},
+		{name: "same version", installed: "v0.2.0", target: "v0.2.0", want: false},
+		{name: "older default version is not proposed", installed: "v0.2.0", target: "v0.1.0", want: false},
+		{name: "older version selected explicitly is rejected", installed: "v0.2.0", target: "v0.1.0", explicit: true, wantErr: true},
+		{name: "release after a pre-release", installed: "v0.2.0-rc.1", target: "v0.2.0", want: true},
+		{name: "versions which can't be compared", installed: "master", target: "v0.2.0", want: false},
+		{name: "versions which can't be compared, selected explicitly", installed: "master", target: "v0.2.0", explicit: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bmoUpgradeNeeded(tt.installed, tt.target, tt.explicit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bmoUpgradeNeeded() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("bmoUpgradeNeeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	logf "sigs.k8s.io/cluster-api/cmd/clusterctl/log"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	waiterInterval = 5 * time.Second
+	waiterTimeout  = 5 * time.Minute
+)
+
+// waitForProvider runs all the waiters defined for a provider; waiters for Ironic components are skipped
+// when the provider uses an external Ironic. If the objects installed for the provider are known, the deployment
+// waiters for Deployments which are not part of them are skipped as well, so waiters for Ironic components are
+// detected even if they are not marked as ironic.
+func waitForProvider(ctx context.Context, c client.Client, provider config.ProviderConfig, targetNamespace string, objs []unstructured.Unstructured) error {
+	log := logf.Log
+	for _, waiter := range provider.Waiters {
+		if waiter.Ironic && provider.Ironic.IsExternal() {
+			log.V(3).Info("Skipping waiter for Ironic components, using an external Ironic", "Name", waiter.Name)
+			continue
+		}
+		if objs != nil && !isWaiterTargetInstalled(waiter, objs) {
+			log.V(3).Info("Skipping waiter, the object is not part of the installed components", "Type", waiter.Type, "Name", waiter.Name)
+			continue
+		}
+
+		namespace := waiter.Namespace
+		if namespace == "" {
+			namespace = waiter.DefaultNamespace
+			if targetNamespace != "" {
+				namespace = targetNamespace
+			}
+		}
+
+		log.Info("Waiting for", "Type", waiter.Type, "Namespace", namespace, "Name", waiter.Name)
+		var check func() (bool, error)
+		switch waiter.Type {
+		case config.DeploymentWaiter:
+			check = func() (bool, error) { return isDeploymentReady(ctx, c, namespace, waiter.Name) }
+		case config.ApiServiceWaiter:
+			check = func() (bool, error) { return isAPIServiceAvailable(ctx, c, waiter.Name) }
+		default:
+			return errors.Errorf("invalid waiter type %q", waiter.Type)
+		}
+		if err := wait.PollImmediate(waiterInterval, waiterTimeout, check); err != nil {
+			return errors.Wrapf(err, "error waiting for %s %q", waiter.Type, waiter.Name)
+		}
+	}
+	return nil
+}
+
+// isWaiterTargetInstalled returns false if the waiter checks a Deployment that is not one of the given objects, e.g.
+// the Ironic Deployment when the baremetal-operator uses an external Ironic and the manifest does not include it.
+func isWaiterTargetInstalled(waiter config.ProviderWaiter, objs []unstructured.Unstructured) bool {
+	if waiter.Type != config.DeploymentWaiter {
+		return true
+	}
+	for _, obj := range objs {
+		if obj.GetKind() == "Deployment" && obj.GetName() == waiter.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func isDeploymentReady(ctx context.Context, c client.Client, namespace, name string) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas >= replicas &&
+		deployment.Status.AvailableReplicas >= replicas, nil
+}
+
+func isAPIServiceAvailable(ctx context.Context, c client.Client, name string) (bool, error) {
+	apiService := &unstructured.Unstructured{}
+	apiService.SetGroupVersionKind(schema.GroupVersionKind{Group: "apiregistration.k8s.io", Version: "v1", Kind: "APIService"})
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, apiService); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	conditions, _, err := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	if err != nil {
+		return false, err
+	}
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Available" && condition["status"] == "True" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/Arvinderpal/metal3ctl/config"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIsWaiterTargetInstalled(t *testing.T) {
+	deployment := unstructured.Unstructured{}
+	deployment.SetKind("Deployment")
+	deployment.SetName("metal3-baremetal-operator")
+	objs := []unstructured.Unstructured{deployment}
+
+	tests := []struct {
+		name   string
+		waiter config.ProviderWaiter
+		want   bool
+	}{
+		{
+			name:   "installed deployment",
+			waiter: config.ProviderWaiter{Type: config.DeploymentWaiter, Name: "metal3-baremetal-operator"},
+			want:   true,
+		},
+		{
+			name:   "deployment not part of the manifest, e.g. Ironic with an external Ironic",
+			waiter: config.ProviderWaiter{Type: config.DeploymentWaiter, Name: "metal3-ironic"},
+			want:   false,
+		},
+		{
+			name:   "apiservice waiters are always run",
+			waiter: config.ProviderWaiter{Type: config.ApiServiceWaiter, Name: "v1beta1.metrics.k8s.io"},
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isWaiterTargetInstalled(tt.waiter, objs); got != tt.want {
+				t.Errorf("isWaiterTargetInstalled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
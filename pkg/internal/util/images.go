@@ -0,0 +1,109 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// podSpecPaths maps the kinds embedding a pod spec to the path of the pod spec inside the object.
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// InspectImages identifies the container images used by the containers and init containers defined in the objects.
+func InspectImages(objs []unstructured.Unstructured) ([]string, error) {
+	images := []string{}
+	seen := map[string]bool{}
+	err := walkImages(objs, func(image string) (string, error) {
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+		return image, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// FixImages alters the images used by the containers and init containers defined in the objects
+// using the given alterImageFunc.
+func FixImages(objs []unstructured.Unstructured, alterImageFunc func(image string) (string, error)) error {
+	return walkImages(objs, alterImageFunc)
+}
+
+func walkImages(objs []unstructured.Unstructured, f func(image string) (string, error)) error {
+	return VisitContainers(objs, func(obj *unstructured.Unstructured, _, container map[string]interface{}) error {
+		image, ok := container["image"].(string)
+		if !ok || image == "" {
+			return nil
+		}
+		newImage, err := f(image)
+		if err != nil {
+			return errors.Wrapf(err, "failed to process image %q in %s, %s/%s", image, obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
+		}
+		container["image"] = newImage
+		return nil
+	})
+}
+
+// VisitContainers calls f for all the containers and init containers defined in the objects, passing the pod spec
+// and the container; changes made by f to the pod spec or to the container are stored back into the object.
+func VisitContainers(objs []unstructured.Unstructured, f func(obj *unstructured.Unstructured, podSpec, container map[string]interface{}) error) error {
+	for i := range objs {
+		obj := &objs[i]
+		podSpecPath, ok := podSpecPaths[obj.GetKind()]
+		if !ok {
+			continue
+		}
+		podSpec, found, err := unstructured.NestedMap(obj.Object, podSpecPath...)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read the pod spec in %s, %s/%s", obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
+		}
+		if !found {
+			continue
+		}
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, ok := podSpec[field].([]interface{})
+			if !ok {
+				continue
+			}
+			for j := range containers {
+				container, ok := containers[j].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if err := f(obj, podSpec, container); err != nil {
+					return err
+				}
+			}
+		}
+		if err := unstructured.SetNestedMap(obj.Object, podSpec, podSpecPath...); err != nil {
+			return errors.Wrapf(err, "failed to set the pod spec in %s, %s/%s", obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// clusterRoleKinds are the cluster wide RBAC and webhook objects, which get a name prefix when several instances of
+// a component are installed in the same cluster.
+var clusterRoleKinds = map[string]bool{
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"MutatingWebhookConfiguration":   true,
+	"ValidatingWebhookConfiguration": true,
+}
+
+// FixTargetNamespace moves the objects to the target namespace: the Namespace object is renamed, the namespaced objects
+// are moved into it, and the namespace is updated in the RBAC subjects and in the webhook services. If prefix is true,
+// the names of the cluster wide RBAC and webhook objects are prefixed with the target namespace, so several instances
+// of a component can be installed in the same cluster; CRDs are shared and left untouched.
+func FixTargetNamespace(objs []unstructured.Unstructured, targetNamespace string, prefix bool) []unstructured.Unstructured {
+	prefixed := func(name string) string {
+		if !prefix || strings.HasPrefix(name, targetNamespace+"-") {
+			return name
+		}
+		return targetNamespace + "-" + name
+	}
+
+	ret := []unstructured.Unstructured{}
+	hasNamespace := false
+	for _, obj := range objs {
+		obj = *obj.DeepCopy()
+		switch obj.GetKind() {
+		case "Namespace":
+			// All the namespaces defined in the manifest are merged into the target one.
+			if hasNamespace {
+				continue
+			}
+			hasNamespace = true
+			obj.SetName(targetNamespace)
+		case "ClusterRoleBinding", "RoleBinding":
+			fixSubjects(&obj, targetNamespace)
+			if kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); kind == "ClusterRole" {
+				name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+				_ = unstructured.SetNestedField(obj.Object, prefixed(name), "roleRef", "name")
+			}
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			fixWebhookServices(&obj, targetNamespace)
+		}
+		if clusterRoleKinds[obj.GetKind()] {
+			obj.SetName(prefixed(obj.GetName()))
+		}
+		if obj.GetNamespace() != "" {
+			obj.SetNamespace(targetNamespace)
+		}
+		ret = append(ret, obj)
+	}
+	return ret
+}
+
+// fixSubjects sets the namespace of the namespaced subjects of a binding.
+func fixSubjects(obj *unstructured.Unstructured, namespace string) {
+	subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+	for i := range subjects {
+		subject, ok := subjects[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := subject["namespace"]; ok {
+			subject["namespace"] = namespace
+		}
+	}
+	if subjects != nil {
+		_ = unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+	}
+}
+
+// fixWebhookServices sets the namespace of the services called by a webhook configuration.
+func fixWebhookServices(obj *unstructured.Unstructured, namespace string) {
+	webhooks, _, _ := unstructured.NestedSlice(obj.Object, "webhooks")
+	for i := range webhooks {
+		webhook, ok := webhooks[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, found, _ := unstructured.NestedMap(webhook, "clientConfig", "service"); found {
+			_ = unstructured.SetNestedField(webhook, namespace, "clientConfig", "service", "namespace")
+		}
+	}
+	if webhooks != nil {
+		_ = unstructured.SetNestedSlice(obj.Object, webhooks, "webhooks")
+	}
+}
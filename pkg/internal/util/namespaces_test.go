@@ -0,0 +1,135 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestFixTargetNamespace(t *testing.T) {
+	rawyaml := []byte("apiVersion: v1\n" +
+		"kind: Namespace\n" +
+		"metadata:\n" +
+		"  name: metal3\n" +
+		"---\n" +
+		"apiVersion: apps/v1\n" +
+		"kind: Deployment\n" +
+		"metadata:\n" +
+		"  name: metal3-baremetal-operator\n" +
+		"  namespace: metal3\n" +
+		"---\n" +
+		"apiVersion: rbac.authorization.k8s.io/v1\n" +
+		"kind: ClusterRole\n" +
+		"metadata:\n" +
+		"  name: manager-role\n" +
+		"---\n" +
+		"apiVersion: rbac.authorization.k8s.io/v1\n" +
+		"kind: ClusterRoleBinding\n" +
+		"metadata:\n" +
+		"  name: manager-rolebinding\n" +
+		"roleRef:\n" +
+		"  apiGroup: rbac.authorization.k8s.io\n" +
+		"  kind: ClusterRole\n" +
+		"  name: manager-role\n" +
+		"subjects:\n" +
+		"- kind: ServiceAccount\n" +
+		"  name: default\n" +
+		"  namespace: metal3\n" +
+		"---\n" +
+		"apiVersion: admissionregistration.k8s.io/v1beta1\n" +
+		"kind: ValidatingWebhookConfiguration\n" +
+		"metadata:\n" +
+		"  name: validating-webhook-configuration\n" +
+		"webhooks:\n" +
+		"- name: validation.metal3.io\n" +
+		"  clientConfig:\n" +
+		"    service:\n" +
+		"      name: webhook-service\n" +
+		"      namespace: metal3\n" +
+		"---\n" +
+		"apiVersion: apiextensions.k8s.io/v1\n" +
+		"kind: CustomResourceDefinition\n" +
+		"metadata:\n" +
+		"  name: baremetalhosts.metal3.io\n")
+
+	tests := []struct {
+		name      string
+		prefix    bool
+		wantNames []string
+	}{
+		{
+			name:   "objects are moved to the target namespace",
+			prefix: false,
+			wantNames: []string{
+				"tenant-a",
+				"metal3-baremetal-operator",
+				"manager-role",
+				"manager-rolebinding",
+				"validating-webhook-configuration",
+				"baremetalhosts.metal3.io",
+			},
+		},
+		{
+			name:   "cluster wide objects are prefixed",
+			prefix: true,
+			wantNames: []string{
+				"tenant-a",
+				"metal3-baremetal-operator",
+				"tenant-a-manager-role",
+				"tenant-a-manager-rolebinding",
+				"tenant-a-validating-webhook-configuration",
+				"baremetalhosts.metal3.io",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := ToUnstructured(rawyaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := FixTargetNamespace(objs, "tenant-a", tt.prefix)
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("got %d objects, want %d", len(got), len(tt.wantNames))
+			}
+			for i, obj := range got {
+				if obj.GetName() != tt.wantNames[i] {
+					t.Errorf("got name %q for %s, want %q", obj.GetName(), obj.GetKind(), tt.wantNames[i])
+				}
+			}
+			if ns := got[1].GetNamespace(); ns != "tenant-a" {
+				t.Errorf("got namespace %q for the Deployment, want %q", ns, "tenant-a")
+			}
+			if roleRef, _, _ := unstructured.NestedString(got[3].Object, "roleRef", "name"); roleRef != tt.wantNames[2] {
+				t.Errorf("got roleRef %q, want %q", roleRef, tt.wantNames[2])
+			}
+			subjects, _, _ := unstructured.NestedSlice(got[3].Object, "subjects")
+			if ns := subjects[0].(map[string]interface{})["namespace"]; ns != "tenant-a" {
+				t.Errorf("got subject namespace %q, want %q", ns, "tenant-a")
+			}
+			webhooks, _, _ := unstructured.NestedSlice(got[4].Object, "webhooks")
+			if ns, _, _ := unstructured.NestedString(webhooks[0].(map[string]interface{}), "clientConfig", "service", "namespace"); ns != "tenant-a" {
+				t.Errorf("got webhook service namespace %q, want %q", ns, "tenant-a")
+			}
+			if ns := objs[1].GetNamespace(); ns != "metal3" {
+				t.Errorf("the input objects were changed, got namespace %q", ns)
+			}
+		})
+	}
+}
@@ -24,3 +24,11 @@ const BMO_CONFIG_FILENAME = "bmo-config.yaml"
 func GetRepositoryPath(artifactsPath string) string {
 	return filepath.Join(artifactsPath, "repository")
 }
+
+func GetBundlePath(artifactsPath string) string {
+	return filepath.Join(artifactsPath, "bundle")
+}
+
+func GetBMORepositoryPath(artifactsPath string) string {
+	return filepath.Join(GetRepositoryPath(artifactsPath), "bmo")
+}